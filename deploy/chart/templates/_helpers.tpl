@@ -61,3 +61,13 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Whether the secrets are read, either by the opt-in secrets resource, to connect
+to the workload clusters or to probe their control plane endpoints
+*/}}
+{{- define "cluster-api-state-metrics.readSecrets" -}}
+{{- if or (has "secrets" (splitList "," .Values.config.resources)) (has "secrets" (.Values.configFile.resources | default list)) .Values.config.workloadClusterNodes .Values.config.controlPlaneProbe -}}
+true
+{{- end }}
+{{- end }}
@@ -19,7 +19,7 @@ HEADER="SPDX-License-Identifier: MIT"
 
 all_files=()
 export IFS=$'\n'
-while IFS='' read -r line; do all_error_files+=("$line"); done < <(git ls-files | grep -v -E '\.yaml$|^(go.sum|LICENSE|hack/boilerplate.go.txt|main.go|pkg/metricshandler/metrics_handler.go|pkg/options/options.go|pkg/store/builder.go|pkg/store/utils.go|pkg/store/utils_test.go)$')
+while IFS='' read -r line; do all_error_files+=("$line"); done < <(git ls-files | grep -v -E '\.yaml$|^(go.sum|LICENSE|hack/boilerplate.go.txt|main.go|pkg/metricshandler/metrics_handler.go|pkg/app/server.go|pkg/options/options.go|pkg/store/builder.go|pkg/store/metrics_store.go|pkg/store/metrics_writer.go|pkg/store/utils.go|pkg/store/utils_test.go)$')
 unset IFS
 
 errors=()
@@ -9,7 +9,17 @@ for file in config/rbac/*role.yaml
 do  
   TEMPLATE_PATH="${CHART_PATH}/templates/$(basename ${file})"
   sed -e '/^.*metadata:.*/a \ \ labels:\n\ \ \ \ {{- include "cluster-api-state-metrics.labels" . | nindent 4 }}' \
-    -e 's/^  name:[[:space:]]\+\(.\+\)$/  name: {{ include "cluster-api-state-metrics.fullname" . }}-\1/' ${file} > ${TEMPLATE_PATH}
+    -e 's/^  name:[[:space:]]\+\(.\+\)$/  name: {{ include "cluster-api-state-metrics.fullname" . }}-\1/' ${file} |
+    # the secrets are only granted if they are read, as they hold the credentials of the clusters
+    awk 'function flush() {
+        if (rule ~ /\n  - secrets\n/) printf "{{- if include \"cluster-api-state-metrics.readSecrets\" . }}\n%s{{- end }}\n", rule
+        else printf "%s", rule
+        rule = ""
+      }
+      /^- apiGroups:/ { flush(); inRules = 1 }
+      inRules { rule = rule $0 "\n"; next }
+      { print }
+      END { flush() }' > ${TEMPLATE_PATH}
 done
 
 git diff --exit-code deploy/chart/templates/  || (echo "Changes to the helm chart templates have been detected! Make sure to update the chart version and publish a new release.")
\ No newline at end of file
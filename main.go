@@ -18,9 +18,11 @@ The original file is located at [1].
 
 The original source was adjusted to:
 - support a store.Builder which uses a controller-runtime client instead of client-go.
-- remove sharding functionality to reduce complexity for the initial implementation.
+- configure sharding in the custom app package instead.
 - use a custom options package.
 - rename the application.
+- run the application via the custom app package.
+- cancel the context on SIGTERM and SIGINT.
 */
 
 package main
@@ -29,17 +31,17 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/daimler/cluster-api-state-metrics/pkg/app"
+	"github.com/daimler/cluster-api-state-metrics/pkg/options"
 	"github.com/daimler/cluster-api-state-metrics/pkg/store"
 	"github.com/prometheus/common/version"
 	"k8s.io/klog/v2"
-
-	"k8s.io/kube-state-metrics/v2/pkg/app"
-	"k8s.io/kube-state-metrics/v2/pkg/options"
 )
 
 func main() {
-	options.DefaultResources = store.DefaultResources
 	opts := options.NewOptions()
 	opts.AddFlags()
 
@@ -47,13 +49,8 @@ func main() {
 		klog.Fatalf("Parsing flag definitions error: %v", err)
 	}
 
-	// reset back to empty, we get the defaults duplicated at `Active resources` otherwise.
-	if opts.Resources.String() == "" {
-		options.DefaultResources = options.ResourceSet{}
-	}
-
 	if opts.Version {
-		fmt.Printf("%s\n", version.Print("kube-state-metrics"))
+		fmt.Printf("%s\n", version.Print("cluster-api-state-metrics"))
 		os.Exit(0)
 	}
 
@@ -62,8 +59,15 @@ func main() {
 		os.Exit(0)
 	}
 
-	ctx := context.Background()
-	if err := app.RunKubeStateMetrics(ctx, opts, store.Factories()...); err != nil {
-		klog.Fatalf("Failed to run kube-state-metrics: %v", err)
+	if err := opts.Validate(); err != nil {
+		klog.Fatalf("Invalid options: %v", err)
+	}
+
+	// The context is cancelled on SIGTERM or SIGINT, which stops the watches
+	// and shuts down the servers gracefully.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	if err := app.RunClusterAPIStateMetrics(ctx, opts, store.Factories()...); err != nil {
+		klog.Fatalf("Failed to run cluster-api-state-metrics: %v", err)
 	}
 }
@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: MIT
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-state-metrics/v2/pkg/allowdenylist"
+	"k8s.io/kube-state-metrics/v2/pkg/customresource"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	"k8s.io/kube-state-metrics/v2/pkg/optin"
+	ksmoptions "k8s.io/kube-state-metrics/v2/pkg/options"
+
+	"github.com/daimler/cluster-api-state-metrics/pkg/options"
+	"github.com/daimler/cluster-api-state-metrics/pkg/store"
+)
+
+// configCheckInterval is the interval in which the configuration file is
+// checked for changes.
+const configCheckInterval = 10 * time.Second
+
+// storeConfig holds the settings of the store builder which can be changed by
+// the configuration file.
+type storeConfig struct {
+	resources             []string
+	namespaces            ksmoptions.NamespaceList
+	namespaceFilter       string
+	resourceNamespaces    map[string]ksmoptions.NamespaceList
+	familyGeneratorFilter generator.FamilyGeneratorFilter
+	clusterSelector       labels.Selector
+	allowAnnotations      map[string][]string
+	allowLabels           map[string][]string
+}
+
+// newStoreConfig validates the store settings of the options.
+func newStoreConfig(opts *options.Options, factories []customresource.RegistryFactory) (*storeConfig, error) {
+	c := &storeConfig{}
+
+	available := map[string]bool{}
+	var availableNames []string
+	for _, f := range factories {
+		available[f.Name()] = true
+		availableNames = append(availableNames, f.Name())
+	}
+	sort.Strings(availableNames)
+
+	if len(opts.Resources) == 0 {
+		klog.Info("Using default resources")
+		c.resources = options.DefaultResources.AsSlice()
+		sort.Strings(c.resources)
+	} else {
+		klog.Infof("Using resources %s", opts.Resources.String())
+		c.resources = opts.Resources.AsSlice()
+	}
+	for _, r := range c.resources {
+		if !available[r] {
+			return nil, fmt.Errorf("resource %s does not exist. Available resources: %s", r, strings.Join(availableNames, ","))
+		}
+	}
+
+	c.namespaces = opts.Namespaces.GetNamespaces()
+	c.namespaceFilter = c.namespaces.GetExcludeNSFieldSelector(opts.NamespacesDenylist)
+	c.resourceNamespaces = map[string]ksmoptions.NamespaceList{}
+	for r, n := range opts.ResourceNamespaces {
+		if !available[r] {
+			return nil, fmt.Errorf("store of resource %s does not exist. Available resources: %s", r, strings.Join(availableNames, ","))
+		}
+		c.resourceNamespaces[r] = n.GetNamespaces()
+	}
+
+	allowDenyList, err := allowdenylist.New(opts.MetricAllowlist, opts.MetricDenylist)
+	if err != nil {
+		return nil, err
+	}
+
+	err = allowDenyList.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the allowdeny list: %v", err)
+	}
+
+	klog.Infof("Metric allow-denylisting: %v", allowDenyList.Status())
+
+	optInMetricFamilyFilter, err := optin.NewMetricFamilyFilter(opts.MetricOptInList)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the opt-in metric list: %v", err)
+	}
+
+	if optInMetricFamilyFilter.Count() > 0 {
+		klog.Infof("Metrics which were opted into: %v", optInMetricFamilyFilter.Status())
+	}
+
+	c.familyGeneratorFilter = generator.NewCompositeFamilyGeneratorFilter(
+		allowDenyList,
+		optInMetricFamilyFilter,
+	)
+
+	if opts.ClusterSelector != "" {
+		c.clusterSelector, err = labels.Parse(opts.ClusterSelector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cluster selector: %v", err)
+		}
+		klog.Infof("Using cluster selector %s", c.clusterSelector.String())
+	}
+
+	c.allowAnnotations = opts.AnnotationsAllowList
+	c.allowLabels = opts.LabelsAllowList
+
+	return c, nil
+}
+
+// apply configures the store builder with the settings.
+func (c *storeConfig) apply(b *store.MultiClusterBuilder) error {
+	if err := b.WithEnabledResources(c.resources); err != nil {
+		return fmt.Errorf("failed to set up resources: %v", err)
+	}
+	b.WithNamespaces(c.namespaces, c.namespaceFilter)
+	b.WithResourceNamespaces(c.resourceNamespaces)
+	b.WithFamilyGeneratorFilter(c.familyGeneratorFilter)
+	b.WithClusterSelector(c.clusterSelector)
+	b.WithAllowAnnotations(c.allowAnnotations)
+	b.WithAllowLabels(c.allowLabels)
+	return nil
+}
+
+// configReloader checks the configuration file for changes and passes the
+// changed configuration to reload.
+type configReloader struct {
+	path     string
+	interval time.Duration
+	reload   func(*options.Config) error
+	stats    []fileStat
+}
+
+// newConfigReloader returns a new configReloader. Changes are detected in
+// relation to the file at the time of the call.
+func newConfigReloader(path string, interval time.Duration, reload func(*options.Config) error) (*configReloader, error) {
+	stats, err := statFiles([]string{path})
+	if err != nil {
+		return nil, err
+	}
+	return &configReloader{
+		path:     path,
+		interval: interval,
+		reload:   reload,
+		stats:    stats,
+	}, nil
+}
+
+// Run checks the configuration file in the interval until the context is
+// done. Invalid configurations are logged and the previous one is kept.
+func (r *configReloader) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+func (r *configReloader) check() {
+	stats, err := statFiles([]string{r.path})
+	if err != nil {
+		klog.Errorf("Failed to check the config file: %v", err)
+		return
+	}
+	if equalFileStats(stats, r.stats) {
+		return
+	}
+	r.stats = stats
+
+	klog.Infof("Reloading the config file %s", r.path)
+	c, err := options.LoadConfig(r.path)
+	if err != nil {
+		klog.Errorf("Failed to load the config file, keeping the previous config: %v", err)
+		return
+	}
+	if err := r.reload(c); err != nil {
+		klog.Errorf("Failed to apply the config file, keeping the previous config: %v", err)
+		return
+	}
+	klog.Info("Reloaded the config file")
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: MIT
+
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daimler/cluster-api-state-metrics/pkg/options"
+	"github.com/daimler/cluster-api-state-metrics/pkg/store"
+)
+
+func TestConfigReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	modTime := time.Now().Add(-time.Minute)
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("resources: [clusters]\n")
+
+	var reloaded []*options.Config
+	r, err := newConfigReloader(path, time.Second, func(c *options.Config) error {
+		if _, err := newStoreConfig(options.NewOptions().WithConfig(c), store.Factories()); err != nil {
+			return err
+		}
+		reloaded = append(reloaded, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.check()
+	if len(reloaded) != 0 {
+		t.Errorf("expected no reload of the unchanged file, got %d", len(reloaded))
+	}
+
+	writeConfig("resources: [clusters, machines]\n")
+	r.check()
+	if len(reloaded) != 1 || len(reloaded[0].Resources) != 2 {
+		t.Fatalf("expected a reload with 2 resources, got %v", reloaded)
+	}
+
+	for _, content := range []string{
+		"resources: [clusters\n",
+		"resources: [pods]\n",
+		"stores:\n  pods:\n    namespaces: [ns1]\n",
+		"clusterSelector: 'env in prod'\n",
+	} {
+		writeConfig(content)
+		r.check()
+		if len(reloaded) != 1 {
+			t.Errorf("expected no reload of invalid config %q", content)
+		}
+	}
+
+	// removing the file keeps the previous config
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	r.check()
+	if len(reloaded) != 1 {
+		t.Errorf("expected no reload after removing the file")
+	}
+}
+
+func TestNewStoreConfigDefaultResources(t *testing.T) {
+	opts := options.NewOptions()
+	opts.AddFlags()
+	c, err := newStoreConfig(opts, store.Factories())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, r := range c.resources {
+		if r == "secrets" {
+			t.Errorf("expected secrets to be opt-in, got default resources %v", c.resources)
+		}
+	}
+	if len(c.resources) != len(store.Factories())-1 {
+		t.Errorf("expected all other resources to be enabled by default, got %v", c.resources)
+	}
+
+	if err := opts.Resources.Set("clusters,secrets"); err != nil {
+		t.Fatal(err)
+	}
+	c, err = newStoreConfig(opts, store.Factories())
+	if err != nil || len(c.resources) != 2 {
+		t.Errorf("expected secrets to be enabled explicitly, got %v and %v", c, err)
+	}
+}
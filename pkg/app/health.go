@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: MIT
+
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/daimler/cluster-api-state-metrics/pkg/store"
+)
+
+// addHealthEndpoints adds the readiness and liveness endpoints of the stores.
+// The stores are ready when all reflectors completed their initial list, so no
+// partial metrics are scraped after a restart. They are not live when list or
+// watch requests fail for longer than the threshold, which is disabled if it is
+// 0.
+func addHealthEndpoints(mux *http.ServeMux, health *store.Health, threshold time.Duration) {
+	mux.HandleFunc(readyzPath, func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, health.Ready())
+	})
+	mux.HandleFunc(livezPath, func(w http.ResponseWriter, r *http.Request) {
+		if threshold == 0 {
+			writeHealth(w, nil)
+			return
+		}
+		writeHealth(w, health.Live(threshold))
+	})
+}
+
+func writeHealth(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(http.StatusText(http.StatusOK)))
+}
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: MIT
+
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/daimler/cluster-api-state-metrics/pkg/store"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	mux := http.NewServeMux()
+	addHealthEndpoints(mux, store.NewHealth(), time.Minute)
+
+	for path, want := range map[string]int{
+		readyzPath: http.StatusServiceUnavailable,
+		livezPath:  http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected status %d, got %d", path, want, rec.Code)
+		}
+	}
+}
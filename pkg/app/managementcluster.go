@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: MIT
+
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/daimler/cluster-api-state-metrics/pkg/options"
+)
+
+// managementCluster is a cluster api management cluster whose objects are watched.
+type managementCluster struct {
+	// name is added as management_cluster label to all metrics. It is empty
+	// if no management clusters are configured explicitly.
+	name   string
+	config *rest.Config
+}
+
+// managementClusters returns the management clusters which are configured by
+// the contexts of --kubeconfig and by additional kubeconfig files. If none are
+// configured, the cluster of --apiserver and --kubeconfig is returned without
+// a name, so no management_cluster label is added.
+func managementClusters(opts *options.Options) ([]managementCluster, error) {
+	if len(opts.ManagementClusterContexts) == 0 && len(opts.ManagementClusterKubeconfigs) == 0 {
+		config, err := clientcmd.BuildConfigFromFlags(opts.Apiserver, opts.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		return []managementCluster{{config: config}}, nil
+	}
+
+	if opts.Apiserver != "" {
+		return nil, fmt.Errorf("--apiserver can't be used together with --management-cluster-contexts or --management-cluster-kubeconfigs")
+	}
+
+	var clusters []managementCluster
+	names := map[string]bool{}
+	add := func(name string, clientConfig clientcmd.ClientConfig) error {
+		if names[name] {
+			return fmt.Errorf("duplicate management cluster %s", name)
+		}
+		names[name] = true
+
+		config, err := clientConfig.ClientConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config of management cluster %s: %v", name, err)
+		}
+		clusters = append(clusters, managementCluster{name: name, config: config})
+		return nil
+	}
+
+	for _, context := range opts.ManagementClusterContexts {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		loadingRules.ExplicitPath = opts.Kubeconfig
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: context})
+		if err := add(context, clientConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, kubeconfig := range opts.ManagementClusterKubeconfigs {
+		var name string
+		if parts := strings.SplitN(kubeconfig, "=", 2); len(parts) == 2 {
+			name, kubeconfig = parts[0], parts[1]
+		}
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}, &clientcmd.ConfigOverrides{})
+		if name == "" {
+			rawConfig, err := clientConfig.RawConfig()
+			if err != nil {
+				return nil, fmt.Errorf("failed to load kubeconfig %s: %v", kubeconfig, err)
+			}
+			name = rawConfig.CurrentContext
+		}
+		if name == "" {
+			return nil, fmt.Errorf("kubeconfig %s has no current context, prefix it with '<name>=' to set the name of the management cluster", kubeconfig)
+		}
+		if err := add(name, clientConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return clusters, nil
+}
@@ -0,0 +1,546 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The original file is located at [1].
+[1]: https://github.com/kubernetes/kube-state-metrics/blob/41eea36f69ef/pkg/app/server.go
+
+The original source was adjusted to:
+- use the store.Builder of cluster-api-state-metrics which supports cross resource factories.
+- use the custom options package.
+- remove the vertical pod autoscaler client.
+- rename the application.
+- watch multiple management clusters.
+- use the metricshandler of cluster-api-state-metrics and expose the metrics per cluster and namespace.
+- authenticate and authorize the requests of the metrics endpoints.
+- serve HTTPS with reloaded certificates and optional client certificate verification.
+- configure the stores with a configuration file which is reloaded when it changes.
+- shut down the servers gracefully with a configurable timeout and exit cleanly when the context is done.
+- push the metrics to an OpenTelemetry collector with OTLP.
+- push the metrics with the Prometheus remote write protocol.
+- serve the profiles on the telemetry port instead of the metrics port.
+*/
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
+	clientset "k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/plugin/pkg/client/auth" // Initialize common client auth plugins.
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-state-metrics/v2/pkg/customresource"
+	"k8s.io/kube-state-metrics/v2/pkg/util/proc"
+
+	"github.com/daimler/cluster-api-state-metrics/pkg/auth"
+	"github.com/daimler/cluster-api-state-metrics/pkg/metricshandler"
+	"github.com/daimler/cluster-api-state-metrics/pkg/options"
+	"github.com/daimler/cluster-api-state-metrics/pkg/otlp"
+	"github.com/daimler/cluster-api-state-metrics/pkg/remotewrite"
+	"github.com/daimler/cluster-api-state-metrics/pkg/store"
+)
+
+const (
+	metricsPath           = "/metrics"
+	clustersMetricsPath   = "/metrics/clusters/"
+	namespacesMetricsPath = "/metrics/namespaces/"
+	healthzPath           = "/healthz"
+	readyzPath            = "/readyz"
+	livezPath             = "/livez"
+)
+
+// probePaths are served without a client certificate, so the kubelet can
+// probe them.
+var probePaths = []string{healthzPath, readyzPath, livezPath}
+
+// promLogger implements promhttp.Logger
+type promLogger struct{}
+
+func (pl promLogger) Println(v ...interface{}) {
+	klog.Error(v...)
+}
+
+// promLogger implements the Logger interface
+func (pl promLogger) Log(v ...interface{}) error {
+	klog.Info(v...)
+	return nil
+}
+
+// RunClusterAPIStateMetrics will build and run cluster-api-state-metrics.
+// The given factories are used to build the custom resource stores.
+func RunClusterAPIStateMetrics(ctx context.Context, opts *options.Options, factories ...customresource.RegistryFactory) error {
+	promLogger := promLogger{}
+
+	clusters, err := managementClusters(opts)
+	if err != nil {
+		return fmt.Errorf("failed to configure management clusters: %v", err)
+	}
+
+	var kubeClient clientset.Interface
+	// kubeClients holds the client of each management cluster by its name.
+	kubeClients := map[string]clientset.Interface{}
+	var builders []*store.Builder
+	for _, c := range clusters {
+		if c.name != "" {
+			klog.Infof("Watching management cluster %s", c.name)
+		}
+		clusterKubeClient, customResourceClients, err := createKubeClient(c.config, factories...)
+		if err != nil {
+			return fmt.Errorf("failed to create client: %v", err)
+		}
+		// The client of the first management cluster is used for autosharding.
+		if kubeClient == nil {
+			kubeClient = clusterKubeClient
+		}
+		kubeClients[c.name] = clusterKubeClient
+
+		b := store.NewBuilder()
+		b.WithManagementCluster(c.name)
+		b.WithCustomResourceStoreFactories(factories...)
+		b.WithCrossResourceFactories(store.CrossResourceFactories(opts.StuckThresholds())...)
+		b.WithKubeClient(clusterKubeClient)
+		b.WithCustomResourceClients(customResourceClients)
+		if opts.WorkloadClusterNodes {
+			b.WithCrossResourceFactories(store.NewWorkloadClusterNodeFactory(clusterKubeClient, opts.WorkloadClusterSyncInterval))
+		}
+		if opts.ControlPlaneProbe {
+			b.WithCrossResourceFactories(store.NewControlPlaneProbeFactory(clusterKubeClient, opts.ControlPlaneProbeInterval, opts.ControlPlaneProbeTimeout))
+		}
+		builders = append(builders, b)
+	}
+	storeBuilder := store.NewMultiClusterBuilder(builders...)
+
+	ksmMetricsRegistry := prometheus.NewRegistry()
+	ksmMetricsRegistry.MustRegister(version.NewCollector("capi_exporter"))
+	durationVec := promauto.With(ksmMetricsRegistry).NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:        "http_request_duration_seconds",
+			Help:        "A histogram of requests for cluster-api-state-metrics metrics handler.",
+			Buckets:     prometheus.DefBuckets,
+			ConstLabels: prometheus.Labels{"handler": "metrics"},
+		}, []string{"method"},
+	)
+	storeBuilder.WithMetrics(ksmMetricsRegistry)
+
+	storeOpts := opts
+	if opts.Config != "" {
+		c, err := options.LoadConfig(opts.Config)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %v", err)
+		}
+		storeOpts = opts.WithConfig(c)
+	}
+	storeConfig, err := newStoreConfig(storeOpts, factories)
+	if err != nil {
+		return err
+	}
+	if err := storeConfig.apply(storeBuilder); err != nil {
+		return err
+	}
+
+	storeBuilder.WithUsingAPIServerCache(opts.UseAPIServerCache)
+	storeBuilder.WithListPageSize(opts.ListPageSize)
+	health := store.NewHealth()
+	storeBuilder.WithHealth(health)
+	storeBuilder.WithGenerateCustomResourceStoresFunc(storeBuilder.DefaultGenerateCustomResourceStoresFunc())
+
+	proc.StartReaper()
+
+	storeBuilder.WithSharding(opts.Shard, opts.TotalShards)
+
+	ksmMetricsRegistry.MustRegister(
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewGoCollector(),
+	)
+
+	var g run.Group
+
+	m := metricshandler.New(
+		opts.Options,
+		kubeClient,
+		storeBuilder,
+		opts.EnableGZIPEncoding,
+	)
+	// Run MetricsHandler
+	{
+		ctxMetricsHandler, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return m.Run(ctxMetricsHandler)
+		}, func(error) {
+			cancel()
+		})
+	}
+	// Run config reloader
+	if opts.Config != "" {
+		reloader, err := newConfigReloader(opts.Config, configCheckInterval, func(c *options.Config) error {
+			storeConfig, err := newStoreConfig(opts.WithConfig(c), factories)
+			if err != nil {
+				return err
+			}
+			var applyErr error
+			m.Reconfigure(func() {
+				applyErr = storeConfig.apply(storeBuilder)
+			})
+			return applyErr
+		})
+		if err != nil {
+			return fmt.Errorf("failed to watch config: %v", err)
+		}
+		ctxReloader, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return reloader.Run(ctxReloader)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	// Run OTLP exporter
+	if opts.OTLPEndpoint != "" {
+		headers, err := opts.OTLPRequestHeaders()
+		if err != nil {
+			return fmt.Errorf("failed to read OTLP headers: %v", err)
+		}
+		exporter, err := otlp.NewExporter(otlp.Config{
+			Endpoint: opts.OTLPEndpoint,
+			Protocol: opts.OTLPProtocol,
+			Interval: opts.OTLPInterval,
+			Timeout:  opts.OTLPTimeout,
+			Insecure: opts.OTLPInsecure,
+			Headers:  headers,
+		}, freshGatherer(m, health, opts.StaleStoreThreshold))
+		if err != nil {
+			return fmt.Errorf("failed to create OTLP exporter: %v", err)
+		}
+		klog.Infof("Pushing metrics to %s with OTLP %s every %s", opts.OTLPEndpoint, opts.OTLPProtocol, opts.OTLPInterval)
+		ctxExporter, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return exporter.Run(ctxExporter)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	// Run remote writer
+	if opts.RemoteWriteURL != "" {
+		headers, err := opts.RemoteWriteRequestHeaders()
+		if err != nil {
+			return fmt.Errorf("failed to read remote write headers: %v", err)
+		}
+		writer := remotewrite.NewWriter(remotewrite.Config{
+			URL:           opts.RemoteWriteURL,
+			Interval:      opts.RemoteWriteInterval,
+			Timeout:       opts.RemoteWriteTimeout,
+			QueueCapacity: opts.RemoteWriteQueueCapacity,
+			MaxRetries:    opts.RemoteWriteMaxRetries,
+			Headers:       headers,
+			Ready:         health.Ready,
+		}, freshGatherer(m, health, opts.StaleStoreThreshold), remotewrite.NewMetrics(ksmMetricsRegistry))
+		// The URL is validated by the options and may contain credentials.
+		u, _ := url.Parse(opts.RemoteWriteURL)
+		klog.Infof("Pushing metrics to %s with remote write every %s", u.Redacted(), opts.RemoteWriteInterval)
+		ctxWriter, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return writer.Run(ctxWriter)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	tlsConfig := opts.TLSConfig
+	var tlsFiles *tlsReloader
+	if opts.TLSCertFile != "" || opts.TLSPrivateKeyFile != "" || opts.TLSClientCAFile != "" {
+		if tlsConfig != "" {
+			return fmt.Errorf("--tls-config cannot be combined with --tls-cert-file, --tls-private-key-file and --tls-client-ca-file")
+		}
+		tlsFiles, err = newTLSReloader(opts.TLSCertFile, opts.TLSPrivateKeyFile, opts.TLSClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS files: %v", err)
+		}
+		klog.Info("TLS is enabled")
+	}
+
+	telemetryMux := buildTelemetryServer(ksmMetricsRegistry)
+	addHealthEndpoints(telemetryMux, health, opts.WatchFailureThreshold)
+	telemetryListenAddress := net.JoinHostPort(opts.TelemetryHost, strconv.Itoa(opts.TelemetryPort))
+	telemetryServer := http.Server{Handler: telemetryMux, Addr: telemetryListenAddress}
+
+	var authorizers map[string]*auth.Authorizer
+	if opts.MetricsAuth {
+		groups, err := store.ResourceGroups(factories...)
+		if err != nil {
+			return fmt.Errorf("failed to get the API groups of the resources: %v", err)
+		}
+		// The objects of each management cluster are authorized by the
+		// reviews of the management cluster itself.
+		authorizers = map[string]*auth.Authorizer{}
+		for name, c := range kubeClients {
+			authorizers[name] = auth.NewAuthorizer(c, groups, opts.MetricsAuthCacheTTL)
+		}
+		klog.Info("Authentication of the metrics endpoints enabled")
+	}
+
+	metricsMux := buildMetricsServer(m, authorizers, durationVec, health, opts.StaleStoreThreshold, len(clusters) > 1)
+	addHealthEndpoints(metricsMux, health, opts.WatchFailureThreshold)
+	metricsServerListenAddress := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
+	metricsServer := http.Server{Handler: metricsMux, Addr: metricsServerListenAddress}
+
+	// Run Telemetry server
+	addServer(&g, &telemetryServer, func() error {
+		klog.Infof("Starting cluster-api-state-metrics self metrics server: %s", telemetryListenAddress)
+		if tlsFiles != nil {
+			return tlsFiles.listenAndServe(&telemetryServer)
+		}
+		return web.ListenAndServe(&telemetryServer, tlsConfig, promLogger)
+	}, opts.ShutdownTimeout)
+	// Run Metrics server
+	addServer(&g, &metricsServer, func() error {
+		klog.Infof("Starting metrics server: %s", metricsServerListenAddress)
+		if tlsFiles != nil {
+			return tlsFiles.listenAndServe(&metricsServer)
+		}
+		return web.ListenAndServe(&metricsServer, tlsConfig, promLogger)
+	}, opts.ShutdownTimeout)
+
+	if err := g.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("run server group error: %v", err)
+	}
+	klog.Info("Exiting")
+	return nil
+}
+
+// freshGatherer returns a gatherer of the generated metrics which fails while a
+// store is stale, so stale metrics are not pushed like they are not served.
+func freshGatherer(m *metricshandler.MetricsHandler, health *store.Health, staleThreshold time.Duration) prometheus.Gatherer {
+	if staleThreshold == 0 {
+		return m
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		if err := health.Stale(staleThreshold); err != nil {
+			return nil, err
+		}
+		return m.Gather()
+	})
+}
+
+// addServer adds the server to the group. When the group is interrupted, the
+// server stops accepting connections and waits up to the timeout for in-flight
+// requests. The servers of the group are shut down concurrently and the actor
+// only returns once the shutdown finished.
+func addServer(g *run.Group, server *http.Server, serve func() error, timeout time.Duration) {
+	shutdown := make(chan struct{})
+	g.Add(func() error {
+		err := serve()
+		if err == http.ErrServerClosed {
+			<-shutdown
+		}
+		return err
+	}, func(error) {
+		go func() {
+			defer close(shutdown)
+			// The context of the group may be done already, so the
+			// timeout is independent of it.
+			ctxShutDown, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := server.Shutdown(ctxShutDown); err != nil {
+				klog.Errorf("Failed to shut down server %s gracefully: %v", server.Addr, err)
+			}
+		}()
+	})
+}
+
+func createKubeClient(config *rest.Config, factories ...customresource.RegistryFactory) (clientset.Interface, map[string]interface{}, error) {
+	config.UserAgent = version.Version
+	config.AcceptContentTypes = "application/vnd.kubernetes.protobuf,application/json"
+	config.ContentType = "application/vnd.kubernetes.protobuf"
+
+	kubeClient, err := clientset.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	customResourceClients := make(map[string]interface{}, len(factories))
+	for _, f := range factories {
+		customResourceClient, err := f.CreateClient(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		customResourceClients[f.Name()] = customResourceClient
+	}
+
+	// Informers don't seem to do a good job logging error messages when it
+	// can't reach the server, making debugging hard. This makes it easier to
+	// figure out if apiserver is configured incorrectly.
+	klog.Infof("Testing communication with server")
+	v, err := kubeClient.Discovery().ServerVersion()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error while trying to communicate with apiserver")
+	}
+	klog.Infof("Running with Kubernetes cluster version: v%s.%s. git version: %s. git tree state: %s. commit: %s. platform: %s",
+		v.Major, v.Minor, v.GitVersion, v.GitTreeState, v.GitCommit, v.Platform)
+	klog.Infof("Communication with server successful")
+
+	return kubeClient, customResourceClients, nil
+}
+
+func buildTelemetryServer(registry prometheus.Gatherer) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// The profiles are served on the telemetry port, because the metrics port
+	// may be exposed to users authenticated by --metrics-auth.
+	mux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	mux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	mux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	mux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	mux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+
+	// Add metricsPath
+	mux.Handle(metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorLog: promLogger{}}))
+	// Add index
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html>
+             <head><title>Cluster-API-State-Metrics Metrics Server</title></head>
+             <body>
+             <h1>Cluster-API-State-Metrics Metrics</h1>
+			 <ul>
+             <li><a href='` + metricsPath + `'>metrics</a></li>
+			 </ul>
+             </body>
+             </html>`))
+	})
+	return mux
+}
+
+func buildMetricsServer(m *metricshandler.MetricsHandler, authorizers map[string]*auth.Authorizer, durationObserver prometheus.ObserverVec, health *store.Health, staleThreshold time.Duration, multipleManagementClusters bool) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// serveMetrics writes the metrics matching the filter. If authentication
+	// is enabled, only the objects the user can list in their management
+	// cluster are written. If a stale threshold is set, no metrics are written
+	// while a store is stale.
+	serveMetrics := func(w http.ResponseWriter, r *http.Request, filter store.MetricsFilter) {
+		if authorizers != nil {
+			// The permissions are resolved before the metrics are written,
+			// so the stores are not locked during the reviews.
+			namespaces := []string{filter.Namespace}
+			if filter.Namespace == "" {
+				namespaces = m.Namespaces()
+			}
+			resources := m.Resources()
+			filter.Allowed = map[string]store.Permissions{}
+			for name, authorizer := range authorizers {
+				if filter.ManagementCluster != "" && filter.ManagementCluster != name {
+					continue
+				}
+				// The token is only valid in some management clusters,
+				// nothing is allowed in the others.
+				user, err := authorizer.Authenticate(r)
+				if err != nil {
+					if err != auth.ErrUnauthenticated {
+						klog.Errorf("Failed to review token in management cluster %q: %v", name, err)
+					}
+					continue
+				}
+				filter.Allowed[name] = authorizer.AllowedNamespaces(r.Context(), user, resources, namespaces)
+			}
+			if len(filter.Allowed) == 0 {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		if staleThreshold > 0 {
+			if err := health.Stale(staleThreshold); err != nil {
+				klog.Warningf("Not serving stale metrics: %v", err)
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		m.ServeFiltered(w, r, filter)
+	}
+
+	mux.Handle(metricsPath, promhttp.InstrumentHandlerDuration(durationObserver, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveMetrics(w, r, store.MetricsFilter{})
+	})))
+	// Add the metrics of a single cluster at /metrics/clusters/<namespace>/<name>
+	// or at /metrics/clusters/<management-cluster>/<namespace>/<name>, which is
+	// required if several management clusters are watched, as they may have
+	// clusters with the same namespace and name.
+	mux.Handle(clustersMetricsPath, promhttp.InstrumentHandlerDuration(durationObserver, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, clustersMetricsPath), "/")
+		for _, part := range parts {
+			if part == "" {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		switch {
+		case len(parts) == 3:
+			serveMetrics(w, r, store.MetricsFilter{ManagementCluster: parts[0], Namespace: parts[1], Cluster: parts[2]})
+		case len(parts) == 2 && multipleManagementClusters:
+			http.Error(w, "Several management clusters are watched, the cluster has to be requested at "+clustersMetricsPath+"<management-cluster>/<namespace>/<name>", http.StatusNotFound)
+		case len(parts) == 2:
+			serveMetrics(w, r, store.MetricsFilter{Namespace: parts[0], Cluster: parts[1]})
+		default:
+			http.NotFound(w, r)
+		}
+	})))
+	// Add the metrics of a single namespace at /metrics/namespaces/<namespace>
+	mux.Handle(namespacesMetricsPath, promhttp.InstrumentHandlerDuration(durationObserver, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace := strings.TrimPrefix(r.URL.Path, namespacesMetricsPath)
+		if namespace == "" || strings.Contains(namespace, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		serveMetrics(w, r, store.MetricsFilter{Namespace: namespace})
+	})))
+
+	// Add healthzPath
+	mux.HandleFunc(healthzPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(http.StatusText(http.StatusOK)))
+	})
+	// Add index
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html>
+             <head><title>Cluster API Metrics Server</title></head>
+             <body>
+             <h1>Cluster API Metrics</h1>
+			 <ul>
+             <li><a href='` + metricsPath + `'>metrics</a></li>
+             <li><a href='` + healthzPath + `'>healthz</a></li>
+             <li><a href='` + readyzPath + `'>readyz</a></li>
+             <li><a href='` + livezPath + `'>livez</a></li>
+			 </ul>
+             </body>
+             </html>`))
+	})
+	return mux
+}
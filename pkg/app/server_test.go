@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+
+package app
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAddServerDrainsRequests(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("complete"))
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var g run.Group
+	g.Add(func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, func(error) {
+		cancel()
+	})
+	addServer(&g, server, func() error {
+		return server.Serve(listener)
+	}, 5*time.Second)
+
+	done := make(chan error)
+	go func() {
+		done <- g.Run()
+	}()
+
+	body := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			t.Error(err)
+			body <- ""
+			return
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		body <- string(b)
+	}()
+	<-started
+
+	// the group waits for the in-flight request after the interrupt
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("expected the group to wait for the in-flight request")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-body; got != "complete" {
+		t.Errorf("expected the complete response, got %q", got)
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the group to return the error of the first actor, got %v", err)
+	}
+}
+
+func TestProfilesServedOnTelemetryPort(t *testing.T) {
+	telemetryMux := buildTelemetryServer(prometheus.NewRegistry())
+	durationVec := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "http_request_duration_seconds"}, []string{"method"})
+	metricsMux := buildMetricsServer(nil, nil, durationVec, nil, 0, false)
+
+	for _, c := range []struct {
+		name string
+		mux  *http.ServeMux
+		want bool
+	}{
+		{name: "telemetry", mux: telemetryMux, want: true},
+		{name: "metrics", mux: metricsMux, want: false},
+	} {
+		rec := httptest.NewRecorder()
+		c.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+		if got := strings.Contains(rec.Body.String(), "Types of profiles available"); got != c.want {
+			t.Errorf("%s: expected the profiles to be served %t, got %t", c.name, c.want, got)
+		}
+	}
+}
+
+func TestClustersMetricsPathRequiresManagementCluster(t *testing.T) {
+	durationVec := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "http_request_duration_seconds"}, []string{"method"})
+	metricsMux := buildMetricsServer(nil, nil, durationVec, nil, 0, true)
+
+	for _, path := range []string{"/metrics/clusters/ns1/c1", "/metrics/clusters/mc1/ns1/", "/metrics/clusters/mc1/ns1/c1/m1"} {
+		rec := httptest.NewRecorder()
+		metricsMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusNotFound, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: MIT
+
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// tlsReloader holds the TLS config of the metrics and telemetry servers. The
+// certificate, key and client CA are reloaded on new connections if one of
+// the files changed.
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mtx    sync.Mutex
+	config *tls.Config
+	stats  []fileStat
+}
+
+// fileStat identifies the version of a file.
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// newTLSReloader returns a new tlsReloader and loads the files initially. If a
+// client CA is given, client certificates are verified.
+func newTLSReloader(certFile, keyFile, clientCAFile string) (*tlsReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both a certificate and a private key are required")
+	}
+	r := &tlsReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if _, err := r.getConfigForClient(nil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// getConfigForClient returns the current TLS config. If reloading the changed
+// files fails, the previous config is kept until the files change again.
+func (r *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	stats, err := statFiles(r.files())
+	if err != nil {
+		if r.config == nil {
+			return nil, err
+		}
+		klog.Errorf("Failed to check the TLS files, keeping the previous config: %v", err)
+		return r.config, nil
+	}
+	if r.config != nil && equalFileStats(stats, r.stats) {
+		return r.config, nil
+	}
+
+	config, err := r.load()
+	if err != nil {
+		if r.config == nil {
+			return nil, err
+		}
+		klog.Errorf("Failed to reload the TLS files, keeping the previous config: %v", err)
+		r.stats = stats
+		return r.config, nil
+	}
+	if r.config != nil {
+		klog.Info("Reloaded the TLS files")
+	}
+	r.config = config
+	r.stats = stats
+	return r.config, nil
+}
+
+func (r *tlsReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// statFiles returns the versions of the files.
+func statFiles(files []string) ([]fileStat, error) {
+	stats := make([]fileStat, 0, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, fileStat{modTime: info.ModTime(), size: info.Size()})
+	}
+	return stats, nil
+}
+
+func (r *tlsReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the certificate: %v", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", r.clientCAFile)
+		}
+		config.ClientCAs = pool
+		// Client certificates are verified if given and required by
+		// requireClientCertificate, so probes can reach the health endpoints.
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+func equalFileStats(a, b []fileStat) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// listenAndServe serves HTTPS using the reloader.
+func (r *tlsReloader) listenAndServe(server *http.Server) error {
+	r.configure(server)
+	return server.ListenAndServeTLS("", "")
+}
+
+// configure sets the TLS config and handler of the server to use the reloader.
+// The NextProtos of the server are kept, so HTTP/2 stays enabled.
+func (r *tlsReloader) configure(server *http.Server) {
+	server.TLSConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// ListenAndServeTLS requires a certificate or GetCertificate
+		// without files, GetConfigForClient is not sufficient before Go
+		// 1.21.
+		GetCertificate: r.getCertificate,
+	}
+	server.TLSConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		config, err := r.getConfigForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.NextProtos = server.TLSConfig.NextProtos
+		return config, nil
+	}
+	if r.clientCAFile != "" {
+		server.Handler = requireClientCertificate(server.Handler, probePaths...)
+	}
+}
+
+// getCertificate returns the certificate of the current TLS config.
+func (r *tlsReloader) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	config, err := r.getConfigForClient(hello)
+	if err != nil {
+		return nil, err
+	}
+	return &config.Certificates[0], nil
+}
+
+// requireClientCertificate rejects requests without a verified client
+// certificate, except for the given paths.
+func requireClientCertificate(next http.Handler, exemptPaths ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range exemptPaths {
+			if r.URL.Path == p {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
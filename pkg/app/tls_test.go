@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: MIT
+
+package app
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCertificate returns a certificate signed by the parent, or a self-signed
+// CA if the parent is nil, and its key.
+func newCertificate(t *testing.T, serial int64, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "cluster-api-state-metrics"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writeCertificate writes the certificate and key as PEM files and sets their
+// modification time.
+func writeCertificate(t *testing.T, certFile, keyFile string, cert *x509.Certificate, key *rsa.PrivateKey, modTime time.Time) {
+	t.Helper()
+	files := map[string][]byte{
+		certFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		keyFile:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTLSReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	if _, err := newTLSReloader(certFile, "", ""); err == nil {
+		t.Error("expected an error without a private key")
+	}
+	if _, err := newTLSReloader(certFile, keyFile, ""); err == nil {
+		t.Error("expected an error for missing files")
+	}
+
+	modTime := time.Now().Add(-time.Minute)
+	cert, key := newCertificate(t, 1, nil, nil)
+	writeCertificate(t, certFile, keyFile, cert, key, modTime)
+
+	r, err := newTLSReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSerial := func(name string, want int64) {
+		t.Helper()
+		config, err := r.getConfigForClient(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.SerialNumber.Int64() != want {
+			t.Errorf("expected certificate %d %s, got %d", want, name, got.SerialNumber.Int64())
+		}
+	}
+	expectSerial("initially", 1)
+
+	// changed files are reloaded
+	cert, key = newCertificate(t, 2, nil, nil)
+	writeCertificate(t, certFile, keyFile, cert, key, modTime.Add(time.Second))
+	expectSerial("after the files changed", 2)
+
+	// invalid files keep the previous certificate
+	if err := ioutil.WriteFile(certFile, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expectSerial("after writing an invalid certificate", 2)
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	expectSerial("after removing the key", 2)
+}
+
+func TestTLSReloaderServe(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	cert, key := newCertificate(t, 1, nil, nil)
+	writeCertificate(t, certFile, keyFile, cert, key, time.Now())
+
+	r, err := newTLSReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})}
+	r.configure(server)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ServeTLS(listener, "", "")
+	}()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + listener.Addr().String() + metricsPath)
+	if err != nil {
+		select {
+		case err := <-served:
+			t.Fatalf("failed to serve: %v", err)
+		default:
+		}
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if resp.TLS == nil || !resp.TLS.PeerCertificates[0].Equal(cert) {
+		t.Error("expected the handshake with the certificate of the reloader")
+	}
+}
+
+func TestRequireClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	caFile, caKeyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+
+	modTime := time.Now()
+	ca, caKey := newCertificate(t, 1, nil, nil)
+	writeCertificate(t, caFile, caKeyFile, ca, caKey, modTime)
+	cert, key := newCertificate(t, 2, ca, caKey)
+	writeCertificate(t, certFile, keyFile, cert, key, modTime)
+	otherCA, otherCAKey := newCertificate(t, 3, nil, nil)
+	otherCert, otherKey := newCertificate(t, 4, otherCA, otherCAKey)
+
+	r, err := newTLSReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(requireClientCertificate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), probePaths...))
+	server.TLS = &tls.Config{GetConfigForClient: r.getConfigForClient}
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	newClient := func(cert *x509.Certificate, key *rsa.PrivateKey) *http.Client {
+		config := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			config.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	}
+
+	cases := []struct {
+		name   string
+		client *http.Client
+		path   string
+		status int
+	}{
+		{"client certificate", newClient(cert, key), metricsPath, http.StatusOK},
+		{"no client certificate", newClient(nil, nil), metricsPath, http.StatusUnauthorized},
+		{"no client certificate on the health endpoint", newClient(nil, nil), healthzPath, http.StatusOK},
+		{"client certificate of another CA", newClient(otherCert, otherKey), metricsPath, 0},
+	}
+	for _, c := range cases {
+		resp, err := c.client.Get(server.URL + c.path)
+		if c.status == 0 {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("expected the handshake to fail with %s", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error with %s: %v", c.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("expected status %d with %s, got %d", c.status, c.name, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: MIT
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// ErrUnauthenticated is returned if a request has no valid bearer token.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authorizer authenticates the bearer token of requests with a TokenReview and
+// checks with SubjectAccessReviews in which namespaces the user can list a
+// resource. The results are cached for the configured TTL.
+type Authorizer struct {
+	kubeClient clientset.Interface
+	// groups holds the API group of each resource.
+	groups   map[string]string
+	cacheTTL time.Duration
+	now      func() time.Time
+
+	mtx       sync.Mutex
+	users     map[string]cachedUser
+	decisions map[decisionKey]cachedDecision
+	lastPrune time.Time
+}
+
+type cachedUser struct {
+	user    authenticationv1.UserInfo
+	expires time.Time
+}
+
+// decisionKey identifies a review. The user includes the groups and extra
+// values, as they are part of the review.
+type decisionKey struct {
+	user      string
+	resource  string
+	namespace string
+}
+
+type cachedDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// NewAuthorizer returns a new Authorizer which uses the given client for the
+// reviews. The groups map the resource names to their API group.
+func NewAuthorizer(kubeClient clientset.Interface, groups map[string]string, cacheTTL time.Duration) *Authorizer {
+	return &Authorizer{
+		kubeClient: kubeClient,
+		groups:     groups,
+		cacheTTL:   cacheTTL,
+		now:        time.Now,
+		users:      map[string]cachedUser{},
+		decisions:  map[decisionKey]cachedDecision{},
+	}
+}
+
+// Authenticate returns the user of the bearer token of the request.
+func (a *Authorizer) Authenticate(r *http.Request) (authenticationv1.UserInfo, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return authenticationv1.UserInfo{}, ErrUnauthenticated
+	}
+
+	a.mtx.Lock()
+	cached, ok := a.users[token]
+	a.mtx.Unlock()
+	if ok && a.now().Before(cached.expires) {
+		return cached.user, nil
+	}
+
+	review, err := a.kubeClient.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authenticationv1.UserInfo{}, err
+	}
+	if !review.Status.Authenticated {
+		return authenticationv1.UserInfo{}, ErrUnauthenticated
+	}
+
+	a.mtx.Lock()
+	a.prune()
+	a.users[token] = cachedUser{user: review.Status.User, expires: a.now().Add(a.cacheTTL)}
+	a.mtx.Unlock()
+
+	return review.Status.User, nil
+}
+
+// AllowedNamespaces returns for each of the given resources the namespaces of
+// the given ones in which the user can list it. The empty namespace is included
+// if the user can list the resource in all namespaces, which is required for
+// metrics without a namespace, and the given namespaces are not reviewed then.
+// Failed reviews are denied.
+func (a *Authorizer) AllowedNamespaces(ctx context.Context, user authenticationv1.UserInfo, resources, namespaces []string) map[string]map[string]bool {
+	allowed := make(map[string]map[string]bool, len(resources))
+	for _, resource := range resources {
+		allowed[resource] = map[string]bool{}
+		if a.allowed(ctx, user, resource, "") {
+			allowed[resource][""] = true
+			continue
+		}
+		for _, namespace := range namespaces {
+			if namespace != "" && a.allowed(ctx, user, resource, namespace) {
+				allowed[resource][namespace] = true
+			}
+		}
+	}
+	return allowed
+}
+
+// allowed returns true if the user can list the resource in the namespace. An
+// empty namespace requires the permission to list the resource in all
+// namespaces. Failed reviews are denied.
+func (a *Authorizer) allowed(ctx context.Context, user authenticationv1.UserInfo, resource, namespace string) bool {
+	key := decisionKey{user: userKey(user), resource: resource, namespace: namespace}
+
+	a.mtx.Lock()
+	cached, ok := a.decisions[key]
+	a.mtx.Unlock()
+	if ok && a.now().Before(cached.expires) {
+		return cached.allowed
+	}
+
+	group, ok := a.groups[resource]
+	if !ok {
+		klog.Errorf("Unknown API group of resource %s", resource)
+		return false
+	}
+
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review, err := a.kubeClient.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "list",
+				Group:     group,
+				Resource:  resource,
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+			Extra:  extra,
+			UID:    user.UID,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		klog.Errorf("Failed to review access of user %s to %s in namespace %q: %v", user.Username, resource, namespace, err)
+		return false
+	}
+
+	a.mtx.Lock()
+	a.prune()
+	a.decisions[key] = cachedDecision{allowed: review.Status.Allowed, expires: a.now().Add(a.cacheTTL)}
+	a.mtx.Unlock()
+	return review.Status.Allowed
+}
+
+// userKey returns the UID, name, sorted groups and sorted extra values of the
+// user, so users with the same name but different groups don't share the
+// cached decisions.
+func userKey(user authenticationv1.UserInfo) string {
+	groups := append([]string{}, user.Groups...)
+	sort.Strings(groups)
+	extra := make([]string, 0, len(user.Extra))
+	for k, v := range user.Extra {
+		values := append([]string{}, v...)
+		sort.Strings(values)
+		extra = append(extra, fmt.Sprintf("%q=%q", k, values))
+	}
+	sort.Strings(extra)
+	return fmt.Sprintf("%q %q %q %q", user.UID, user.Username, groups, extra)
+}
+
+// prune removes the expired entries from the caches once per TTL. The mutex
+// has to be held by the caller.
+func (a *Authorizer) prune() {
+	now := a.now()
+	if now.Sub(a.lastPrune) < a.cacheTTL {
+		return
+	}
+	a.lastPrune = now
+
+	for token, u := range a.users {
+		if !now.Before(u.expires) {
+			delete(a.users, token)
+		}
+	}
+	for key, d := range a.decisions {
+		if !now.Before(d.expires) {
+			delete(a.decisions, key)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: MIT
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// newFakeAPIServer returns an API server which authenticates the token "team-a"
+// as user team-a, who can list machines in namespace ns1 and in all namespaces
+// as member of the group admins. It counts the reviews it received.
+func newFakeAPIServer(t *testing.T, reviews *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(reviews, 1)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/authentication.k8s.io/v1/tokenreviews":
+			review := &authenticationv1.TokenReview{}
+			if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+				t.Error(err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if review.Spec.Token == "team-a" {
+				review.Status.Authenticated = true
+				review.Status.User = authenticationv1.UserInfo{Username: "team-a", UID: "1"}
+			}
+			json.NewEncoder(w).Encode(review)
+		case "/apis/authorization.k8s.io/v1/subjectaccessreviews":
+			review := &authorizationv1.SubjectAccessReview{}
+			if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+				t.Error(err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			attrs := review.Spec.ResourceAttributes
+			review.Status.Allowed = review.Spec.User == "team-a" &&
+				attrs.Verb == "list" &&
+				attrs.Group == "cluster.x-k8s.io" &&
+				attrs.Resource == "machines" &&
+				(attrs.Namespace == "ns1" || len(review.Spec.Groups) == 1 && review.Spec.Groups[0] == "admins")
+			json.NewEncoder(w).Encode(review)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestAuthorizer(t *testing.T) {
+	var reviews int32
+	server := newFakeAPIServer(t, &reviews)
+	defer server.Close()
+
+	kubeClient, err := clientset.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthorizer(kubeClient, map[string]string{
+		"clusters": "cluster.x-k8s.io",
+		"machines": "cluster.x-k8s.io",
+	}, time.Minute)
+	now := time.Unix(1700000000, 0)
+	a.now = func() time.Time { return now }
+
+	for _, header := range []string{"", "Basic team-a", "Bearer team-b"} {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if _, err := a.Authenticate(r); err != ErrUnauthenticated {
+			t.Errorf("expected authorization header %q to be unauthenticated, got %v", header, err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer team-a")
+	user, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "team-a" {
+		t.Errorf("expected user team-a, got %s", user.Username)
+	}
+
+	allowed := func(resource, namespace string) bool {
+		return a.allowed(context.Background(), user, resource, namespace)
+	}
+	cases := []struct {
+		resource  string
+		namespace string
+		allowed   bool
+	}{
+		{"machines", "ns1", true},
+		{"machines", "ns2", false},
+		{"machines", "", false},
+		{"clusters", "ns1", false},
+		{"unknown", "ns1", false},
+	}
+	for _, c := range cases {
+		if got := allowed(c.resource, c.namespace); got != c.allowed {
+			t.Errorf("expected %s in namespace %q allowed to be %t, got %t", c.resource, c.namespace, c.allowed, got)
+		}
+	}
+
+	// the results are cached until the TTL expired
+	reviewsBefore := atomic.LoadInt32(&reviews)
+	if _, err := a.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	allowed("machines", "ns1")
+	if got := atomic.LoadInt32(&reviews) - reviewsBefore; got != 0 {
+		t.Errorf("expected cached results, got %d additional reviews", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := a.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	allowed("machines", "ns1")
+	if got := atomic.LoadInt32(&reviews) - reviewsBefore; got != 2 {
+		t.Errorf("expected 2 reviews after the TTL expired, got %d", got)
+	}
+
+	// the decisions are cached per groups of the user
+	admin := user
+	admin.Groups = []string{"admins"}
+	if !a.allowed(context.Background(), admin, "machines", "") {
+		t.Error("expected the admin to list machines in all namespaces")
+	}
+	if allowed("machines", "") {
+		t.Error("expected the user without groups not to get the cached decision of the admin")
+	}
+}
+
+func TestAuthorizerAllowedNamespaces(t *testing.T) {
+	var reviews int32
+	server := newFakeAPIServer(t, &reviews)
+	defer server.Close()
+
+	kubeClient, err := clientset.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthorizer(kubeClient, map[string]string{
+		"clusters": "cluster.x-k8s.io",
+		"machines": "cluster.x-k8s.io",
+		"secrets":  "",
+	}, time.Minute)
+
+	// only the given resources are reviewed
+	user := authenticationv1.UserInfo{Username: "team-a", UID: "1"}
+	got := a.AllowedNamespaces(context.Background(), user, []string{"clusters", "machines"}, []string{"ns1", "ns2"})
+	want := map[string]map[string]bool{
+		"clusters": {},
+		"machines": {"ns1": true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected allowed namespaces %v, got %v", want, got)
+	}
+	// one review of all namespaces and one of each namespace per resource
+	if got := atomic.LoadInt32(&reviews); got != 6 {
+		t.Errorf("expected 6 reviews, got %d", got)
+	}
+}
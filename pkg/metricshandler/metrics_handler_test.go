@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MIT
+
+package metricshandler
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	ksmtypes "k8s.io/kube-state-metrics/v2/pkg/builder/types"
+	metricsstore "k8s.io/kube-state-metrics/v2/pkg/metrics_store"
+	"k8s.io/kube-state-metrics/v2/pkg/options"
+)
+
+// countingBuilder counts the builds and rebuilds of the stores.
+type countingBuilder struct {
+	ksmtypes.BuilderInterface
+	ctx      context.Context
+	builds   int
+	rebuilds int
+}
+
+func (b *countingBuilder) WithSharding(shard int32, totalShards int) {}
+
+func (b *countingBuilder) WithContext(ctx context.Context) {
+	b.ctx = ctx
+}
+
+func (b *countingBuilder) Build() []metricsstore.MetricsWriter {
+	b.builds++
+	return []metricsstore.MetricsWriter{nopWriter{}}
+}
+
+func (b *countingBuilder) Rebuild() []metricsstore.MetricsWriter {
+	b.rebuilds++
+	return []metricsstore.MetricsWriter{nopWriter{}, nopWriter{}}
+}
+
+type nopWriter struct{}
+
+func (nopWriter) WriteAll(io.Writer) {}
+
+func TestReconfigure(t *testing.T) {
+	b := &countingBuilder{}
+	m := New(&options.Options{}, nil, b, false)
+
+	configured := 0
+	configure := func() {
+		configured++
+	}
+
+	// the stores are only configured before they were built
+	m.Reconfigure(configure)
+	if configured != 1 || b.builds != 0 || b.rebuilds != 0 {
+		t.Errorf("expected only the configuration before the build, got %d configurations, %d builds and %d rebuilds", configured, b.builds, b.rebuilds)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.ConfigureSharding(ctx, 0, 1)
+	if b.builds != 1 {
+		t.Errorf("expected 1 build, got %d", b.builds)
+	}
+	built := b.ctx
+
+	// the stores are rebuilt incrementally with the same context
+	m.Reconfigure(configure)
+	if configured != 2 || b.builds != 1 || b.rebuilds != 1 {
+		t.Errorf("expected a rebuild after the configuration, got %d configurations, %d builds and %d rebuilds", configured, b.builds, b.rebuilds)
+	}
+	if built.Err() != nil || b.ctx != built {
+		t.Error("expected the context of the stores to be kept")
+	}
+	if got := len(m.metricsWriters); got != 2 {
+		t.Errorf("expected the metrics writers of the rebuild, got %d", got)
+	}
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+package options
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"k8s.io/kube-state-metrics/v2/pkg/options"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the content of the configuration file set by --config. It covers
+// the flags which configure the stores. Every field which is set overrides the
+// corresponding flag, an empty list overrides the flag as well.
+type Config struct {
+	Resources                  []string            `json:"resources,omitempty"`
+	Namespaces                 []string            `json:"namespaces,omitempty"`
+	NamespacesDenylist         []string            `json:"namespacesDenylist,omitempty"`
+	MetricAllowlist            []string            `json:"metricAllowlist,omitempty"`
+	MetricDenylist             []string            `json:"metricDenylist,omitempty"`
+	MetricOptInList            []string            `json:"metricOptInList,omitempty"`
+	MetricLabelsAllowlist      map[string][]string `json:"metricLabelsAllowlist,omitempty"`
+	MetricAnnotationsAllowlist map[string][]string `json:"metricAnnotationsAllowlist,omitempty"`
+	ClusterSelector            *string             `json:"clusterSelector,omitempty"`
+	// Stores holds the settings of single resources.
+	Stores map[string]StoreConfig `json:"stores,omitempty"`
+}
+
+// StoreConfig holds the settings of the store of a single resource.
+type StoreConfig struct {
+	// Namespaces overrides the namespaces which are watched for the resource.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// MetricLabelsAllowlist overrides the label keys of the resource in the
+	// metric labels allowlist.
+	MetricLabelsAllowlist []string `json:"metricLabelsAllowlist,omitempty"`
+	// MetricAnnotationsAllowlist overrides the annotation keys of the resource
+	// in the metric annotations allowlist.
+	MetricAnnotationsAllowlist []string `json:"metricAnnotationsAllowlist,omitempty"`
+}
+
+// LoadConfig reads the configuration file. Unknown fields are rejected.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// WithConfig returns a copy of the options with the fields which are set in
+// the configuration applied.
+func (o *Options) WithConfig(c *Config) *Options {
+	ksmOpts := *o.Options
+	opts := *o
+	opts.Options = &ksmOpts
+
+	if c.Resources != nil {
+		opts.Resources = options.ResourceSet{}
+		for _, r := range c.Resources {
+			opts.Resources[r] = struct{}{}
+		}
+	}
+	if c.Namespaces != nil {
+		opts.Namespaces = c.Namespaces
+	}
+	if c.NamespacesDenylist != nil {
+		opts.NamespacesDenylist = c.NamespacesDenylist
+	}
+	if c.MetricAllowlist != nil {
+		opts.MetricAllowlist = metricSet(c.MetricAllowlist)
+	}
+	if c.MetricDenylist != nil {
+		opts.MetricDenylist = metricSet(c.MetricDenylist)
+	}
+	if c.MetricOptInList != nil {
+		opts.MetricOptInList = metricSet(c.MetricOptInList)
+	}
+	if c.MetricLabelsAllowlist != nil {
+		opts.LabelsAllowList = c.MetricLabelsAllowlist
+	}
+	if c.MetricAnnotationsAllowlist != nil {
+		opts.AnnotationsAllowList = c.MetricAnnotationsAllowlist
+	}
+	if c.ClusterSelector != nil {
+		opts.ClusterSelector = *c.ClusterSelector
+	}
+
+	opts.ResourceNamespaces = map[string]options.NamespaceList{}
+	for resource, s := range c.Stores {
+		if s.Namespaces != nil {
+			opts.ResourceNamespaces[resource] = s.Namespaces
+		}
+		if s.MetricLabelsAllowlist != nil {
+			opts.LabelsAllowList = withResource(opts.LabelsAllowList, resource, s.MetricLabelsAllowlist)
+		}
+		if s.MetricAnnotationsAllowlist != nil {
+			opts.AnnotationsAllowList = withResource(opts.AnnotationsAllowList, resource, s.MetricAnnotationsAllowlist)
+		}
+	}
+
+	return &opts
+}
+
+func metricSet(metrics []string) options.MetricSet {
+	s := options.MetricSet{}
+	for _, m := range metrics {
+		s[m] = struct{}{}
+	}
+	return s
+}
+
+// withResource returns a copy of the allowlist with the keys of the resource
+// replaced.
+func withResource(l options.LabelsAllowList, resource string, keys []string) options.LabelsAllowList {
+	allowList := options.LabelsAllowList{}
+	for r, k := range l {
+		allowList[r] = k
+	}
+	allowList[resource] = keys
+	return allowList
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: MIT
+
+package options
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/kube-state-metrics/v2/pkg/options"
+)
+
+func TestWithConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+resources: [clusters, machines]
+metricDenylist: []
+metricLabelsAllowlist:
+  clusters: [team]
+clusterSelector: env=prod
+stores:
+  machines:
+    namespaces: [ns1]
+    metricLabelsAllowlist: [app]
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := NewOptions()
+	o.Namespaces = options.NamespaceList{"ns1", "ns2"}
+	o.MetricDenylist = options.MetricSet{"capi_machine_info": {}}
+	o.LabelsAllowList = options.LabelsAllowList{"machinesets": {"app"}}
+
+	got := o.WithConfig(c)
+	if want := (options.ResourceSet{"clusters": {}, "machines": {}}); !reflect.DeepEqual(got.Resources, want) {
+		t.Errorf("expected resources %v, got %v", want, got.Resources)
+	}
+	if want := (options.NamespaceList{"ns1", "ns2"}); !reflect.DeepEqual(got.Namespaces, want) {
+		t.Errorf("expected namespaces of the flags %v, got %v", want, got.Namespaces)
+	}
+	if len(got.MetricDenylist) != 0 {
+		t.Errorf("expected the denylist to be overridden with an empty list, got %v", got.MetricDenylist)
+	}
+	if want := (options.LabelsAllowList{"clusters": {"team"}, "machines": {"app"}}); !reflect.DeepEqual(got.LabelsAllowList, want) {
+		t.Errorf("expected labels allowlist %v, got %v", want, got.LabelsAllowList)
+	}
+	if want := (map[string]options.NamespaceList{"machines": {"ns1"}}); !reflect.DeepEqual(got.ResourceNamespaces, want) {
+		t.Errorf("expected resource namespaces %v, got %v", want, got.ResourceNamespaces)
+	}
+	if got.ClusterSelector != "env=prod" {
+		t.Errorf("expected cluster selector env=prod, got %q", got.ClusterSelector)
+	}
+
+	// the original options are not modified
+	if len(o.Resources) != 0 || len(o.MetricDenylist) != 1 || len(o.LabelsAllowList) != 1 {
+		t.Errorf("expected the original options to be unchanged, got resources %v, denylist %v, labels allowlist %v", o.Resources, o.MetricDenylist, o.LabelsAllowList)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("resource: [clusters]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+
+package options
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// OTLPRequestHeaders returns the headers of --otlp-headers and
+// --otlp-headers-file.
+func (o *Options) OTLPRequestHeaders() (map[string]string, error) {
+	return mergeHeadersFile(o.OTLPHeaders, o.OTLPHeadersFile)
+}
+
+// RemoteWriteRequestHeaders returns the headers of --remote-write-headers and
+// --remote-write-headers-file.
+func (o *Options) RemoteWriteRequestHeaders() (map[string]string, error) {
+	return mergeHeadersFile(o.RemoteWriteHeaders, o.RemoteWriteHeadersFile)
+}
+
+// mergeHeadersFile returns the given headers and the headers of the file, which
+// take precedence. The headers are returned as is if the file is empty.
+func mergeHeadersFile(headers map[string]string, file string) (map[string]string, error) {
+	if file == "" {
+		return headers, nil
+	}
+
+	fileHeaders, err := readHeadersFile(file)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]string, len(headers)+len(fileHeaders))
+	for k, v := range headers {
+		merged[k] = v
+	}
+	for k, v := range fileHeaders {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// readHeadersFile reads a file with one header per line in the form
+// name=value. Empty lines and lines starting with # are skipped, so a secret
+// can be mounted as is.
+func readHeadersFile(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the headers file: %v", err)
+	}
+
+	headers := map[string]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := cut(line, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			// The value is not logged, as it may be a credential.
+			return nil, fmt.Errorf("line %d of the headers file %s is not in the form name=value", i+1, file)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// cut slices s around the first instance of sep like strings.Cut, which
+// requires Go 1.18.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2018 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The original file is located at [1].
+[1]: https://github.com/kubernetes/kube-state-metrics/blob/41eea36f69ef/pkg/options/options.go
+
+The original source was adjusted to:
+- embed the kube-state-metrics options instead of redefining them.
+- add flags which are specific to cluster-api-state-metrics.
+- use the cluster api resources as default resources.
+- rename the application in the help texts.
+*/
+
+package options
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-state-metrics/v2/pkg/options"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+
+	"github.com/daimler/cluster-api-state-metrics/pkg/otlp"
+	"github.com/daimler/cluster-api-state-metrics/pkg/store"
+)
+
+// DefaultResources are the resources which are enabled if --resources is not
+// set.
+var DefaultResources = store.DefaultResources
+
+// supportedClusterAPIVersions are the API versions of cluster api which can be
+// watched.
+var supportedClusterAPIVersions = []string{clusterv1.GroupVersion.Version}
+
+// Options are the configurable parameters for cluster-api-state-metrics.
+type Options struct {
+	*options.Options
+
+	Config                            string
+	ClusterAPIVersion                 string
+	MachineDeploymentProgressDeadline time.Duration
+	MachineProvisioningThreshold      time.Duration
+	MachineDeletionThreshold          time.Duration
+	WorkloadClusterNodes              bool
+	WorkloadClusterSyncInterval       time.Duration
+	ControlPlaneProbe                 bool
+	ControlPlaneProbeInterval         time.Duration
+	ControlPlaneProbeTimeout          time.Duration
+	ClusterSelector                   string
+	TLSCertFile                       string
+	TLSPrivateKeyFile                 string
+	TLSClientCAFile                   string
+	MetricsAuth                       bool
+	ShutdownTimeout                   time.Duration
+	ListPageSize                      int64
+	WatchFailureThreshold             time.Duration
+	StaleStoreThreshold               time.Duration
+	MetricsAuthCacheTTL               time.Duration
+	ManagementClusterContexts         []string
+	ManagementClusterKubeconfigs      []string
+	OTLPEndpoint                      string
+	OTLPProtocol                      string
+	OTLPInterval                      time.Duration
+	OTLPTimeout                       time.Duration
+	OTLPInsecure                      bool
+	OTLPHeaders                       map[string]string
+	OTLPHeadersFile                   string
+	RemoteWriteURL                    string
+	RemoteWriteInterval               time.Duration
+	RemoteWriteTimeout                time.Duration
+	RemoteWriteQueueCapacity          int
+	RemoteWriteMaxRetries             int
+	RemoteWriteHeaders                map[string]string
+	RemoteWriteHeadersFile            string
+	// ResourceNamespaces overrides the namespaces of single resources. It is
+	// only set by the configuration file.
+	ResourceNamespaces map[string]options.NamespaceList
+
+	flags *pflag.FlagSet
+}
+
+// NewOptions returns a new instance of `Options`.
+func NewOptions() *Options {
+	return &Options{
+		Options: options.NewOptions(),
+	}
+}
+
+// AddFlags populated the Options struct from the command line arguments passed.
+func (o *Options) AddFlags() {
+	o.flags = pflag.NewFlagSet("", pflag.ExitOnError)
+	// add klog flags
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	o.flags.AddGoFlagSet(klogFlags)
+	o.flags.Lookup("logtostderr").Value.Set("true")
+	o.flags.Lookup("logtostderr").DefValue = "true"
+	o.flags.Lookup("logtostderr").NoOptDefVal = "true"
+
+	o.flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		o.flags.PrintDefaults()
+	}
+
+	o.flags.BoolVarP(&o.UseAPIServerCache, "use-apiserver-cache", "", false, "Sets resourceVersion=0 for ListWatch requests, using cached resources from the apiserver instead of an etcd quorum read.")
+	o.flags.Int64Var(&o.ListPageSize, "list-page-size", 500, "Number of objects per request when listing a resource. The objects are listed in chunks using continue tokens. The apiserver ignores it if --use-apiserver-cache is set. Set to 0 to list all objects in a single request.")
+	o.flags.StringVar(&o.Apiserver, "apiserver", "", `The URL of the apiserver to use as a master`)
+	o.flags.StringVar(&o.Kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig file")
+	o.flags.StringVar(&o.TLSConfig, "tls-config", "", "Path to the TLS configuration file")
+	o.flags.BoolVarP(&o.Help, "help", "h", false, "Print Help text")
+	o.flags.IntVar(&o.Port, "port", 8080, `Port to expose metrics on.`)
+	o.flags.StringVar(&o.Host, "host", "::", `Host to expose metrics on.`)
+	o.flags.IntVar(&o.TelemetryPort, "telemetry-port", 8081, `Port to expose cluster-api-state-metrics self metrics on.`)
+	o.flags.StringVar(&o.TelemetryHost, "telemetry-host", "::", `Host to expose cluster-api-state-metrics self metrics on.`)
+	o.flags.Var(&o.Resources, "resources", fmt.Sprintf("Comma-separated list of Resources to be enabled. Defaults to %q", &DefaultResources))
+	o.flags.Var(&o.Namespaces, "namespaces", fmt.Sprintf("Comma-separated list of namespaces to be watched. Defaults to %q", &options.DefaultNamespaces))
+	o.flags.Var(&o.NamespacesDenylist, "namespaces-denylist", "Comma-separated list of namespaces not to be watched. If namespaces and namespaces-denylist are both set, only namespaces that are excluded in namespaces-denylist will be used.")
+	o.flags.Var(&o.MetricAllowlist, "metric-allowlist", "Comma-separated list of metrics to be exposed. This list comprises of exact metric names and/or regex patterns. The allowlist and denylist are mutually exclusive.")
+	o.flags.Var(&o.MetricDenylist, "metric-denylist", "Comma-separated list of metrics not to be enabled. This list comprises of exact metric names and/or regex patterns. The allowlist and denylist are mutually exclusive.")
+	o.flags.Var(&o.MetricOptInList, "metric-opt-in-list", "Comma-separated list of metrics which are opt-in and not enabled by default. This is in addition to the metric allow- and denylists")
+	o.flags.Var(&o.AnnotationsAllowList, "metric-annotations-allowlist", "Comma-separated list of Kubernetes annotations keys that will be used in the resource' labels metric. By default the metric contains only name and namespace labels. To include additional annotations provide a list of resource names in their plural form and Kubernetes annotation keys you would like to allow for them (Example: '=clusters=[kubernetes.io/team,...],machines=[kubernetes.io/team],...)'. A single '*' can be provided per resource instead to allow any annotations, but that has severe performance implications (Example: '=machines=[*]').")
+	o.flags.Var(&o.LabelsAllowList, "metric-labels-allowlist", "Comma-separated list of additional Kubernetes label keys that will be used in the resource' labels metric. By default the metric contains only name and namespace labels. To include additional labels provide a list of resource names in their plural form and Kubernetes label keys you would like to allow for them (Example: '=clusters=[k8s-label-1,k8s-label-n,...],machines=[app],...)'. A single '*' can be provided per resource instead to allow any labels, but that has severe performance implications (Example: '=machines=[*]').")
+	o.flags.Int32Var(&o.Shard, "shard", int32(0), "The instances shard nominal (zero indexed) within the total number of shards. (default 0)")
+	o.flags.IntVar(&o.TotalShards, "total-shards", 1, "The total number of shards. Sharding is disabled when total shards is set to 1.")
+
+	autoshardingNotice := "When set, it is expected that --pod and --pod-namespace are both set. Most likely this should be passed via the downward API. This is used for auto-detecting sharding. If set, this has preference over statically configured sharding. This is experimental, it may be removed without notice."
+
+	o.flags.StringVar(&o.Pod, "pod", "", "Name of the pod that contains the cluster-api-state-metrics container. "+autoshardingNotice)
+	o.flags.StringVar(&o.Namespace, "pod-namespace", "", "Name of the namespace of the pod specified by --pod. "+autoshardingNotice)
+	o.flags.BoolVarP(&o.Version, "version", "", false, "cluster-api-state-metrics build version information")
+	o.flags.BoolVar(&o.EnableGZIPEncoding, "enable-gzip-encoding", false, "Gzip responses when requested by clients via 'Accept-Encoding: gzip' header.")
+
+	o.flags.StringVar(&o.Config, "config", "", "Path to a YAML configuration file of the stores. Its settings override the corresponding flags. The stores are rebuilt when the file changes.")
+	o.flags.StringVar(&o.ClusterAPIVersion, "cluster-api-version", clusterv1.GroupVersion.Version, fmt.Sprintf("API version of the cluster api resources to watch. Supported versions: %s", strings.Join(supportedClusterAPIVersions, ", ")))
+	o.flags.DurationVar(&o.MachineDeploymentProgressDeadline, "machinedeployment-progress-deadline", 15*time.Minute, "Duration after which a machinedeployment rollout without a change in its updated replicas is considered as stalled.")
+	o.flags.DurationVar(&o.MachineProvisioningThreshold, "machine-provisioning-threshold", 30*time.Minute, "Duration after which a machine in the Pending, Provisioning or Provisioned phase is considered as stuck.")
+	o.flags.DurationVar(&o.MachineDeletionThreshold, "machine-deletion-threshold", 30*time.Minute, "Duration after which a deleted machine which still exists is considered as stuck.")
+	o.flags.BoolVar(&o.WorkloadClusterNodes, "workload-cluster-nodes", false, "Connect to the workload clusters using their kubeconfig secret to compare their nodes with the machines.")
+	o.flags.DurationVar(&o.WorkloadClusterSyncInterval, "workload-cluster-sync-interval", time.Minute, "Interval in which the nodes of the workload clusters are listed if --workload-cluster-nodes is enabled.")
+	o.flags.BoolVar(&o.ControlPlaneProbe, "control-plane-probe", false, "Probe the /readyz endpoint of the control plane of each cluster using the certificate authority of the cluster.")
+	o.flags.DurationVar(&o.ControlPlaneProbeInterval, "control-plane-probe-interval", time.Minute, "Interval in which the control plane endpoints are probed if --control-plane-probe is enabled.")
+	o.flags.DurationVar(&o.ControlPlaneProbeTimeout, "control-plane-probe-timeout", 10*time.Second, "Timeout of a single probe of a control plane endpoint.")
+	o.flags.StringVar(&o.ClusterSelector, "cluster-selector", "", "Label selector of the clusters whose metrics are exposed. Machines, machinesets, machinedeployments, kubeadmcontrolplanes and secrets are only exposed if their cluster matches the selector.")
+	o.flags.StringVar(&o.TLSCertFile, "tls-cert-file", "", "Path to the certificate used to serve the metrics and telemetry endpoints with HTTPS. The certificate and key are reloaded when the files change. Cannot be combined with --tls-config.")
+	o.flags.StringVar(&o.TLSPrivateKeyFile, "tls-private-key-file", "", "Path to the private key of --tls-cert-file.")
+	o.flags.StringVar(&o.TLSClientCAFile, "tls-client-ca-file", "", "Path to the certificate authority used to verify client certificates if --tls-cert-file is set. Requests without a verified client certificate are rejected, except for /healthz, /readyz and /livez.")
+	o.flags.DurationVar(&o.WatchFailureThreshold, "watch-failure-threshold", 10*time.Minute, "Duration after which failing list or watch requests of a store make /livez fail. Set to 0 to disable the check.")
+	o.flags.DurationVar(&o.StaleStoreThreshold, "stale-store-threshold", 0, "Duration after which a store without a successful list or watch request or watch event is considered as stale. The metrics endpoints respond with 503 Service Unavailable while a store is stale. It should be longer than the watch timeout of 10m0s. Set to 0 to always serve the metrics.")
+	o.flags.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", 5*time.Second, "Duration to wait for in-flight requests of the metrics and telemetry servers on SIGTERM or SIGINT before exiting. It should be shorter than the termination grace period of the pod.")
+	o.flags.BoolVar(&o.MetricsAuth, "metrics-auth", false, "Authenticate requests of the metrics endpoints with a bearer token using a TokenReview and only expose the objects in namespaces where the user can list the resource.")
+	o.flags.DurationVar(&o.MetricsAuthCacheTTL, "metrics-auth-cache-ttl", time.Minute, "Duration for which the results of token and subject access reviews are cached if --metrics-auth is enabled.")
+	o.flags.StringSliceVar(&o.ManagementClusterContexts, "management-cluster-contexts", nil, "Comma-separated list of contexts of the kubeconfig of management clusters to watch. The context name is added as management_cluster label to all metrics.")
+	o.flags.StringSliceVar(&o.ManagementClusterKubeconfigs, "management-cluster-kubeconfigs", nil, "Comma-separated list of kubeconfig files of management clusters to watch using their current context. Each entry may be prefixed with '<name>=' to set the management_cluster label, which defaults to the name of the current context.")
+	o.flags.StringVar(&o.OTLPEndpoint, "otlp-endpoint", "", "Endpoint of an OpenTelemetry collector to push the metrics to with OTLP, e.g. otel-collector:4317 for gRPC or https://otel-collector:4318 for HTTP. The path /v1/metrics is used if the HTTP endpoint has no path. Pushing is disabled if empty.")
+	o.flags.StringVar(&o.OTLPProtocol, "otlp-protocol", otlp.ProtocolGRPC, fmt.Sprintf("Protocol used to push the metrics to --otlp-endpoint. Supported protocols: %s", strings.Join(otlp.Protocols, ", ")))
+	o.flags.DurationVar(&o.OTLPInterval, "otlp-interval", time.Minute, "Interval in which the metrics are pushed to --otlp-endpoint.")
+	o.flags.DurationVar(&o.OTLPTimeout, "otlp-timeout", 10*time.Second, "Timeout of a single push to --otlp-endpoint.")
+	o.flags.BoolVar(&o.OTLPInsecure, "otlp-insecure", false, "Push the metrics to --otlp-endpoint without TLS. HTTP endpoints with a scheme use their scheme instead.")
+	o.flags.StringToStringVar(&o.OTLPHeaders, "otlp-headers", nil, "Comma-separated list of headers sent with every push to --otlp-endpoint, e.g. 'x-scope-orgid=team-a'. Flags are visible in the process list, so set credentials with --otlp-headers-file instead.")
+	o.flags.StringVar(&o.OTLPHeadersFile, "otlp-headers-file", "", "File with one header per line in the form name=value, e.g. 'authorization=Bearer <token>', sent with every push to --otlp-endpoint. It is read on startup and its headers take precedence over --otlp-headers.")
+	o.flags.StringVar(&o.RemoteWriteURL, "remote-write-url", "", "URL of a Prometheus remote write endpoint to push the metrics to, e.g. https://prometheus.example.com/api/v1/write. Pushing is disabled if empty.")
+	o.flags.DurationVar(&o.RemoteWriteInterval, "remote-write-interval", time.Minute, "Interval in which the metrics are pushed to --remote-write-url.")
+	o.flags.DurationVar(&o.RemoteWriteTimeout, "remote-write-timeout", 30*time.Second, "Timeout of a single write request to --remote-write-url.")
+	o.flags.IntVar(&o.RemoteWriteQueueCapacity, "remote-write-queue-capacity", 10, "Maximum number of pending write requests of at most 2000 samples each. The oldest requests are dropped if the queue is full.")
+	o.flags.IntVar(&o.RemoteWriteMaxRetries, "remote-write-max-retries", 5, "Number of retries of a write request which failed with a network error, 5xx or 429 response before it is dropped. The retries back off exponentially from 500ms up to 30s.")
+	o.flags.StringToStringVar(&o.RemoteWriteHeaders, "remote-write-headers", nil, "Comma-separated list of headers sent with every write request to --remote-write-url, e.g. 'x-scope-orgid=team-a'. Flags are visible in the process list, so set credentials with --remote-write-headers-file instead.")
+	o.flags.StringVar(&o.RemoteWriteHeadersFile, "remote-write-headers-file", "", "File with one header per line in the form name=value, e.g. 'authorization=Bearer <token>', sent with every write request to --remote-write-url. It is read on startup and its headers take precedence over --remote-write-headers.")
+}
+
+// Parse parses the flag definitions from the argument list.
+func (o *Options) Parse() error {
+	err := o.flags.Parse(os.Args)
+	return err
+}
+
+// Validate returns an error if the options are invalid.
+func (o *Options) Validate() error {
+	supported := false
+	for _, v := range supportedClusterAPIVersions {
+		if o.ClusterAPIVersion == v {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported cluster api version %q, supported versions: %s", o.ClusterAPIVersion, strings.Join(supportedClusterAPIVersions, ", "))
+	}
+
+	for name, d := range map[string]time.Duration{
+		"machinedeployment-progress-deadline": o.MachineDeploymentProgressDeadline,
+		"machine-provisioning-threshold":      o.MachineProvisioningThreshold,
+		"machine-deletion-threshold":          o.MachineDeletionThreshold,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("--%s must be positive, got %s", name, d)
+		}
+	}
+
+	if o.StaleStoreThreshold < 0 {
+		return fmt.Errorf("--stale-store-threshold must not be negative, got %s", o.StaleStoreThreshold)
+	}
+	if o.WatchFailureThreshold < 0 {
+		return fmt.Errorf("--watch-failure-threshold must not be negative, got %s", o.WatchFailureThreshold)
+	}
+	if o.MetricsAuth && o.MetricsAuthCacheTTL <= 0 {
+		return fmt.Errorf("--metrics-auth-cache-ttl must be positive, got %s", o.MetricsAuthCacheTTL)
+	}
+	if o.ListPageSize < 0 {
+		return fmt.Errorf("--list-page-size must not be negative, got %d", o.ListPageSize)
+	}
+	if o.OTLPEndpoint != "" {
+		if err := o.validateOTLP(); err != nil {
+			return err
+		}
+	}
+	if o.RemoteWriteURL != "" {
+		if err := o.validateRemoteWrite(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Options) validateRemoteWrite() error {
+	u, err := url.Parse(o.RemoteWriteURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("--remote-write-url must be an http or https URL, got %q", o.RemoteWriteURL)
+	}
+	for name, d := range map[string]time.Duration{
+		"remote-write-interval": o.RemoteWriteInterval,
+		"remote-write-timeout":  o.RemoteWriteTimeout,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("--%s must be positive, got %s", name, d)
+		}
+	}
+	if o.RemoteWriteQueueCapacity <= 0 {
+		return fmt.Errorf("--remote-write-queue-capacity must be positive, got %d", o.RemoteWriteQueueCapacity)
+	}
+	if o.RemoteWriteMaxRetries < 0 {
+		return fmt.Errorf("--remote-write-max-retries must not be negative, got %d", o.RemoteWriteMaxRetries)
+	}
+	if _, err := o.RemoteWriteRequestHeaders(); err != nil {
+		return fmt.Errorf("invalid --remote-write-headers-file: %v", err)
+	}
+	return nil
+}
+
+func (o *Options) validateOTLP() error {
+	supported := false
+	for _, p := range otlp.Protocols {
+		if o.OTLPProtocol == p {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported OTLP protocol %q, supported protocols: %s", o.OTLPProtocol, strings.Join(otlp.Protocols, ", "))
+	}
+	for name, d := range map[string]time.Duration{
+		"otlp-interval": o.OTLPInterval,
+		"otlp-timeout":  o.OTLPTimeout,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("--%s must be positive, got %s", name, d)
+		}
+	}
+	if _, err := o.OTLPRequestHeaders(); err != nil {
+		return fmt.Errorf("invalid --otlp-headers-file: %v", err)
+	}
+	return nil
+}
+
+// StuckThresholds returns the thresholds after which objects are considered as
+// stuck.
+func (o *Options) StuckThresholds() store.StuckThresholds {
+	return store.StuckThresholds{
+		MachineDeploymentProgressDeadline: o.MachineDeploymentProgressDeadline,
+		MachineProvisioning:               o.MachineProvisioningThreshold,
+		MachineDeletion:                   o.MachineDeletionThreshold,
+	}
+}
+
+// Usage is the function called when an error occurs while parsing flags.
+func (o *Options) Usage() {
+	o.flags.Usage()
+}
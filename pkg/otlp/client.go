@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultHTTPPath is the path of the metrics of the OTLP HTTP receiver.
+const defaultHTTPPath = "/v1/metrics"
+
+// grpcClient exports the metrics with the MetricsService of the collector.
+type grpcClient struct {
+	conn    *grpc.ClientConn
+	service colmetricspb.MetricsServiceClient
+	headers metadata.MD
+}
+
+func newGRPCClient(cfg Config) (*grpcClient, error) {
+	creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if cfg.Insecure {
+		creds = insecure.NewCredentials()
+	}
+	// The connection is established lazily, so an unavailable collector
+	// does not prevent the start.
+	conn, err := grpc.Dial(cfg.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP gRPC client: %v", err)
+	}
+	return &grpcClient{
+		conn:    conn,
+		service: colmetricspb.NewMetricsServiceClient(conn),
+		headers: metadata.New(cfg.Headers),
+	}, nil
+}
+
+func (c *grpcClient) export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	if len(c.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, c.headers)
+	}
+	_, err := c.service.Export(ctx, req)
+	return err
+}
+
+func (c *grpcClient) close() error {
+	return c.conn.Close()
+}
+
+// httpClient exports the metrics as protobuf to the HTTP receiver of the
+// collector.
+type httpClient struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPClient(cfg Config) (*httpClient, error) {
+	endpoint := cfg.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		if cfg.Insecure {
+			endpoint = "http://" + endpoint
+		} else {
+			endpoint = "https://" + endpoint
+		}
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP HTTP endpoint %q: %v", cfg.Endpoint, err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultHTTPPath
+	}
+	return &httpClient{
+		url:     u.String(),
+		headers: cfg.Headers,
+		client:  &http.Client{},
+	}, nil
+}
+
+func (c *httpClient) export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range c.headers {
+		r.Header.Set(k, v)
+	}
+
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("collector responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	// Drain the body, so the connection can be reused.
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	return err
+}
+
+func (c *httpClient) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
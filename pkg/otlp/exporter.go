@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: MIT
+
+// Package otlp pushes the generated metrics to an OpenTelemetry collector with
+// the OpenTelemetry protocol (OTLP).
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ProtocolGRPC pushes the metrics with OTLP over gRPC.
+	ProtocolGRPC = "grpc"
+	// ProtocolHTTP pushes the metrics with OTLP over HTTP using protobuf.
+	ProtocolHTTP = "http/protobuf"
+)
+
+// Protocols are the supported protocols.
+var Protocols = []string{ProtocolGRPC, ProtocolHTTP}
+
+const (
+	serviceName = "cluster-api-state-metrics"
+	scopeName   = "github.com/daimler/cluster-api-state-metrics"
+)
+
+// Config configures the Exporter.
+type Config struct {
+	// Endpoint is the host and port of the collector for gRPC or the URL of
+	// the collector for HTTP. The path /v1/metrics is used if the URL has
+	// no path.
+	Endpoint string
+	Protocol string
+	// Interval is the interval in which the metrics are pushed.
+	Interval time.Duration
+	// Timeout is the timeout of a single push.
+	Timeout time.Duration
+	// Insecure disables TLS for gRPC and for HTTP endpoints without scheme.
+	Insecure bool
+	// Headers are sent with every push, e.g. for authentication.
+	Headers map[string]string
+}
+
+// client sends the metrics to the collector.
+type client interface {
+	export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error
+	close() error
+}
+
+// Exporter periodically pushes the metric families of a gatherer to an
+// OpenTelemetry collector. Gauges are pushed as OTLP gauges and counters as
+// cumulative monotonic sums, with the labels as attributes.
+type Exporter struct {
+	gatherer prometheus.Gatherer
+	client   client
+	interval time.Duration
+	timeout  time.Duration
+	resource *resourcepb.Resource
+	// start is the start time of the cumulative sums.
+	start time.Time
+	now   func() time.Time
+}
+
+// NewExporter returns an Exporter which pushes the metric families of the
+// gatherer as configured.
+func NewExporter(cfg Config, gatherer prometheus.Gatherer) (*Exporter, error) {
+	var c client
+	var err error
+	switch cfg.Protocol {
+	case ProtocolGRPC:
+		c, err = newGRPCClient(cfg)
+	case ProtocolHTTP:
+		c, err = newHTTPClient(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q", cfg.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Exporter{
+		gatherer: gatherer,
+		client:   c,
+		interval: cfg.Interval,
+		timeout:  cfg.Timeout,
+		resource: &resourcepb.Resource{
+			Attributes: []*commonpb.KeyValue{
+				stringAttribute("service.name", serviceName),
+				stringAttribute("service.version", version.Version),
+			},
+		},
+		start: time.Now(),
+		now:   time.Now,
+	}, nil
+}
+
+// Run pushes the metrics in the configured interval until the context is done.
+// Failed pushes are logged and retried in the next interval.
+func (e *Exporter) Run(ctx context.Context) error {
+	defer e.client.close()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := e.Push(ctx); err != nil {
+				klog.Errorf("Failed to push the metrics with OTLP: %v", err)
+			}
+		}
+	}
+}
+
+// Push gathers the metrics and pushes them once.
+func (e *Exporter) Push(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return e.client.export(ctx, e.request(families))
+}
+
+// request converts the metric families to an export request. Families without
+// metrics and of unsupported types are skipped.
+func (e *Exporter) request(families []*dto.MetricFamily) *colmetricspb.ExportMetricsServiceRequest {
+	now := uint64(e.now().UnixNano())
+	start := uint64(e.start.UnixNano())
+
+	var metrics []*metricspb.Metric
+	for _, f := range families {
+		if len(f.GetMetric()) == 0 {
+			continue
+		}
+
+		m := &metricspb.Metric{
+			Name:        f.GetName(),
+			Description: f.GetHelp(),
+		}
+		switch f.GetType() {
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			points := make([]*metricspb.NumberDataPoint, 0, len(f.GetMetric()))
+			for _, pm := range f.GetMetric() {
+				value := pm.GetGauge().GetValue()
+				if pm.Untyped != nil {
+					value = pm.GetUntyped().GetValue()
+				}
+				points = append(points, dataPoint(pm, value, 0, now))
+			}
+			m.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: points}}
+		case dto.MetricType_COUNTER:
+			points := make([]*metricspb.NumberDataPoint, 0, len(f.GetMetric()))
+			for _, pm := range f.GetMetric() {
+				points = append(points, dataPoint(pm, pm.GetCounter().GetValue(), start, now))
+			}
+			m.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				DataPoints:             points,
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}}
+		default:
+			klog.V(4).Infof("Skipping metric %s of unsupported type %s", f.GetName(), f.GetType())
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{
+				Resource: e.resource,
+				ScopeMetrics: []*metricspb.ScopeMetrics{
+					{
+						Scope:   &commonpb.InstrumentationScope{Name: scopeName, Version: version.Version},
+						Metrics: metrics,
+					},
+				},
+			},
+		},
+	}
+}
+
+// dataPoint returns the data point of the metric with its labels as
+// attributes. Labels with empty values are omitted, as they are equal to
+// missing labels in Prometheus.
+func dataPoint(m *dto.Metric, value float64, start, now uint64) *metricspb.NumberDataPoint {
+	attributes := make([]*commonpb.KeyValue, 0, len(m.GetLabel()))
+	for _, l := range m.GetLabel() {
+		if l.GetValue() == "" {
+			continue
+		}
+		attributes = append(attributes, stringAttribute(l.GetName(), l.GetValue()))
+	}
+	return &metricspb.NumberDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+	}
+}
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: MIT
+
+package otlp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const testMetrics = `# HELP capi_cluster_info Information about a cluster.
+# TYPE capi_cluster_info gauge
+capi_cluster_info{management_cluster="",namespace="ns1",cluster="c1",topology_version="v1.22.4"} 1
+# HELP capi_cluster_status_phase The clusters current phase.
+# TYPE capi_cluster_status_phase gauge
+capi_cluster_status_phase{management_cluster="",namespace="ns1",cluster="c1",phase="Provisioned"} 1
+capi_cluster_status_phase{management_cluster="",namespace="ns1",cluster="c1",phase="Failed"} 0
+# HELP capi_machine_created Unix creation timestamp.
+# TYPE capi_machine_created gauge
+# HELP capi_test_total Test counter.
+# TYPE capi_test_total counter
+capi_test_total{namespace="ns1"} 3
+`
+
+// testGatherer returns the metric families of testMetrics.
+func testGatherer() prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		var parser expfmt.TextParser
+		parsed, err := parser.TextToMetricFamilies(strings.NewReader(testMetrics))
+		if err != nil {
+			return nil, err
+		}
+		var families []*dto.MetricFamily
+		for _, name := range []string{"capi_cluster_info", "capi_cluster_status_phase", "capi_machine_created", "capi_test_total"} {
+			families = append(families, parsed[name])
+		}
+		return families, nil
+	})
+}
+
+// verifyRequest checks the metrics of a request of testMetrics.
+func verifyRequest(t *testing.T, req *colmetricspb.ExportMetricsServiceRequest) {
+	t.Helper()
+
+	if len(req.ResourceMetrics) != 1 || len(req.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("expected a single resource and scope, got %v", req.ResourceMetrics)
+	}
+	if got := req.ResourceMetrics[0].Resource.Attributes[0]; got.Key != "service.name" || got.Value.GetStringValue() != serviceName {
+		t.Errorf("expected service.name %s, got %v", serviceName, got)
+	}
+
+	metrics := map[string]*metricspb.Metric{}
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	if len(metrics) != 3 {
+		t.Errorf("expected 3 metrics without the empty family, got %d", len(metrics))
+	}
+
+	info := metrics["capi_cluster_info"].GetGauge()
+	if info == nil || len(info.DataPoints) != 1 {
+		t.Fatalf("expected capi_cluster_info as gauge with one data point, got %v", metrics["capi_cluster_info"])
+	}
+	attributes := map[string]string{}
+	for _, a := range info.DataPoints[0].Attributes {
+		attributes[a.Key] = a.Value.GetStringValue()
+	}
+	if len(attributes) != 3 || attributes["cluster"] != "c1" || attributes["topology_version"] != "v1.22.4" {
+		t.Errorf("expected the non-empty labels as attributes, got %v", attributes)
+	}
+	if got := info.DataPoints[0].GetAsDouble(); got != 1 {
+		t.Errorf("expected value 1, got %v", got)
+	}
+
+	if got := len(metrics["capi_cluster_status_phase"].GetGauge().GetDataPoints()); got != 2 {
+		t.Errorf("expected a data point per phase, got %d", got)
+	}
+
+	sum := metrics["capi_test_total"].GetSum()
+	if sum == nil || !sum.IsMonotonic || sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Fatalf("expected capi_test_total as cumulative monotonic sum, got %v", metrics["capi_test_total"])
+	}
+	if p := sum.DataPoints[0]; p.GetAsDouble() != 3 || p.StartTimeUnixNano == 0 || p.StartTimeUnixNano > p.TimeUnixNano {
+		t.Errorf("expected value 3 with start time, got %v", p)
+	}
+}
+
+// fakeCollector records the requests of the gRPC MetricsService.
+type fakeCollector struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+
+	mtx      sync.Mutex
+	requests []*colmetricspb.ExportMetricsServiceRequest
+	md       metadata.MD
+}
+
+func (c *fakeCollector) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.requests = append(c.requests, req)
+	c.md, _ = metadata.FromIncomingContext(ctx)
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestExporterGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server := grpc.NewServer()
+	collector := &fakeCollector{}
+	colmetricspb.RegisterMetricsServiceServer(server, collector)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	e, err := NewExporter(Config{
+		Endpoint: lis.Addr().String(),
+		Protocol: ProtocolGRPC,
+		Interval: 10 * time.Millisecond,
+		Timeout:  time.Second,
+		Insecure: true,
+		Headers:  map[string]string{"authorization": "Bearer token"},
+	}, testGatherer())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		collector.mtx.Lock()
+		n := len(collector.requests)
+		collector.mtx.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected periodic pushes, got %d", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	collector.mtx.Lock()
+	defer collector.mtx.Unlock()
+	verifyRequest(t, collector.requests[0])
+	if got := collector.md.Get("authorization"); len(got) != 1 || got[0] != "Bearer token" {
+		t.Errorf("expected the authorization header, got %v", got)
+	}
+}
+
+func TestExporterHTTP(t *testing.T) {
+	var req *colmetricspb.ExportMetricsServiceRequest
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != defaultHTTPPath {
+			t.Errorf("expected path %s, got %s", defaultHTTPPath, r.URL.Path)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/x-protobuf" {
+			t.Errorf("expected protobuf content type, got %s", got)
+		}
+		if got := r.Header.Get("X-Scope-OrgID"); got != "tenant1" {
+			t.Errorf("expected the tenant header, got %q", got)
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		req = &colmetricspb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("collector is overloaded"))
+	}))
+	defer server.Close()
+
+	e, err := NewExporter(Config{
+		Endpoint: strings.TrimPrefix(server.URL, "http://"),
+		Protocol: ProtocolHTTP,
+		Interval: time.Minute,
+		Timeout:  time.Second,
+		Insecure: true,
+		Headers:  map[string]string{"X-Scope-OrgID": "tenant1"},
+	}, testGatherer())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := e.Push(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	verifyRequest(t, req)
+
+	status = http.StatusServiceUnavailable
+	if err := e.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "collector is overloaded") {
+		t.Errorf("expected the error of the collector, got %v", err)
+	}
+}
+
+func TestNewExporterRejectsUnsupportedProtocol(t *testing.T) {
+	if _, err := NewExporter(Config{Endpoint: "localhost:4317", Protocol: "http/json"}, testGatherer()); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: MIT
+
+package remotewrite
+
+import (
+	"math"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"k8s.io/klog/v2"
+)
+
+// The field numbers of the messages of the remote write protocol, see
+// https://github.com/prometheus/prometheus/blob/main/prompb/types.proto.
+// The messages are encoded directly, as their generated types are only
+// available as part of the Prometheus server module.
+const (
+	writeRequestTimeseries protowire.Number = 1
+	timeSeriesLabels       protowire.Number = 1
+	timeSeriesSamples      protowire.Number = 2
+	labelName              protowire.Number = 1
+	labelValue             protowire.Number = 2
+	sampleValue            protowire.Number = 1
+	sampleTimestamp        protowire.Number = 2
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+// timeSeries is a series with a single sample.
+type timeSeries struct {
+	// labels are sorted by name and include the metric name.
+	labels []label
+	value  float64
+}
+
+// newTimeSeries returns the series of the metric families. Labels with empty
+// values are omitted, as they are equal to missing labels. Families of other
+// types than gauges and counters are skipped.
+func newTimeSeries(families []*dto.MetricFamily) []timeSeries {
+	var series []timeSeries
+	for _, f := range families {
+		switch f.GetType() {
+		case dto.MetricType_GAUGE, dto.MetricType_COUNTER, dto.MetricType_UNTYPED:
+		default:
+			klog.V(4).Infof("Skipping metric %s of unsupported type %s", f.GetName(), f.GetType())
+			continue
+		}
+
+		for _, m := range f.GetMetric() {
+			labels := make([]label, 0, len(m.GetLabel())+1)
+			labels = append(labels, label{name: "__name__", value: f.GetName()})
+			for _, l := range m.GetLabel() {
+				if l.GetValue() != "" {
+					labels = append(labels, label{name: l.GetName(), value: l.GetValue()})
+				}
+			}
+			sort.Slice(labels, func(i, j int) bool {
+				return labels[i].name < labels[j].name
+			})
+
+			value := m.GetGauge().GetValue()
+			switch {
+			case m.Counter != nil:
+				value = m.GetCounter().GetValue()
+			case m.Untyped != nil:
+				value = m.GetUntyped().GetValue()
+			}
+			series = append(series, timeSeries{labels: labels, value: value})
+		}
+	}
+	return series
+}
+
+// encodeWriteRequest returns the protobuf encoded WriteRequest of the series
+// with their samples at the timestamp in milliseconds.
+func encodeWriteRequest(series []timeSeries, timestamp int64) []byte {
+	var req, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, labelName, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.name)
+			msg = protowire.AppendTag(msg, labelValue, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.value)
+			ts = protowire.AppendTag(ts, timeSeriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, sampleValue, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, sampleTimestamp, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(timestamp))
+		ts = protowire.AppendTag(ts, timeSeriesSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		req = protowire.AppendTag(req, writeRequestTimeseries, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
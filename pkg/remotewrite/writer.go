@@ -0,0 +1,380 @@
+// SPDX-License-Identifier: MIT
+
+// Package remotewrite pushes the generated metrics with the Prometheus remote
+// write protocol.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/version"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maxSamplesPerSend is the maximum number of samples of a single write
+	// request, like the default of Prometheus.
+	maxSamplesPerSend = 2000
+	minBackoff        = 500 * time.Millisecond
+	maxBackoff        = 30 * time.Second
+	readyInterval     = time.Second
+)
+
+// staleNaN is the value of a stale marker, which ends a series in Prometheus
+// like a series that disappeared from a scrape.
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// Config configures the Writer.
+type Config struct {
+	// URL is the remote write endpoint, e.g.
+	// https://prometheus.example.com/api/v1/write.
+	URL string
+	// Interval is the interval in which the metrics are rendered and queued.
+	Interval time.Duration
+	// Timeout is the timeout of a single write request.
+	Timeout time.Duration
+	// QueueCapacity is the maximum number of pending write requests. The
+	// oldest requests are dropped if the queue is full.
+	QueueCapacity int
+	// MaxRetries is the number of retries of a write request which failed
+	// with a recoverable error before it is dropped.
+	MaxRetries int
+	// Headers are sent with every write request, e.g. for authentication.
+	Headers map[string]string
+	// Ready returns an error until the stores are synced. The metrics are
+	// pushed once as soon as it succeeds and then in the interval. It may be
+	// nil.
+	Ready func() error
+}
+
+// Metrics are the self metrics of the Writer.
+type Metrics struct {
+	Samples     *prometheus.CounterVec
+	Retries     prometheus.Counter
+	QueueLength prometheus.Gauge
+}
+
+// NewMetrics registers the self metrics of the Writer with the registerer.
+func NewMetrics(r prometheus.Registerer) *Metrics {
+	return &Metrics{
+		Samples: promauto.With(r).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "capi_exporter_remote_write_samples_total",
+				Help: "Number of samples pushed with remote write by result.",
+			},
+			[]string{"result"},
+		),
+		Retries: promauto.With(r).NewCounter(
+			prometheus.CounterOpts{
+				Name: "capi_exporter_remote_write_retries_total",
+				Help: "Number of retried remote write requests.",
+			},
+		),
+		QueueLength: promauto.With(r).NewGauge(
+			prometheus.GaugeOpts{
+				Name: "capi_exporter_remote_write_queue_length",
+				Help: "Number of pending remote write requests.",
+			},
+		),
+	}
+}
+
+// request is a snappy compressed write request.
+type request struct {
+	body    []byte
+	samples int
+}
+
+// Writer periodically renders the metric families of a gatherer and sends
+// them with the Prometheus remote write protocol. The write requests are sent
+// in order from a bounded queue and retried with an exponential backoff.
+type Writer struct {
+	cfg      Config
+	gatherer prometheus.Gatherer
+	metrics  *Metrics
+	client   *http.Client
+	now      func() time.Time
+	// minBackoff is the backoff of the first retry.
+	minBackoff time.Duration
+	// readyInterval is the interval in which the readiness is checked before
+	// the first push.
+	readyInterval time.Duration
+
+	// previous are the labels of the series of the previous push by their
+	// key. Series which are missing in the next push are marked as stale.
+	previous map[string][]label
+
+	mtx   sync.Mutex
+	queue []request
+	// pending is signaled when a request is queued.
+	pending chan struct{}
+}
+
+// NewWriter returns a Writer which sends the metric families of the gatherer
+// as configured.
+func NewWriter(cfg Config, gatherer prometheus.Gatherer, metrics *Metrics) *Writer {
+	return &Writer{
+		cfg:           cfg,
+		gatherer:      gatherer,
+		metrics:       metrics,
+		client:        &http.Client{},
+		now:           time.Now,
+		minBackoff:    minBackoff,
+		readyInterval: readyInterval,
+		pending:       make(chan struct{}, 1),
+	}
+}
+
+// Run renders the metrics once the stores are synced and then in the
+// configured interval and sends them until the context is done. Pending write
+// requests are dropped then.
+func (w *Writer) Run(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.send(ctx)
+	}()
+
+	if w.waitReady(ctx) {
+		w.renderOrLog()
+	}
+
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			<-done
+			w.client.CloseIdleConnections()
+			return ctx.Err()
+		case <-ticker.C:
+			w.renderOrLog()
+		}
+	}
+}
+
+// waitReady blocks until the stores are synced. It returns false if the
+// context is done before.
+func (w *Writer) waitReady(ctx context.Context) bool {
+	if w.cfg.Ready == nil {
+		return true
+	}
+	ticker := time.NewTicker(w.readyInterval)
+	defer ticker.Stop()
+
+	for {
+		err := w.cfg.Ready()
+		if err == nil {
+			return true
+		}
+		klog.V(4).Infof("Waiting for the stores before the first remote write: %v", err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Writer) renderOrLog() {
+	if err := w.render(); err != nil {
+		klog.Errorf("Failed to render the metrics for remote write: %v", err)
+	}
+}
+
+// render gathers the metrics and queues them in requests of at most
+// maxSamplesPerSend samples. The series of the previous render which are
+// missing now are sent with a stale marker.
+func (w *Writer) render() error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	series := w.withStaleMarkers(newTimeSeries(families))
+	timestamp := w.now().UnixNano() / int64(time.Millisecond)
+
+	for start := 0; start < len(series); start += maxSamplesPerSend {
+		end := start + maxSamplesPerSend
+		if end > len(series) {
+			end = len(series)
+		}
+		w.enqueue(request{
+			body:    snappy.Encode(nil, encodeWriteRequest(series[start:end], timestamp)),
+			samples: end - start,
+		})
+	}
+	return nil
+}
+
+// withStaleMarkers remembers the series and appends a stale marker for each
+// series of the previous render which is missing in them.
+func (w *Writer) withStaleMarkers(series []timeSeries) []timeSeries {
+	current := make(map[string][]label, len(series))
+	for _, s := range series {
+		current[seriesKey(s.labels)] = s.labels
+	}
+	for key, labels := range w.previous {
+		if _, ok := current[key]; !ok {
+			series = append(series, timeSeries{labels: labels, value: staleNaN})
+		}
+	}
+	w.previous = current
+	return series
+}
+
+// seriesKey returns a unique key of the sorted labels of a series.
+func seriesKey(labels []label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.name)
+		b.WriteByte(0xff)
+		b.WriteString(l.value)
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
+
+// enqueue queues the request. The oldest request is dropped if the queue is
+// full, as newer samples supersede it.
+func (w *Writer) enqueue(r request) {
+	w.mtx.Lock()
+	if len(w.queue) >= w.cfg.QueueCapacity {
+		dropped := w.queue[0]
+		w.queue = w.queue[1:]
+		w.metrics.Samples.WithLabelValues("dropped").Add(float64(dropped.samples))
+		klog.Warningf("Remote write queue is full, dropping %d samples", dropped.samples)
+	}
+	w.queue = append(w.queue, r)
+	w.metrics.QueueLength.Set(float64(len(w.queue)))
+	w.mtx.Unlock()
+
+	select {
+	case w.pending <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest queued request. It blocks until a request is queued
+// and returns false when the context is done.
+func (w *Writer) next(ctx context.Context) (request, bool) {
+	for {
+		w.mtx.Lock()
+		if len(w.queue) > 0 {
+			r := w.queue[0]
+			w.queue = w.queue[1:]
+			w.metrics.QueueLength.Set(float64(len(w.queue)))
+			w.mtx.Unlock()
+			return r, true
+		}
+		w.mtx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return request{}, false
+		case <-w.pending:
+		}
+	}
+}
+
+// send sends the queued requests in order until the context is done.
+func (w *Writer) send(ctx context.Context) {
+	for {
+		r, ok := w.next(ctx)
+		if !ok {
+			return
+		}
+		if err := w.sendWithRetries(ctx, r); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			w.metrics.Samples.WithLabelValues("failed").Add(float64(r.samples))
+			klog.Errorf("Failed to send %d samples with remote write: %v", r.samples, err)
+			continue
+		}
+		w.metrics.Samples.WithLabelValues("success").Add(float64(r.samples))
+	}
+}
+
+// sendWithRetries sends the request and retries recoverable errors with an
+// exponential backoff up to the configured number of retries.
+func (w *Writer) sendWithRetries(ctx context.Context, r request) error {
+	backoff := w.minBackoff
+	for retries := 0; ; retries++ {
+		err := w.post(ctx, r.body)
+		var recoverable *recoverableError
+		if err == nil || !errors.As(err, &recoverable) || retries >= w.cfg.MaxRetries {
+			return err
+		}
+
+		klog.V(2).Infof("Retrying remote write in %s: %v", backoff, err)
+		w.metrics.Retries.Inc()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// recoverableError is an error of a write request which may succeed when it is
+// retried, like a network error or a server error.
+type recoverableError struct {
+	err error
+}
+
+func (e *recoverableError) Error() string {
+	return e.err.Error()
+}
+
+func (w *Writer) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "cluster-api-state-metrics/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &recoverableError{err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		// Drain the body, so the connection can be reused.
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("server responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return &recoverableError{err: err}
+	}
+	return err
+}
@@ -0,0 +1,387 @@
+// SPDX-License-Identifier: MIT
+
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const testMetrics = `# HELP capi_cluster_info Information about a cluster.
+# TYPE capi_cluster_info gauge
+capi_cluster_info{management_cluster="",namespace="ns1",cluster="c1",topology_version="v1.22.4"} 1
+# HELP capi_cluster_status_phase The clusters current phase.
+# TYPE capi_cluster_status_phase gauge
+capi_cluster_status_phase{management_cluster="",namespace="ns1",cluster="c1",phase="Provisioned"} 1
+capi_cluster_status_phase{management_cluster="",namespace="ns1",cluster="c1",phase="Failed"} 0
+`
+
+// testGatherer returns the metric families of the text.
+func testGatherer(text string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		var parser expfmt.TextParser
+		parsed, err := parser.TextToMetricFamilies(strings.NewReader(text))
+		if err != nil {
+			return nil, err
+		}
+		var families []*dto.MetricFamily
+		for _, f := range parsed {
+			families = append(families, f)
+		}
+		return families, nil
+	})
+}
+
+// decodeWriteRequest decodes the series of a WriteRequest as strings in the
+// format name{label="value",...} value@timestamp.
+func decodeWriteRequest(b []byte) ([]string, error) {
+	var series []string
+	err := consumeMessages(b, writeRequestTimeseries, func(ts []byte) error {
+		var labels []string
+		var name, sample string
+		err := consumeFields(ts, func(num protowire.Number, msg []byte) error {
+			switch num {
+			case timeSeriesLabels:
+				var l label
+				err := consumeFields(msg, func(num protowire.Number, v []byte) error {
+					if num == labelName {
+						l.name = string(v)
+					} else {
+						l.value = string(v)
+					}
+					return nil
+				})
+				if l.name == "__name__" {
+					name = l.value
+				} else {
+					labels = append(labels, fmt.Sprintf("%s=%q", l.name, l.value))
+				}
+				return err
+			case timeSeriesSamples:
+				var value float64
+				var timestamp int64
+				for len(msg) > 0 {
+					num, typ, n := protowire.ConsumeTag(msg)
+					msg = msg[n:]
+					switch {
+					case num == sampleValue && typ == protowire.Fixed64Type:
+						v, n := protowire.ConsumeFixed64(msg)
+						value, msg = math.Float64frombits(v), msg[n:]
+					case num == sampleTimestamp && typ == protowire.VarintType:
+						v, n := protowire.ConsumeVarint(msg)
+						timestamp, msg = int64(v), msg[n:]
+					default:
+						return fmt.Errorf("unexpected sample field %d", num)
+					}
+				}
+				if math.Float64bits(value) == math.Float64bits(staleNaN) {
+					sample = fmt.Sprintf("StaleNaN@%d", timestamp)
+				} else {
+					sample = fmt.Sprintf("%v@%d", value, timestamp)
+				}
+			}
+			return nil
+		})
+		series = append(series, fmt.Sprintf("%s{%s} %s", name, strings.Join(labels, ","), sample))
+		return err
+	})
+	return series, err
+}
+
+// consumeFields calls the function with the bytes of each length delimited
+// field of the message.
+func consumeFields(b []byte, f func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.BytesType {
+			return fmt.Errorf("unexpected field %d of type %d", num, typ)
+		}
+		b = b[n:]
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := f(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func consumeMessages(b []byte, field protowire.Number, f func([]byte) error) error {
+	return consumeFields(b, func(num protowire.Number, v []byte) error {
+		if num != field {
+			return fmt.Errorf("unexpected field %d", num)
+		}
+		return f(v)
+	})
+}
+
+// fakeReceiver is a remote write receiver which responds with the queued status
+// codes and records the received series.
+type fakeReceiver struct {
+	t *testing.T
+
+	mtx      sync.Mutex
+	statuses []int
+	requests [][]string
+	headers  http.Header
+}
+
+func (r *fakeReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	if status >= 300 {
+		w.WriteHeader(status)
+		w.Write([]byte("receiver failed"))
+		return
+	}
+
+	r.headers = req.Header
+	compressed, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("unexpected error: %v", err)
+	}
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		r.t.Errorf("expected a snappy compressed body: %v", err)
+	}
+	series, err := decodeWriteRequest(body)
+	if err != nil {
+		r.t.Errorf("failed to decode the write request: %v", err)
+	}
+	r.requests = append(r.requests, series)
+	w.WriteHeader(status)
+}
+
+func newTestWriter(url string, gatherer prometheus.Gatherer) *Writer {
+	w := NewWriter(Config{
+		URL:           url,
+		Interval:      time.Minute,
+		Timeout:       time.Second,
+		QueueCapacity: 2,
+		MaxRetries:    2,
+		Headers:       map[string]string{"X-Scope-OrgID": "tenant1"},
+	}, gatherer, NewMetrics(prometheus.NewRegistry()))
+	w.now = func() time.Time { return time.Unix(1501569018, 0) }
+	w.minBackoff = time.Millisecond
+	w.readyInterval = time.Millisecond
+	return w
+}
+
+func TestWriterSendsSamples(t *testing.T) {
+	receiver := &fakeReceiver{t: t, statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	w := newTestWriter(server.URL, testGatherer(testMetrics))
+	if err := w.render(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.send(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(w.metrics.Samples.WithLabelValues("success")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a successful write request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	receiver.mtx.Lock()
+	defer receiver.mtx.Unlock()
+	want := []string{
+		`capi_cluster_info{cluster="c1",namespace="ns1",topology_version="v1.22.4"} 1@1501569018000`,
+		`capi_cluster_status_phase{cluster="c1",namespace="ns1",phase="Failed"} 0@1501569018000`,
+		`capi_cluster_status_phase{cluster="c1",namespace="ns1",phase="Provisioned"} 1@1501569018000`,
+	}
+	got := receiver.requests[0]
+	sort.Strings(got)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected series, want:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	for header, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"X-Scope-OrgID":                     "tenant1",
+	} {
+		if got := receiver.headers.Get(header); got != want {
+			t.Errorf("expected header %s %q, got %q", header, want, got)
+		}
+	}
+
+	if got := testutil.ToFloat64(w.metrics.Retries); got != 2 {
+		t.Errorf("expected 2 retries, got %v", got)
+	}
+	if got := testutil.ToFloat64(w.metrics.Samples.WithLabelValues("success")); got != 3 {
+		t.Errorf("expected 3 successful samples, got %v", got)
+	}
+}
+
+func TestWriterDropsFailedRequests(t *testing.T) {
+	receiver := &fakeReceiver{t: t, statuses: []int{
+		http.StatusBadRequest,
+		http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError,
+	}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	w := newTestWriter(server.URL, testGatherer(testMetrics))
+	for _, want := range []float64{0, 2} {
+		if err := w.render(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r, _ := w.next(context.Background())
+		if err := w.sendWithRetries(context.Background(), r); err == nil || !strings.Contains(err.Error(), "receiver failed") {
+			t.Errorf("expected the error of the receiver, got %v", err)
+		}
+		if got := testutil.ToFloat64(w.metrics.Retries); got != want {
+			t.Errorf("expected %v retries, got %v", want, got)
+		}
+	}
+}
+
+func TestWriterQueue(t *testing.T) {
+	var text strings.Builder
+	text.WriteString("# HELP capi_test_info Test info.\n# TYPE capi_test_info gauge\n")
+	for i := 0; i < maxSamplesPerSend+1; i++ {
+		fmt.Fprintf(&text, "capi_test_info{name=\"%d\"} 1\n", i)
+	}
+
+	w := newTestWriter("http://127.0.0.1:0", testGatherer(text.String()))
+	if err := w.render(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(w.queue) != 2 || w.queue[0].samples != maxSamplesPerSend || w.queue[1].samples != 1 {
+		t.Fatalf("expected the samples in requests of at most %d samples, got %d requests", maxSamplesPerSend, len(w.queue))
+	}
+
+	if err := w.render(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(w.metrics.QueueLength); got != 2 {
+		t.Errorf("expected the queue to be bounded to 2 requests, got %v", got)
+	}
+	if got := testutil.ToFloat64(w.metrics.Samples.WithLabelValues("dropped")); got != maxSamplesPerSend+1 {
+		t.Errorf("expected the samples of the oldest requests to be dropped, got %v", got)
+	}
+}
+
+func TestWriterPushesOnceReady(t *testing.T) {
+	receiver := &fakeReceiver{t: t}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	w := newTestWriter(server.URL, testGatherer(testMetrics))
+	var mtx sync.Mutex
+	checks := 0
+	w.cfg.Ready = func() error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		checks++
+		if checks < 3 {
+			return fmt.Errorf("initial list of machines is not complete")
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	// The interval is a minute, so the samples are pushed before the first
+	// tick.
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(w.metrics.Samples.WithLabelValues("success")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a successful write request once the stores are ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if checks != 3 {
+		t.Errorf("expected the readiness to be checked until it succeeds, got %d checks", checks)
+	}
+	if got := testutil.ToFloat64(w.metrics.Samples.WithLabelValues("success")); got != 3 {
+		t.Errorf("expected 3 successful samples, got %v", got)
+	}
+}
+
+func TestWriterMarksMissingSeriesStale(t *testing.T) {
+	text := testMetrics
+	w := newTestWriter("http://127.0.0.1:0", prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return testGatherer(text).Gather()
+	}))
+	w.cfg.QueueCapacity = 3
+	if err := w.render(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text = strings.Replace(testMetrics, `phase="Provisioned"} 1`, `phase="Deleting"} 1`, 1)
+	if err := w.render(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.render(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, want := range [][]string{
+		{
+			`capi_cluster_info{cluster="c1",namespace="ns1",topology_version="v1.22.4"} 1@1501569018000`,
+			`capi_cluster_status_phase{cluster="c1",namespace="ns1",phase="Deleting"} 1@1501569018000`,
+			`capi_cluster_status_phase{cluster="c1",namespace="ns1",phase="Failed"} 0@1501569018000`,
+			`capi_cluster_status_phase{cluster="c1",namespace="ns1",phase="Provisioned"} StaleNaN@1501569018000`,
+		},
+		{
+			`capi_cluster_info{cluster="c1",namespace="ns1",topology_version="v1.22.4"} 1@1501569018000`,
+			`capi_cluster_status_phase{cluster="c1",namespace="ns1",phase="Deleting"} 1@1501569018000`,
+			`capi_cluster_status_phase{cluster="c1",namespace="ns1",phase="Failed"} 0@1501569018000`,
+		},
+	} {
+		body, err := snappy.Decode(nil, w.queue[i+1].body)
+		if err != nil {
+			t.Fatalf("expected a snappy compressed body: %v", err)
+		}
+		got, err := decodeWriteRequest(body)
+		if err != nil {
+			t.Fatalf("failed to decode the write request: %v", err)
+		}
+		sort.Strings(got)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("unexpected series of the %vth render, want:\n%s\ngot:\n%s", i+2, strings.Join(want, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
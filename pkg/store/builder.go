@@ -0,0 +1,834 @@
+/*
+Copyright 2018 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The original file is located at [1].
+[1]: https://github.com/kubernetes/kube-state-metrics/blob/41eea36f69ef/internal/store/builder.go
+
+The original source was adjusted to:
+- only support custom resource stores.
+- keep the objects of the resources which are required by cross resource factories.
+- add metrics writers for cross resource factories which generate metrics at scrape time.
+- add the management cluster label to all metrics.
+- use the MetricsStore of cluster-api-state-metrics.
+- only keep the objects of the clusters matching the cluster selector.
+- support watching single resources in other namespaces.
+- allow resetting the annotations and labels allowlists when the stores are reconfigured.
+- cancel the list and watch requests with the context of the Builder.
+- list the objects in chunks and keep the watch bookmarks of sharded stores.
+- strip the fields of the objects which are not read by any metric.
+- skip and count the metric families which fail to generate instead of panicking.
+- record the self metrics of the list and watch requests per resource.
+- track the initial list and failing requests of the reflectors for the health endpoints.
+- record the events and objects of the metrics stores.
+- notify the cross resource factories which observe the changes of the kept objects.
+- rebuild only the stores whose list and watch settings changed when the Builder is reconfigured.
+*/
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	vpaclientset "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	ksmtypes "k8s.io/kube-state-metrics/v2/pkg/builder/types"
+	"k8s.io/kube-state-metrics/v2/pkg/customresource"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	metricsstore "k8s.io/kube-state-metrics/v2/pkg/metrics_store"
+	"k8s.io/kube-state-metrics/v2/pkg/options"
+	"k8s.io/kube-state-metrics/v2/pkg/sharding"
+	"k8s.io/kube-state-metrics/v2/pkg/watch"
+)
+
+// Make sure the Builder implements the kube-state-metrics BuilderInterface.
+var _ ksmtypes.BuilderInterface = &Builder{}
+
+// Rebuilder is implemented by builders which can apply a changed configuration
+// to the stores of the last Build. Rebuild only rebuilds the stores whose list
+// and watch settings changed and regenerates the metrics of the other stores
+// with the current metric families, labels and annotations.
+type Rebuilder interface {
+	Rebuild() []metricsstore.MetricsWriter
+}
+
+// Make sure the Builder implements the Rebuilder.
+var _ Rebuilder = &Builder{}
+
+// Builder helps to build store. It follows the builder pattern
+// (https://en.wikipedia.org/wiki/Builder_pattern).
+type Builder struct {
+	kubeClient            clientset.Interface
+	customResourceClients map[string]interface{}
+	namespaces            options.NamespaceList
+	namespaceFilter       string
+	// resourceNamespaces overrides the namespaces of single resources.
+	resourceNamespaces            map[string]options.NamespaceList
+	ctx                           context.Context
+	enabledResources              []string
+	familyGeneratorFilter         generator.FamilyGeneratorFilter
+	listWatchMetrics              *watch.ListWatchMetrics
+	exporterMetrics               *ExporterMetrics
+	health                        *Health
+	shardingMetrics               *sharding.Metrics
+	shard                         int32
+	totalShards                   int
+	buildCustomResourceStoresFunc ksmtypes.BuildCustomResourceStoresFunc
+	allowAnnotationsList          map[string][]string
+	allowLabelsList               map[string][]string
+	useAPIServerCache             bool
+	listPageSize                  int64
+	availableStores               map[string]func(b *Builder) []cache.Store
+	crossResourceFactories        []CrossResourceFactory
+	// managementCluster is added as management_cluster label to all metrics
+	// if it is not empty.
+	managementCluster string
+	clusterSelector   labels.Selector
+	// clusterSelection holds the clusters matching the cluster selector. It
+	// is nil if no cluster selector is configured and reset on every Build.
+	clusterSelection *clusterSelection
+	// objects holds the object stores of the resources which are required
+	// by the enabled cross resource factories. It is reset on every Build.
+	objects Objects
+	// objectObservers holds the cross resource factories which observe the
+	// changes of the objects per resource. It is reset on every Build.
+	objectObservers map[string][]CrossResourceObserver
+	// customResourceFactories holds the factory of each available resource.
+	customResourceFactories map[string]customresource.RegistryFactory
+	// storeCtx is the context of the stores which are built. It is derived
+	// from ctx per resource, so the stores of a resource can be stopped
+	// without the others.
+	storeCtx context.Context
+	// builtResources holds the stores of each resource of the last Build.
+	builtResources map[string]*builtResource
+	// clusterSelectionSettings are the settings of the running cluster
+	// selection, which is stopped by stopClusterSelection.
+	clusterSelectionSettings string
+	stopClusterSelection     context.CancelFunc
+	// stopCrossResourceRunners stops the cross resource runners of the last
+	// Build.
+	stopCrossResourceRunners context.CancelFunc
+}
+
+// builtResource holds the stores of a resource and the settings they were
+// built with.
+type builtResource struct {
+	settings string
+	stores   []*MetricsStore
+	// objects holds the object stores if the objects are kept for cross
+	// resource factories.
+	objects []cache.Store
+	stop    context.CancelFunc
+}
+
+// NewBuilder returns a new builder.
+func NewBuilder() *Builder {
+	b := &Builder{
+		availableStores:         map[string]func(b *Builder) []cache.Store{},
+		customResourceFactories: map[string]customresource.RegistryFactory{},
+	}
+	return b
+}
+
+// WithMetrics sets the metrics property of a Builder.
+func (b *Builder) WithMetrics(r prometheus.Registerer) {
+	b.listWatchMetrics = watch.NewListWatchMetrics(r)
+	b.exporterMetrics = NewExporterMetrics(r)
+	b.shardingMetrics = sharding.NewShardingMetrics(r)
+}
+
+// WithHealth configures the Health which tracks the reflectors of the stores.
+func (b *Builder) WithHealth(h *Health) {
+	b.health = h
+}
+
+// WithEnabledResources sets the enabledResources property of a Builder.
+func (b *Builder) WithEnabledResources(r []string) error {
+	for _, col := range r {
+		if !b.resourceExists(col) {
+			return errors.Errorf("resource %s does not exist. Available resources: %s", col, strings.Join(b.availableResources(), ","))
+		}
+	}
+
+	var copy []string
+	copy = append(copy, r...)
+
+	sort.Strings(copy)
+
+	b.enabledResources = copy
+	return nil
+}
+
+// WithNamespaces sets the namespaces property of a Builder.
+func (b *Builder) WithNamespaces(n options.NamespaceList, nsFilter string) {
+	b.namespaces = n
+	b.namespaceFilter = nsFilter
+}
+
+// WithResourceNamespaces overrides the namespaces which are watched for single
+// resources.
+func (b *Builder) WithResourceNamespaces(n map[string]options.NamespaceList) {
+	b.resourceNamespaces = n
+}
+
+// WithSharding sets the shard and totalShards property of a Builder.
+func (b *Builder) WithSharding(shard int32, totalShards int) {
+	b.shard = shard
+	labels := map[string]string{sharding.LabelOrdinal: strconv.Itoa(int(shard))}
+	b.shardingMetrics.Ordinal.Reset()
+	b.shardingMetrics.Ordinal.With(labels).Set(float64(shard))
+	b.totalShards = totalShards
+	b.shardingMetrics.Total.Set(float64(totalShards))
+}
+
+// WithContext sets the ctx property of a Builder.
+func (b *Builder) WithContext(ctx context.Context) {
+	b.ctx = ctx
+}
+
+// WithKubeClient sets the kubeClient property of a Builder.
+func (b *Builder) WithKubeClient(c clientset.Interface) {
+	b.kubeClient = c
+}
+
+// WithVPAClient is a no-op, as the Builder only supports custom resource stores.
+func (b *Builder) WithVPAClient(c vpaclientset.Interface) {}
+
+// WithCustomResourceClients sets the customResourceClients property of a Builder.
+func (b *Builder) WithCustomResourceClients(cs map[string]interface{}) {
+	b.customResourceClients = cs
+}
+
+// WithUsingAPIServerCache configures whether using APIServer cache or not.
+func (b *Builder) WithUsingAPIServerCache(u bool) {
+	b.useAPIServerCache = u
+}
+
+// WithListPageSize sets the number of objects per list request. The objects are
+// listed in a single request if it is 0.
+func (b *Builder) WithListPageSize(pageSize int64) {
+	b.listPageSize = pageSize
+}
+
+// WithFamilyGeneratorFilter configures the family generator filter which decides which
+// metrics are to be exposed by the store build by the Builder.
+func (b *Builder) WithFamilyGeneratorFilter(l generator.FamilyGeneratorFilter) {
+	b.familyGeneratorFilter = l
+}
+
+// WithGenerateStoresFunc is a no-op, as the Builder only supports custom resource stores.
+func (b *Builder) WithGenerateStoresFunc(f ksmtypes.BuildStoresFunc) {}
+
+// WithGenerateCustomResourceStoresFunc configures a custom generate custom resource store function
+func (b *Builder) WithGenerateCustomResourceStoresFunc(f ksmtypes.BuildCustomResourceStoresFunc) {
+	b.buildCustomResourceStoresFunc = f
+}
+
+// DefaultGenerateStoresFunc returns nil, as the Builder only supports custom resource stores.
+func (b *Builder) DefaultGenerateStoresFunc() ksmtypes.BuildStoresFunc {
+	return nil
+}
+
+// DefaultGenerateCustomResourceStoresFunc returns default buildCustomResourceStores function
+func (b *Builder) DefaultGenerateCustomResourceStoresFunc() ksmtypes.BuildCustomResourceStoresFunc {
+	return b.buildCustomResourceStores
+}
+
+// WithCustomResourceStoreFactories returns configures a custom resource stores factory
+func (b *Builder) WithCustomResourceStoreFactories(fs ...customresource.RegistryFactory) {
+	for i := range fs {
+		f := fs[i]
+		b.customResourceFactories[f.Name()] = f
+		b.availableStores[f.Name()] = func(b *Builder) []cache.Store {
+			listWatch := f.ListWatch
+			if lw, ok := f.(ContextListWatcher); ok {
+				listWatch = func(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+					return lw.ListWatchWithContext(b.storeCtx, customResourceClient, ns, fieldSelector)
+				}
+			}
+			if t, ok := f.(ObjectTransformer); ok {
+				untransformedListWatch := listWatch
+				listWatch = func(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+					return newTransformingListWatch(untransformedListWatch(customResourceClient, ns, fieldSelector), t.TransformObject)
+				}
+			}
+			return b.buildCustomResourceStoresFunc(
+				f.Name(),
+				b.metricFamilyGenerators(f),
+				f.ExpectedType(),
+				listWatch,
+				b.useAPIServerCache,
+			)
+		}
+	}
+}
+
+// WithCrossResourceFactories configures the factories for metrics which are
+// generated at scrape time from the objects of one or more resources.
+func (b *Builder) WithCrossResourceFactories(fs ...CrossResourceFactory) {
+	b.crossResourceFactories = append(b.crossResourceFactories, fs...)
+}
+
+// WithManagementCluster configures the name of the management cluster which is
+// added as management_cluster label to all metrics. No label is added if the
+// name is empty.
+func (b *Builder) WithManagementCluster(name string) {
+	b.managementCluster = name
+}
+
+// WithClusterSelector configures the label selector of the clusters whose
+// metrics are exposed. Objects which belong to a cluster by the cluster name
+// label are only exposed if their cluster matches the selector.
+func (b *Builder) WithClusterSelector(selector labels.Selector) {
+	b.clusterSelector = selector
+}
+
+// WithAllowAnnotations configures which annotations can be returned for metrics
+func (b *Builder) WithAllowAnnotations(annotations map[string][]string) {
+	b.allowAnnotationsList = annotations
+}
+
+// WithAllowLabels configures which labels can be returned for metrics
+func (b *Builder) WithAllowLabels(labels map[string][]string) {
+	b.allowLabelsList = labels
+}
+
+// Build initializes and registers all enabled stores.
+// It returns metrics writers which can be used to write out
+// metrics from the stores.
+func (b *Builder) Build() []metricsstore.MetricsWriter {
+	return b.metricsWriters(b.build(false))
+}
+
+// Rebuild applies the changed configuration to the stores of the last Build.
+// The stores of a resource are only rebuilt if its list and watch settings
+// changed, otherwise the metrics of its objects are regenerated.
+func (b *Builder) Rebuild() []metricsstore.MetricsWriter {
+	return b.metricsWriters(b.build(true))
+}
+
+// metricsWriters returns the metrics writers of the stores and cross resource
+// writers.
+func (b *Builder) metricsWriters(resourceStores []resourceStores, crossResourceWriters []*crossResourceMetricsWriter) []metricsstore.MetricsWriter {
+
+	var metricsWriters []metricsstore.MetricsWriter
+	for _, r := range resourceStores {
+		if len(r.stores) == 1 {
+			metricsWriters = append(metricsWriters, r.stores[0])
+		} else {
+			metricsWriters = append(metricsWriters, NewMultiStoreMetricsWriter(r.stores))
+		}
+	}
+	for _, w := range crossResourceWriters {
+		metricsWriters = append(metricsWriters, w)
+	}
+
+	return metricsWriters
+}
+
+// resourceStores holds the metrics stores of a resource.
+type resourceStores struct {
+	name   string
+	stores []*MetricsStore
+}
+
+// build initializes and registers all enabled stores. It returns the metrics
+// stores of the enabled resources and the metrics writers of the enabled cross
+// resource factories, so they can be combined with the ones of other builders.
+// If rebuild is true, the stores of the last build whose settings did not
+// change are kept and only their metrics are regenerated. All other stores of
+// the last build are stopped.
+func (b *Builder) build(rebuild bool) ([]resourceStores, []*crossResourceMetricsWriter) {
+	if b.familyGeneratorFilter == nil {
+		panic("familyGeneratorFilter should not be nil")
+	}
+
+	var allResourceStores []resourceStores
+	var activeStoreNames []string
+
+	previous := b.builtResources
+	if !rebuild {
+		previous = nil
+		b.stop()
+	}
+	if !rebuild || b.clusterSelectionSettings != b.currentClusterSelectionSettings() {
+		if b.stopClusterSelection != nil {
+			b.stopClusterSelection()
+		}
+		b.storeCtx, b.stopClusterSelection = context.WithCancel(b.ctx)
+		b.startClusterSelection()
+		b.clusterSelectionSettings = b.currentClusterSelectionSettings()
+	}
+
+	crossResourceFactories := b.enabledCrossResourceFactories()
+	b.objects = Objects{}
+	b.objectObservers = map[string][]CrossResourceObserver{}
+	for _, f := range crossResourceFactories {
+		for _, r := range f.Resources() {
+			b.objects[r] = []cache.Store{}
+			if o, ok := f.(CrossResourceObserver); ok {
+				b.objectObservers[r] = append(b.objectObservers[r], o)
+			}
+		}
+	}
+
+	built := map[string]*builtResource{}
+	var rebuiltStoreNames []string
+	for _, c := range b.enabledResources {
+		constructor, ok := b.availableStores[c]
+		if !ok {
+			continue
+		}
+		settings := b.storeSettings(c, crossResourceFactories)
+		r, ok := previous[c]
+		if ok && r.settings == settings {
+			b.regenerate(c, r.stores)
+			if _, ok := b.objects[c]; ok {
+				b.objects[c] = r.objects
+			}
+			delete(previous, c)
+		} else {
+			r = &builtResource{settings: settings}
+			b.storeCtx, r.stop = context.WithCancel(b.ctx)
+			r.stores = cacheStoresToMetricStores(constructor(b))
+			r.objects = b.objects[c]
+			rebuiltStoreNames = append(rebuiltStoreNames, c)
+		}
+		built[c] = r
+		activeStoreNames = append(activeStoreNames, c)
+		allResourceStores = append(allResourceStores, resourceStores{name: c, stores: r.stores})
+	}
+	// The stores of disabled resources and the previous stores of rebuilt
+	// resources are stopped.
+	for _, r := range previous {
+		r.stop()
+	}
+	b.builtResources = built
+
+	klog.Infof("Active resources%s: %s", b.managementClusterLogSuffix(), strings.Join(activeStoreNames, ","))
+	if rebuild && len(rebuiltStoreNames) > 0 {
+		klog.Infof("Rebuilt resources%s: %s", b.managementClusterLogSuffix(), strings.Join(rebuiltStoreNames, ","))
+	}
+
+	// The cross resource runners are restarted with the current objects.
+	if b.stopCrossResourceRunners != nil {
+		b.stopCrossResourceRunners()
+	}
+	var crossResourceCtx context.Context
+	crossResourceCtx, b.stopCrossResourceRunners = context.WithCancel(b.ctx)
+	var crossResourceWriters []*crossResourceMetricsWriter
+	var activeCrossResourceNames []string
+	for _, f := range crossResourceFactories {
+		metricFamilies := f.MetricFamilyGenerators(b.allowAnnotationsList[f.Name()], b.allowLabelsList[f.Name()])
+		metricFamilies = generator.FilterFamilyGenerators(b.familyGeneratorFilter, metricFamilies)
+		if len(metricFamilies) == 0 {
+			continue
+		}
+		activeCrossResourceNames = append(activeCrossResourceNames, f.Name())
+		crossResourceWriters = append(crossResourceWriters, newCrossResourceMetricsWriter(f.Name(), f.Resources(), b.withManagementClusterLabel(b.withGenerationErrors(metricFamilies)), b.objects))
+		if r, ok := f.(CrossResourceRunner); ok {
+			go r.Run(crossResourceCtx, b.objects)
+		}
+	}
+
+	if len(activeCrossResourceNames) > 0 {
+		klog.Infof("Active cross resource metrics%s: %s", b.managementClusterLogSuffix(), strings.Join(activeCrossResourceNames, ","))
+	}
+
+	return allResourceStores, crossResourceWriters
+}
+
+// BuildStores initializes and registers all enabled stores.
+// It returns metric stores which can be used to consume
+// the generated metrics from the stores.
+func (b *Builder) BuildStores() [][]cache.Store {
+	if b.familyGeneratorFilter == nil {
+		panic("familyGeneratorFilter should not be nil")
+	}
+
+	var allStores [][]cache.Store
+	var activeStoreNames []string
+
+	b.storeCtx = b.ctx
+	b.startClusterSelection()
+
+	for _, c := range b.enabledResources {
+		constructor, ok := b.availableStores[c]
+		if ok {
+			stores := constructor(b)
+			activeStoreNames = append(activeStoreNames, c)
+			allStores = append(allStores, stores)
+		}
+	}
+
+	klog.Infof("Active resources: %s", strings.Join(activeStoreNames, ","))
+
+	return allStores
+}
+
+// stop stops the stores, the cluster selection and the cross resource runners
+// of the last build.
+func (b *Builder) stop() {
+	for _, r := range b.builtResources {
+		r.stop()
+	}
+	b.builtResources = nil
+	if b.stopClusterSelection != nil {
+		b.stopClusterSelection()
+		b.stopClusterSelection = nil
+	}
+	if b.stopCrossResourceRunners != nil {
+		b.stopCrossResourceRunners()
+		b.stopCrossResourceRunners = nil
+	}
+}
+
+// storeSettings returns the settings the stores of the resource are listed
+// and watched with. The stores have to be rebuilt if they change.
+func (b *Builder) storeSettings(resource string, crossResourceFactories []CrossResourceFactory) string {
+	namespaces := b.namespaces
+	if n, ok := b.resourceNamespaces[resource]; ok {
+		namespaces = n
+	}
+	// The objects are kept for and observed by these factories.
+	var joinedBy []string
+	for _, f := range crossResourceFactories {
+		for _, r := range f.Resources() {
+			if r == resource {
+				joinedBy = append(joinedBy, f.Name())
+			}
+		}
+	}
+	return fmt.Sprintf("namespaces=%v namespaceFilter=%s clusterSelection=%s shard=%d/%d listPageSize=%d useAPIServerCache=%t crossResources=%v",
+		namespaces, b.namespaceFilter, b.currentClusterSelectionSettings(), b.shard, b.totalShards, b.listPageSize, b.useAPIServerCache, joinedBy)
+}
+
+// currentClusterSelectionSettings returns the settings the clusters of the
+// cluster selection are listed and watched with. All stores filter their
+// objects by the selection, so they have to be rebuilt if they change.
+func (b *Builder) currentClusterSelectionSettings() string {
+	if b.clusterSelector == nil {
+		return ""
+	}
+	return fmt.Sprintf("selector=%s namespaces=%v namespaceFilter=%s", b.clusterSelector.String(), b.clusterSelectionNamespaces(), b.namespaceFilter)
+}
+
+// regenerate regenerates the metrics of the stores of the resource with the
+// current metric families.
+func (b *Builder) regenerate(resource string, stores []*MetricsStore) {
+	f, ok := b.customResourceFactories[resource]
+	if !ok {
+		return
+	}
+	metricFamilies := generator.FilterFamilyGenerators(b.familyGeneratorFilter, b.metricFamilyGenerators(f))
+	headers := generator.ExtractMetricFamilyHeaders(metricFamilies)
+	generateFunc := generator.ComposeMetricGenFuncs(metricFamilies)
+	for _, s := range stores {
+		s.regenerate(headers, generateFunc)
+	}
+}
+
+// metricFamilyGenerators returns the metric family generators of the resource
+// with the allowed annotations and labels, the generation errors and the
+// management cluster label.
+func (b *Builder) metricFamilyGenerators(f customresource.RegistryFactory) []generator.FamilyGenerator {
+	return b.withManagementClusterLabel(b.withGenerationErrors(f.MetricFamilyGenerators(b.allowAnnotationsList[f.Name()], b.allowLabelsList[f.Name()])))
+}
+
+// enabledCrossResourceFactories returns the cross resource factories for which
+// all required resources are enabled. Factories which join multiple resources
+// are disabled when sharding is used.
+func (b *Builder) enabledCrossResourceFactories() []CrossResourceFactory {
+	enabled := map[string]bool{}
+	for _, r := range b.enabledResources {
+		enabled[r] = true
+	}
+
+	var factories []CrossResourceFactory
+	for _, f := range b.crossResourceFactories {
+		complete := true
+		for _, r := range f.Resources() {
+			if !enabled[r] {
+				complete = false
+				break
+			}
+		}
+		if !complete {
+			klog.Infof("Skipping cross resource metrics %s, not all required resources (%s) are enabled", f.Name(), strings.Join(f.Resources(), ","))
+			continue
+		}
+		// With sharding each instance only sees a part of the objects, so
+		// references between objects of different resources can't be resolved.
+		if b.totalShards > 1 && len(f.Resources()) > 1 {
+			klog.Infof("Skipping cross resource metrics %s, they join multiple resources (%s) which is not supported with sharding", f.Name(), strings.Join(f.Resources(), ","))
+			continue
+		}
+		factories = append(factories, f)
+	}
+
+	return factories
+}
+
+// withManagementClusterLabel returns the metric family generators with the
+// management_cluster label added to all generated metrics. The generators are
+// returned as is if no management cluster is configured.
+func (b *Builder) withManagementClusterLabel(metricFamilies []generator.FamilyGenerator) []generator.FamilyGenerator {
+	if b.managementCluster == "" {
+		return metricFamilies
+	}
+
+	labeled := make([]generator.FamilyGenerator, len(metricFamilies))
+	for i, f := range metricFamilies {
+		generateFunc := f.GenerateFunc
+		f.GenerateFunc = func(obj interface{}) *metric.Family {
+			family := generateFunc(obj)
+			for _, m := range family.Metrics {
+				m.LabelKeys = append([]string{"management_cluster"}, m.LabelKeys...)
+				m.LabelValues = append([]string{b.managementCluster}, m.LabelValues...)
+			}
+			return family
+		}
+		labeled[i] = f
+	}
+	return labeled
+}
+
+// withGenerationErrors returns the metric family generators which skip the
+// family of an object if its generation failed, as indicated by a nil family.
+// The skipped families are counted by the exporter metrics.
+func (b *Builder) withGenerationErrors(metricFamilies []generator.FamilyGenerator) []generator.FamilyGenerator {
+	checked := make([]generator.FamilyGenerator, len(metricFamilies))
+	for i, f := range metricFamilies {
+		name := f.Name
+		generateFunc := f.GenerateFunc
+		f.GenerateFunc = func(obj interface{}) *metric.Family {
+			family := generateFunc(obj)
+			if family == nil {
+				if b.exporterMetrics != nil {
+					b.exporterMetrics.GenerationErrors.WithLabelValues(b.managementCluster, name).Inc()
+				}
+				return &metric.Family{}
+			}
+			return family
+		}
+		checked[i] = f
+	}
+	return checked
+}
+
+func (b *Builder) managementClusterLogSuffix() string {
+	if b.managementCluster == "" {
+		return ""
+	}
+	return " of management cluster " + b.managementCluster
+}
+
+func (b *Builder) resourceExists(name string) bool {
+	_, ok := b.availableStores[name]
+	return ok
+}
+
+func (b *Builder) availableResources() []string {
+	c := []string{}
+	for name := range b.availableStores {
+		c = append(c, name)
+	}
+	return c
+}
+
+func (b *Builder) buildCustomResourceStores(resourceName string,
+	metricFamilies []generator.FamilyGenerator,
+	expectedType interface{},
+	listWatchFunc func(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher,
+	useAPIServerCache bool,
+) []cache.Store {
+	metricFamilies = generator.FilterFamilyGenerators(b.familyGeneratorFilter, metricFamilies)
+	composedMetricGenFuncs := generator.ComposeMetricGenFuncs(metricFamilies)
+	familyHeaders := generator.ExtractMetricFamilyHeaders(metricFamilies)
+
+	customResourceClient, ok := b.customResourceClients[resourceName]
+	if !ok {
+		klog.Warningf("Custom resource client %s does not exist", resourceName)
+		return []cache.Store{}
+	}
+
+	namespaces := b.namespaces
+	if n, ok := b.resourceNamespaces[resourceName]; ok {
+		namespaces = n
+	}
+
+	if namespaces.IsAllNamespaces() {
+		store := NewMetricsStore(
+			familyHeaders,
+			composedMetricGenFuncs,
+		)
+		store.resource = resourceName
+		store.managementCluster = b.managementCluster
+		listWatcher := listWatchFunc(customResourceClient, v1.NamespaceAll, b.namespaceFilter)
+		b.startReflector(resourceName, expectedType, b.withStoreMetrics(resourceName, store, b.withClusterFilter(b.withObjectStore(resourceName, store), store)), listWatcher, useAPIServerCache)
+		return []cache.Store{store}
+	}
+
+	stores := make([]cache.Store, 0, len(namespaces))
+	for _, ns := range namespaces {
+		store := NewMetricsStore(
+			familyHeaders,
+			composedMetricGenFuncs,
+		)
+		store.resource = resourceName
+		store.managementCluster = b.managementCluster
+		listWatcher := listWatchFunc(customResourceClient, ns, b.namespaceFilter)
+		b.startReflector(resourceName, expectedType, b.withStoreMetrics(resourceName, store, b.withClusterFilter(b.withObjectStore(resourceName, store), store)), listWatcher, useAPIServerCache)
+		stores = append(stores, store)
+	}
+
+	return stores
+}
+
+// withObjectStore returns a store which additionally keeps the objects of the
+// given resource if they are required by a cross resource factory. Otherwise
+// the given store is returned as is.
+func (b *Builder) withObjectStore(resourceName string, store cache.Store) cache.Store {
+	if _, ok := b.objects[resourceName]; !ok {
+		return store
+	}
+
+	objectStore := newSyncingStore()
+	b.objects[resourceName] = append(b.objects[resourceName], objectStore)
+	return &objectCachingStore{
+		Store:     store,
+		objects:   objectStore,
+		resource:  resourceName,
+		observers: b.objectObservers[resourceName],
+	}
+}
+
+// withStoreMetrics returns a store which additionally records the events and
+// the objects of the given metrics store if the metrics are configured.
+// Otherwise the given store is returned as is.
+func (b *Builder) withStoreMetrics(resourceName string, metricsStore *MetricsStore, store cache.Store) cache.Store {
+	if b.exporterMetrics == nil {
+		return store
+	}
+	return newInstrumentedStore(b.storeCtx, store, metricsStore, b.exporterMetrics, b.managementCluster, resourceName)
+}
+
+// startClusterSelection starts watching the clusters matching the cluster
+// selector if one is configured. The clusters are not sharded, so the objects
+// of all selected clusters are known to every shard.
+func (b *Builder) startClusterSelection() {
+	b.clusterSelection = nil
+	if b.clusterSelector == nil {
+		return
+	}
+
+	f := &ClusterFactory{}
+	clusterClient, ok := b.customResourceClients[f.Name()]
+	if !ok {
+		klog.Warningf("Custom resource client %s does not exist, no clusters are selected", f.Name())
+		b.clusterSelection = &clusterSelection{}
+		return
+	}
+	b.clusterSelection = newClusterSelection(b.storeCtx, b.clusterSelector, func(ns string) cache.ListerWatcher {
+		lw := newTransformingListWatch(f.ListWatchWithContext(b.storeCtx, clusterClient, ns, b.namespaceFilter), f.TransformObject)
+		if b.health != nil {
+			lw = b.health.track(b.storeCtx, lw, f.Name())
+		}
+		return lw
+	}, b.clusterSelectionNamespaces(), b.listPageSize)
+}
+
+// clusterSelectionNamespaces returns the namespaces the clusters are selected
+// in. These are the namespaces of all enabled resources, including the ones
+// overriding the namespaces of single resources.
+func (b *Builder) clusterSelectionNamespaces() options.NamespaceList {
+	if b.namespaces.IsAllNamespaces() {
+		return b.namespaces
+	}
+	set := map[string]bool{}
+	for _, ns := range b.namespaces {
+		set[ns] = true
+	}
+	for _, r := range b.enabledResources {
+		namespaces, ok := b.resourceNamespaces[r]
+		if !ok {
+			continue
+		}
+		if namespaces.IsAllNamespaces() {
+			return namespaces
+		}
+		for _, ns := range namespaces {
+			set[ns] = true
+		}
+	}
+	namespaces := make(options.NamespaceList, 0, len(set))
+	for ns := range set {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// withClusterFilter returns a store which only passes the objects of the
+// selected clusters to the given store, whose objects are kept by the given
+// metrics store. Otherwise the given store is returned as is.
+func (b *Builder) withClusterFilter(store cache.Store, metricsStore *MetricsStore) cache.Store {
+	if b.clusterSelection == nil {
+		return store
+	}
+	return b.clusterSelection.filter(store, metricsStore)
+}
+
+// startReflector starts a Kubernetes client-go reflector with the given
+// listWatcher and registers it with the given store.
+func (b *Builder) startReflector(
+	resourceName string,
+	expectedType interface{},
+	store cache.Store,
+	listWatcher cache.ListerWatcher,
+	useAPIServerCache bool,
+) {
+	instrumentedListWatch := watch.NewInstrumentedListerWatcher(listWatcher, b.listWatchMetrics, reflect.TypeOf(expectedType).String(), useAPIServerCache)
+	shardedListWatch := newShardedListWatch(b.shard, b.totalShards, instrumentedListWatch)
+	if b.exporterMetrics != nil {
+		shardedListWatch = newInstrumentedListWatch(b.storeCtx, shardedListWatch, b.exporterMetrics, b.managementCluster, resourceName)
+	}
+	if b.health != nil {
+		shardedListWatch = b.health.track(b.storeCtx, shardedListWatch, resourceName)
+	}
+	reflector := newReflector(shardedListWatch, expectedType, store, b.listPageSize)
+	go reflector.Run(b.storeCtx.Done())
+}
+
+// cacheStoresToMetricStores converts []cache.Store into []*MetricsStore
+func cacheStoresToMetricStores(cStores []cache.Store) []*MetricsStore {
+	mStores := make([]*MetricsStore, 0, len(cStores))
+	for _, store := range cStores {
+		mStores = append(mStores, store.(*MetricsStore))
+	}
+
+	return mStores
+}
@@ -0,0 +1,308 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kube-state-metrics/v2/pkg/allowdenylist"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	"k8s.io/kube-state-metrics/v2/pkg/options"
+	"k8s.io/utils/pointer"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+// countingMachineFactory lists a single machine and counts the lists.
+type countingMachineFactory struct {
+	lists int32
+}
+
+func (f *countingMachineFactory) Name() string {
+	return "machines"
+}
+
+func (f *countingMachineFactory) CreateClient(cfg *rest.Config) (interface{}, error) {
+	return nil, nil
+}
+
+func (f *countingMachineFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_test_info",
+			"Test info.",
+			metric.Gauge,
+			"",
+			func(obj interface{}) *metric.Family {
+				m := obj.(*clusterv1.Machine)
+				keys, values := []string{"namespace", "machine"}, []string{m.Namespace, m.Name}
+				for _, l := range allowLabelsList {
+					keys = append(keys, "label_"+l)
+					values = append(values, m.Labels[l])
+				}
+				return &metric.Family{
+					Metrics: []*metric.Metric{{LabelKeys: keys, LabelValues: values, Value: 1}},
+				}
+			},
+		),
+		*generator.NewFamilyGenerator(
+			"capi_test_created",
+			"Test created.",
+			metric.Gauge,
+			"",
+			func(obj interface{}) *metric.Family {
+				m := obj.(*clusterv1.Machine)
+				return &metric.Family{
+					Metrics: []*metric.Metric{{LabelKeys: []string{"namespace", "machine"}, LabelValues: []string{m.Namespace, m.Name}, Value: 1}},
+				}
+			},
+		),
+	}
+}
+
+func (f *countingMachineFactory) ExpectedType() interface{} {
+	return &clusterv1.Machine{}
+}
+
+func (f *countingMachineFactory) ListWatch(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			atomic.AddInt32(&f.lists, 1)
+			return &clusterv1.MachineList{
+				ListMeta: metav1.ListMeta{ResourceVersion: "1"},
+				Items: []clusterv1.Machine{{
+					ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "ns1", UID: "m1", Labels: map[string]string{"team": "a"}},
+				}},
+			}, nil
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			return watch.NewFake(), nil
+		},
+	}
+}
+
+func newFamilyGeneratorFilter(t *testing.T, denylist ...string) generator.FamilyGeneratorFilter {
+	t.Helper()
+	denied := map[string]struct{}{}
+	for _, d := range denylist {
+		denied[d] = struct{}{}
+	}
+	l, err := allowdenylist.New(map[string]struct{}{}, denied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	return generator.NewCompositeFamilyGeneratorFilter(l)
+}
+
+func TestBuilderRebuild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := &countingMachineFactory{}
+	b := NewBuilder()
+	b.WithMetrics(prometheus.NewRegistry())
+	b.WithSharding(0, 1)
+	b.WithContext(ctx)
+	b.WithCustomResourceClients(map[string]interface{}{f.Name(): nil})
+	b.WithCustomResourceStoreFactories(f)
+	b.WithGenerateCustomResourceStoresFunc(b.DefaultGenerateCustomResourceStoresFunc())
+	if err := b.WithEnabledResources([]string{f.Name()}); err != nil {
+		t.Fatal(err)
+	}
+	b.WithNamespaces(options.DefaultNamespaces, "")
+	b.WithFamilyGeneratorFilter(newFamilyGeneratorFilter(t))
+
+	expectMetrics := func(name string, writers []*MetricsStore, want string) {
+		t.Helper()
+		var got string
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			buf := &bytes.Buffer{}
+			for _, w := range writers {
+				w.WriteAll(buf)
+			}
+			if got = buf.String(); got == want {
+				return
+			}
+		}
+		t.Errorf("unexpected metrics %s, want:\n%s\ngot:\n%s", name, want, got)
+	}
+	stores := func() []*MetricsStore {
+		return b.builtResources[f.Name()].stores
+	}
+
+	b.Build()
+	expectMetrics("after the build", stores(), `# HELP capi_test_info Test info.
+# TYPE capi_test_info gauge
+capi_test_info{namespace="ns1",machine="m1"} 1
+# HELP capi_test_created Test created.
+# TYPE capi_test_created gauge
+capi_test_created{namespace="ns1",machine="m1"} 1
+`)
+	if got := atomic.LoadInt32(&f.lists); got != 1 {
+		t.Errorf("expected 1 list after the build, got %d", got)
+	}
+
+	// changed labels and metrics are applied to the existing stores
+	built := stores()
+	b.WithAllowLabels(map[string][]string{f.Name(): {"team"}})
+	b.WithFamilyGeneratorFilter(newFamilyGeneratorFilter(t, "capi_test_created"))
+	b.Rebuild()
+	if got := stores(); len(got) != 1 || got[0] != built[0] {
+		t.Error("expected the store to be kept when the labels and metrics changed")
+	}
+	expectMetrics("after the labels and metrics changed", stores(), `# HELP capi_test_info Test info.
+# TYPE capi_test_info gauge
+capi_test_info{namespace="ns1",machine="m1",label_team="a"} 1
+`)
+	if got := atomic.LoadInt32(&f.lists); got != 1 {
+		t.Errorf("expected no list after the labels and metrics changed, got %d", got-1)
+	}
+
+	// changed namespaces rebuild the store
+	b.WithNamespaces(options.NamespaceList{"ns1"}, "")
+	b.Rebuild()
+	if got := stores(); got[0] == built[0] {
+		t.Error("expected the store to be rebuilt when the namespaces changed")
+	}
+	expectMetrics("after the namespaces changed", stores(), `# HELP capi_test_info Test info.
+# TYPE capi_test_info gauge
+capi_test_info{namespace="ns1",machine="m1",label_team="a"} 1
+`)
+	if got := atomic.LoadInt32(&f.lists); got != 2 {
+		t.Errorf("expected 2 lists after the namespaces changed, got %d", got)
+	}
+
+	// disabled resources are removed
+	if err := b.WithEnabledResources(nil); err != nil {
+		t.Fatal(err)
+	}
+	if writers := b.Rebuild(); len(writers) != 0 || len(b.builtResources) != 0 {
+		t.Errorf("expected no stores after the resource was disabled, got %d", len(writers))
+	}
+}
+
+func TestBuilderClusterSelectionNamespaces(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		namespaces         options.NamespaceList
+		resourceNamespaces map[string]options.NamespaceList
+		want               options.NamespaceList
+	}{
+		{
+			name:       "all namespaces",
+			namespaces: options.DefaultNamespaces,
+			resourceNamespaces: map[string]options.NamespaceList{
+				"machines": {"ns2"},
+			},
+			want: options.DefaultNamespaces,
+		},
+		{
+			name:       "namespaces of the enabled resources",
+			namespaces: options.NamespaceList{"ns1"},
+			resourceNamespaces: map[string]options.NamespaceList{
+				"machines":    {"ns3", "ns1"},
+				"machinesets": {"ns2"},
+				"secrets":     {"ns4"},
+			},
+			want: options.NamespaceList{"ns1", "ns2", "ns3"},
+		},
+		{
+			name:       "all namespaces of an enabled resource",
+			namespaces: options.NamespaceList{"ns1"},
+			resourceNamespaces: map[string]options.NamespaceList{
+				"machines": options.DefaultNamespaces,
+			},
+			want: options.DefaultNamespaces,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBuilder()
+			b.WithCustomResourceStoreFactories(&countingMachineFactory{}, &MachineSetFactory{})
+			if err := b.WithEnabledResources([]string{"machines", "machinesets"}); err != nil {
+				t.Fatal(err)
+			}
+			b.WithNamespaces(tc.namespaces, "")
+			b.WithResourceNamespaces(tc.resourceNamespaces)
+			if got := b.clusterSelectionNamespaces(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected namespaces %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWithGenerationErrors(t *testing.T) {
+	b := NewBuilder()
+	b.WithMetrics(prometheus.NewRegistry())
+	b.WithManagementCluster("eu")
+
+	maxSurge := intstr.FromString("abc%")
+	maxUnavailable := intstr.FromInt(1)
+	md := &clusterv1.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "md1", Namespace: "ns1", UID: "foo"},
+		Spec: clusterv1.MachineDeploymentSpec{
+			Replicas: pointer.Int32(3),
+			Strategy: &clusterv1.MachineDeploymentStrategy{
+				Type: clusterv1.RollingUpdateMachineDeploymentStrategyType,
+				RollingUpdate: &clusterv1.MachineRollingUpdateDeployment{
+					MaxSurge:       &maxSurge,
+					MaxUnavailable: &maxUnavailable,
+				},
+			},
+		},
+	}
+	families := b.withManagementClusterLabel(b.withGenerationErrors((&MachineDeploymentFactory{}).MetricFamilyGenerators(nil, nil)))
+	var out []string
+	for _, f := range generator.ComposeMetricGenFuncs(families)(md) {
+		out = append(out, string(f.ByteSlice()))
+	}
+	metrics := strings.Join(out, "")
+	if strings.Contains(metrics, "capi_machinedeployment_spec_strategy_rollingupdate_max_surge{") {
+		t.Errorf("expected the malformed max surge to be skipped, got:\n%s", metrics)
+	}
+	if !strings.Contains(metrics, `capi_machinedeployment_spec_strategy_rollingupdate_max_unavailable{management_cluster="eu",namespace="ns1",machinedeployment="md1",uid="foo"} 1`) {
+		t.Errorf("expected the other families to be generated, got:\n%s", metrics)
+	}
+
+	panicking := b.withGenerationErrors([]generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_test_info",
+			"Test info.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				var family *metric.Family
+				return &metric.Family{Metrics: family.Metrics}
+			}),
+		),
+	})
+	if got := panicking[0].GenerateFunc(Objects{}); len(got.Metrics) != 0 {
+		t.Errorf("expected no metrics of a panicking generator, got %d", len(got.Metrics))
+	}
+
+	for name, want := range map[string]float64{
+		"capi_machinedeployment_spec_strategy_rollingupdate_max_surge":       1,
+		"capi_machinedeployment_spec_strategy_rollingupdate_max_unavailable": 0,
+		"capi_test_info": 1,
+	} {
+		if got := testutil.ToFloat64(b.exporterMetrics.GenerationErrors.WithLabelValues("eu", name)); got != want {
+			t.Errorf("expected %v generation errors of %s, got %v", want, name, got)
+		}
+	}
+}
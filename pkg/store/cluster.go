@@ -4,6 +4,8 @@ package store
 
 import (
 	"context"
+	"sort"
+	"strconv"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -142,31 +144,72 @@ func (f *ClusterFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabel
 				return getConditionMetricFamily(c.Status.Conditions)
 			}),
 		),
+		*generator.NewFamilyGenerator(
+			"capi_cluster_failure_domains",
+			"The failure domains of a cluster with their control plane eligibility and attributes.",
+			metric.Gauge,
+			"",
+			wrapClusterFunc(func(c *clusterv1.Cluster) *metric.Family {
+				names := make([]string, 0, len(c.Status.FailureDomains))
+				for name := range c.Status.FailureDomains {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				ms := make([]*metric.Metric, len(names))
+
+				for i, name := range names {
+					fd := c.Status.FailureDomains[name]
+					attributeKeys, attributeValues := mapToPrometheusLabels(fd.Attributes, "attribute")
+					ms[i] = &metric.Metric{
+						LabelKeys:   append([]string{"failure_domain", "control_plane"}, attributeKeys...),
+						LabelValues: append([]string{name, strconv.FormatBool(fd.ControlPlane)}, attributeValues...),
+						Value:       1,
+					}
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
 	}
 }
 
 func (f *ClusterFactory) ListWatch(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+	return f.ListWatchWithContext(context.Background(), customResourceClient, ns, fieldSelector)
+}
+
+func (f *ClusterFactory) ListWatchWithContext(ctx context.Context, customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
 	ctrlClient := customResourceClient.(client.WithWatch)
 	return &cache.ListWatch{
 		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
 			clusterList := clusterv1.ClusterList{}
 			opts.FieldSelector = fieldSelector
-			err := ctrlClient.List(context.TODO(), &clusterList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			err := ctrlClient.List(ctx, &clusterList, &client.ListOptions{Raw: &opts, Namespace: ns, Limit: opts.Limit, Continue: opts.Continue})
 			return &clusterList, err
 		},
 		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
 			clusterList := clusterv1.ClusterList{}
 			opts.FieldSelector = fieldSelector
-			return ctrlClient.Watch(context.TODO(), &clusterList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			return ctrlClient.Watch(ctx, &clusterList, &client.ListOptions{Raw: &opts, Namespace: ns})
 		},
 	}
 }
 
+func (f *ClusterFactory) TransformObject(obj interface{}) {
+	stripObjectMeta(obj.(*clusterv1.Cluster))
+}
+
 func wrapClusterFunc(f func(*clusterv1.Cluster) *metric.Family) func(interface{}) *metric.Family {
-	return func(obj interface{}) *metric.Family {
+	return func(obj interface{}) (metricFamily *metric.Family) {
 		cluster := obj.(*clusterv1.Cluster)
+		defer recoverFamily(&metricFamily, "cluster", cluster)
 
-		metricFamily := f(cluster)
+		metricFamily = f(cluster)
+		if metricFamily == nil {
+			return nil
+		}
 
 		for _, m := range metricFamily.Metrics {
 			m.LabelKeys = append(descClusterLabelsDefaultLabels, m.LabelKeys...)
@@ -104,6 +104,36 @@ func TestClusterStore(t *testing.T) {
 			`,
 			MetricNames: []string{"capi_cluster_status_condition"},
 		},
+		{
+			Obj: &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "cluster3",
+					Namespace: "ns3",
+					UID:       types.UID("foo"),
+				},
+				Status: clusterv1.ClusterStatus{
+					FailureDomains: clusterv1.FailureDomains{
+						"zone-b": clusterv1.FailureDomainSpec{
+							ControlPlane: false,
+						},
+						"zone-a": clusterv1.FailureDomainSpec{
+							ControlPlane: true,
+							Attributes: map[string]string{
+								"region":   "eu-central-1",
+								"subnetID": "subnet-1",
+							},
+						},
+					},
+				},
+			},
+			Want: `
+				# HELP capi_cluster_failure_domains The failure domains of a cluster with their control plane eligibility and attributes.
+				# TYPE capi_cluster_failure_domains gauge
+				capi_cluster_failure_domains{attribute_region="eu-central-1",attribute_subnet_id="subnet-1",cluster="cluster3",control_plane="true",failure_domain="zone-a",namespace="ns3",uid="foo"} 1
+				capi_cluster_failure_domains{cluster="cluster3",control_plane="false",failure_domain="zone-b",namespace="ns3",uid="foo"} 1
+			`,
+			MetricNames: []string{"capi_cluster_failure_domains"},
+		},
 	}
 	for i, c := range cases {
 		f := ClusterFactory{}
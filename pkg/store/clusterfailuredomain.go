@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"sort"
+	"strconv"
+
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+var descClusterFailureDomainMachinesLabels = []string{"namespace", "cluster", "failure_domain", "control_plane"}
+
+// ClusterFailureDomainFactory generates metrics about the distribution of the
+// machines of a cluster across its failure domains.
+type ClusterFailureDomainFactory struct{}
+
+func (f *ClusterFailureDomainFactory) Name() string {
+	return "clusterfailuredomains"
+}
+
+func (f *ClusterFailureDomainFactory) Resources() []string {
+	return []string{"machines"}
+}
+
+func (f *ClusterFailureDomainFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_cluster_failure_domain_machines",
+			"The number of control plane and worker machines of a cluster per failure domain.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				// counts maps the label values of a metric to the number of machines.
+				counts := map[[4]string]int{}
+				for _, obj := range o.List("machines") {
+					m := obj.(*clusterv1.Machine)
+					if m.Spec.FailureDomain == nil {
+						continue
+					}
+					_, controlPlane := m.Labels[clusterv1.MachineControlPlaneLabelName]
+					counts[[4]string{m.Namespace, m.Spec.ClusterName, *m.Spec.FailureDomain, strconv.FormatBool(controlPlane)}]++
+				}
+
+				keys := make([][4]string, 0, len(counts))
+				for k := range counts {
+					keys = append(keys, k)
+				}
+				sort.Slice(keys, func(i, j int) bool {
+					for n := range keys[i] {
+						if keys[i][n] != keys[j][n] {
+							return keys[i][n] < keys[j][n]
+						}
+					}
+					return false
+				})
+
+				ms := make([]*metric.Metric, len(keys))
+
+				for i, k := range keys {
+					ms[i] = &metric.Metric{
+						LabelKeys:   descClusterFailureDomainMachinesLabels,
+						LabelValues: []string{k[0], k[1], k[2], k[3]},
+						Value:       float64(counts[k]),
+					}
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+	}
+}
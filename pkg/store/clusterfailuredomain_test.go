@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	"k8s.io/utils/pointer"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestClusterFailureDomainStore(t *testing.T) {
+	newMachine := func(name, cluster string, failureDomain *string, controlPlane bool) *clusterv1.Machine {
+		m := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns1",
+				Labels:    map[string]string{},
+			},
+			Spec: clusterv1.MachineSpec{
+				ClusterName:   cluster,
+				FailureDomain: failureDomain,
+			},
+		}
+		if controlPlane {
+			m.Labels[clusterv1.MachineControlPlaneLabelName] = ""
+		}
+		return m
+	}
+
+	f := &ClusterFailureDomainFactory{}
+	tc := generateMetricsTestCase{
+		Obj: newObjects(map[string][]interface{}{
+			"machines": {
+				newMachine("cp1", "c1", pointer.String("zone-a"), true),
+				newMachine("cp2", "c1", pointer.String("zone-a"), true),
+				newMachine("cp3", "c1", pointer.String("zone-a"), true),
+				newMachine("w1", "c1", pointer.String("zone-a"), false),
+				newMachine("w2", "c1", pointer.String("zone-b"), false),
+				newMachine("w3", "c1", nil, false),
+				newMachine("cp4", "c2", pointer.String("zone-b"), true),
+			},
+		}),
+		Want: `
+			# HELP capi_cluster_failure_domain_machines The number of control plane and worker machines of a cluster per failure domain.
+			# TYPE capi_cluster_failure_domain_machines gauge
+			capi_cluster_failure_domain_machines{cluster="c1",control_plane="false",failure_domain="zone-a",namespace="ns1"} 1
+			capi_cluster_failure_domain_machines{cluster="c1",control_plane="false",failure_domain="zone-b",namespace="ns1"} 1
+			capi_cluster_failure_domain_machines{cluster="c1",control_plane="true",failure_domain="zone-a",namespace="ns1"} 3
+			capi_cluster_failure_domain_machines{cluster="c2",control_plane="true",failure_domain="zone-b",namespace="ns1"} 1
+		`,
+		Func:    generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil)),
+		Headers: generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil)),
+	}
+	if err := tc.run(); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
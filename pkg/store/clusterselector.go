@@ -0,0 +1,300 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+// clusterIndex is the name of the index of the objects by the namespace and
+// name of their cluster.
+const clusterIndex = "cluster"
+
+// clusterSelection keeps the clusters which match the cluster selector. The
+// clusterFilteringStores are refreshed whenever a cluster is selected or
+// deselected, so the objects of the cluster are added or removed.
+type clusterSelection struct {
+	mtx sync.RWMutex
+	// clusters holds the selected clusters per namespace store.
+	clusters []cache.Store
+	stores   []*clusterFilteringStore
+}
+
+// newClusterSelection starts a reflector per namespace which watches the
+// clusters matching the selector until the context is done.
+func newClusterSelection(ctx context.Context, selector labels.Selector, listWatchFunc func(ns string) cache.ListerWatcher, namespaces []string, pageSize int64) *clusterSelection {
+	s := &clusterSelection{}
+	for _, ns := range namespaces {
+		store := &clusterSelectionStore{
+			Store:     cache.NewStore(cache.MetaNamespaceKeyFunc),
+			selection: s,
+		}
+		s.clusters = append(s.clusters, store)
+		listWatcher := withLabelSelector(listWatchFunc(ns), selector.String())
+		reflector := newReflector(listWatcher, &clusterv1.Cluster{}, store, pageSize)
+		go reflector.Run(ctx.Done())
+	}
+	return s
+}
+
+// selected returns true if the object is a selected cluster or belongs to a
+// selected cluster by the cluster name label.
+func (s *clusterSelection) selected(obj interface{}) bool {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+
+	name := getClusterName(obj)
+	if name == "" {
+		return false
+	}
+
+	key := o.GetNamespace() + "/" + name
+	for _, c := range s.clusters {
+		if _, exists, _ := c.GetByKey(key); exists {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterObjectLister lists the objects of a cluster.
+type clusterObjectLister interface {
+	clusterObjects(namespace, cluster string) []interface{}
+}
+
+// filter returns a store which only passes the objects of selected clusters to
+// the given store. The lister has to list the objects which were passed to the
+// store, so they can be removed when their cluster is deselected.
+func (s *clusterSelection) filter(store cache.Store, lister clusterObjectLister) cache.Store {
+	f := &clusterFilteringStore{
+		Store:      store,
+		lister:     lister,
+		deselected: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{clusterIndex: clusterIndexFunc}),
+		selection:  s,
+	}
+	s.mtx.Lock()
+	s.stores = append(s.stores, f)
+	s.mtx.Unlock()
+	return f
+}
+
+// refresh adds the objects of the cluster with the given key to the filtering
+// stores if it was selected or removes them if it was deselected.
+func (s *clusterSelection) refresh(key string, selected bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	for _, f := range s.stores {
+		f.refresh(key, selected)
+	}
+}
+
+// clusterSelectionStore holds the selected clusters of a namespace and
+// refreshes the filtering stores if the set of selected clusters changed.
+type clusterSelectionStore struct {
+	cache.Store
+	selection *clusterSelection
+}
+
+func (s *clusterSelectionStore) Add(obj interface{}) error {
+	_, exists, _ := s.Store.Get(obj)
+	if err := s.Store.Add(obj); err != nil {
+		return err
+	}
+	if !exists {
+		key, _ := cache.MetaNamespaceKeyFunc(obj)
+		s.selection.refresh(key, true)
+	}
+	return nil
+}
+
+func (s *clusterSelectionStore) Update(obj interface{}) error {
+	return s.Add(obj)
+}
+
+func (s *clusterSelectionStore) Delete(obj interface{}) error {
+	if err := s.Store.Delete(obj); err != nil {
+		return err
+	}
+	key, _ := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	s.selection.refresh(key, false)
+	return nil
+}
+
+// Replace refreshes the filtering stores for the clusters which were selected
+// or deselected since the previous list.
+func (s *clusterSelectionStore) Replace(list []interface{}, resourceVersion string) error {
+	previous := map[string]bool{}
+	for _, key := range s.Store.ListKeys() {
+		previous[key] = true
+	}
+	if err := s.Store.Replace(list, resourceVersion); err != nil {
+		return err
+	}
+	for _, obj := range list {
+		key, _ := cache.MetaNamespaceKeyFunc(obj)
+		if !previous[key] {
+			s.selection.refresh(key, true)
+		}
+		delete(previous, key)
+	}
+	for key := range previous {
+		s.selection.refresh(key, false)
+	}
+	return nil
+}
+
+// clusterFilteringStore only passes the objects of selected clusters to the
+// embedded store. It only keeps the objects of deselected clusters indexed by
+// their cluster, so they can be passed on when their cluster is selected. The
+// objects of selected clusters are listed from the embedded store when their
+// cluster is deselected.
+type clusterFilteringStore struct {
+	cache.Store
+	mtx        sync.Mutex
+	lister     clusterObjectLister
+	deselected cache.Indexer
+	selection  *clusterSelection
+}
+
+func (s *clusterFilteringStore) Add(obj interface{}) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.add(obj)
+}
+
+func (s *clusterFilteringStore) Update(obj interface{}) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.add(obj)
+}
+
+// add passes the object to the embedded store if its cluster is selected and
+// keeps it otherwise. Objects without a cluster are dropped, as they are never
+// selected. The mutex has to be held by the caller.
+func (s *clusterFilteringStore) add(obj interface{}) error {
+	if s.selection.selected(obj) {
+		if err := s.deselected.Delete(obj); err != nil {
+			return err
+		}
+		return s.Store.Add(obj)
+	}
+
+	var err error
+	if getClusterName(obj) != "" {
+		err = s.deselected.Add(obj)
+	} else {
+		err = s.deselected.Delete(obj)
+	}
+	if err != nil {
+		return err
+	}
+	return s.Store.Delete(obj)
+}
+
+func (s *clusterFilteringStore) Delete(obj interface{}) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.deselected.Delete(obj); err != nil {
+		return err
+	}
+	return s.Store.Delete(obj)
+}
+
+func (s *clusterFilteringStore) Replace(list []interface{}, resourceVersion string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	selected := make([]interface{}, 0, len(list))
+	var deselected []interface{}
+	for _, obj := range list {
+		if s.selection.selected(obj) {
+			selected = append(selected, obj)
+		} else if getClusterName(obj) != "" {
+			deselected = append(deselected, obj)
+		}
+	}
+	if err := s.deselected.Replace(deselected, resourceVersion); err != nil {
+		return err
+	}
+	return s.Store.Replace(selected, resourceVersion)
+}
+
+// refresh passes the objects of the cluster with the given key to the embedded
+// store if the cluster is selected or removes them otherwise.
+func (s *clusterFilteringStore) refresh(key string, selected bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var objs []interface{}
+	if selected {
+		var err error
+		objs, err = s.deselected.ByIndex(clusterIndex, key)
+		if err != nil {
+			klog.Errorf("Failed to get objects of cluster %s: %v", key, err)
+			return
+		}
+	} else {
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			klog.Errorf("Failed to get objects of cluster %s: %v", key, err)
+			return
+		}
+		objs = s.lister.clusterObjects(namespace, name)
+	}
+
+	for _, obj := range objs {
+		var err error
+		if selected {
+			if err = s.Store.Add(obj); err == nil {
+				err = s.deselected.Delete(obj)
+			}
+		} else {
+			if err = s.Store.Delete(obj); err == nil {
+				err = s.deselected.Add(obj)
+			}
+		}
+		if err != nil {
+			klog.Errorf("Failed to refresh objects of cluster %s: %v", key, err)
+		}
+	}
+}
+
+// clusterIndexFunc indexes the objects by the namespace and name of their
+// cluster. Objects without a cluster are not indexed.
+func clusterIndexFunc(obj interface{}) ([]string, error) {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	name := getClusterName(obj)
+	if name == "" {
+		return nil, nil
+	}
+	return []string{o.GetNamespace() + "/" + name}, nil
+}
+
+// withLabelSelector returns a ListerWatcher which lists and watches the objects
+// of the given ListerWatcher matching the label selector.
+func withLabelSelector(lw cache.ListerWatcher, selector string) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.LabelSelector = selector
+			return lw.List(opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.LabelSelector = selector
+			return lw.Watch(opts)
+		},
+	}
+}
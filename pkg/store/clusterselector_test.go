@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+// countingStore counts the changes passed to the embedded store.
+type countingStore struct {
+	cache.Store
+	changes int
+}
+
+func (s *countingStore) Add(obj interface{}) error {
+	s.changes++
+	return s.Store.Add(obj)
+}
+
+func (s *countingStore) Delete(obj interface{}) error {
+	s.changes++
+	return s.Store.Delete(obj)
+}
+
+func (s *countingStore) Replace(list []interface{}, resourceVersion string) error {
+	s.changes += len(list)
+	return s.Store.Replace(list, resourceVersion)
+}
+
+// clusterIndexedStore lists the objects of a cluster like the MetricsStore.
+type clusterIndexedStore struct {
+	cache.Indexer
+}
+
+func (s clusterIndexedStore) clusterObjects(namespace, cluster string) []interface{} {
+	objs, _ := s.ByIndex(clusterIndex, namespace+"/"+cluster)
+	return objs
+}
+
+func newClusterIndexedStore() clusterIndexedStore {
+	return clusterIndexedStore{cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{clusterIndex: clusterIndexFunc})}
+}
+
+func TestClusterFilteringStore(t *testing.T) {
+	s := &clusterSelection{}
+	selected := &clusterSelectionStore{
+		Store:     cache.NewStore(cache.MetaNamespaceKeyFunc),
+		selection: s,
+	}
+	s.clusters = []cache.Store{selected}
+
+	machineStore, clusters := newClusterIndexedStore(), newClusterIndexedStore()
+	machines := &countingStore{Store: machineStore}
+	filteredMachines := s.filter(machines, machineStore)
+	filteredClusters := s.filter(clusters, clusters)
+	deselectedMachines := filteredMachines.(*clusterFilteringStore).deselected
+
+	newCluster := func(name string) *clusterv1.Cluster {
+		return &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1"}}
+	}
+	newMachine := func(name, clusterName string) *clusterv1.Machine {
+		m := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1"}}
+		if clusterName != "" {
+			m.Labels = map[string]string{clusterv1.ClusterLabelName: clusterName}
+		}
+		return m
+	}
+	expectKeys := func(name string, store cache.Store, want ...string) {
+		t.Helper()
+		got := store.ListKeys()
+		sort.Strings(got)
+		if len(got) == 0 && len(want) == 0 {
+			return
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %s %v, got %v", name, want, got)
+		}
+	}
+
+	if err := selected.Replace([]interface{}{newCluster("c1")}, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := filteredClusters.Replace([]interface{}{newCluster("c1"), newCluster("c2")}, "1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*clusterv1.Machine{newMachine("m1", "c1"), newMachine("m2", "c2"), newMachine("m3", "")} {
+		if err := filteredMachines.Add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectKeys("clusters", clusters, "ns1/c1")
+	expectKeys("machines", machines, "ns1/m1")
+	// only the objects of deselected clusters are kept
+	expectKeys("deselected machines", deselectedMachines, "ns1/m2")
+
+	// selecting a cluster only adds its objects
+	machines.changes = 0
+	if err := selected.Add(newCluster("c2")); err != nil {
+		t.Fatal(err)
+	}
+	expectKeys("clusters after selecting c2", clusters, "ns1/c1", "ns1/c2")
+	expectKeys("machines after selecting c2", machines, "ns1/m1", "ns1/m2")
+	expectKeys("deselected machines after selecting c2", deselectedMachines)
+	if machines.changes != 1 {
+		t.Errorf("expected only the machine of c2 to be added, got %d changes", machines.changes)
+	}
+
+	// relisting the selected clusters only refreshes the changed clusters
+	machines.changes = 0
+	if err := selected.Replace([]interface{}{newCluster("c1"), newCluster("c2")}, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if machines.changes != 0 {
+		t.Errorf("expected no changes without a changed selection, got %d", machines.changes)
+	}
+
+	// moving an object to a deselected cluster removes it
+	if err := selected.Delete(newCluster("c1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := filteredMachines.Update(newMachine("m2", "c1")); err != nil {
+		t.Fatal(err)
+	}
+	expectKeys("clusters after deselecting c1", clusters, "ns1/c2")
+	expectKeys("machines after deselecting c1", machines)
+	expectKeys("deselected machines after deselecting c1", deselectedMachines, "ns1/m1", "ns1/m2")
+
+	// selecting the cluster again adds the objects which were removed
+	if err := selected.Add(newCluster("c1")); err != nil {
+		t.Fatal(err)
+	}
+	expectKeys("machines after selecting c1 again", machines, "ns1/m1", "ns1/m2")
+	expectKeys("deselected machines after selecting c1 again", deselectedMachines)
+}
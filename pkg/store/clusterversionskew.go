@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha4"
+)
+
+const (
+	// maxControlPlaneMinorVersionSkew is the maximum allowed minor version
+	// skew between kube-apiserver instances.
+	maxControlPlaneMinorVersionSkew = 1
+	// maxKubeletMinorVersionSkew is the maximum number of minor versions a
+	// kubelet may be older than kube-apiserver.
+	maxKubeletMinorVersionSkew = 2
+	// maxKubeletMinorVersionSkewSince128 is the maximum number of minor
+	// versions a kubelet may be older than kube-apiserver since Kubernetes 1.28.
+	maxKubeletMinorVersionSkewSince128 = 3
+)
+
+var descClusterVersionSkewLabels = []string{"namespace", "cluster", "kind", "name", "version", "control_plane_version"}
+
+// ClusterVersionSkewFactory generates metrics about the Kubernetes version
+// skew between the kubeadmcontrolplane of a cluster and its machinedeployments
+// and machines.
+type ClusterVersionSkewFactory struct{}
+
+func (f *ClusterVersionSkewFactory) Name() string {
+	return "clusterversionskews"
+}
+
+func (f *ClusterVersionSkewFactory) Resources() []string {
+	return []string{"clusters", "kubeadmcontrolplanes", "machinedeployments", "machines"}
+}
+
+func (f *ClusterVersionSkewFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_cluster_version_skew",
+			"The number of minor versions a machinedeployment or machine is behind the kubeadmcontrolplane of its cluster.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				skews := getClusterVersionSkews(o)
+				ms := make([]*metric.Metric, len(skews))
+
+				for i, s := range skews {
+					ms[i] = &metric.Metric{
+						LabelKeys:   descClusterVersionSkewLabels,
+						LabelValues: s.labelValues(),
+						Value:       float64(s.minorSkew),
+					}
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+		*generator.NewFamilyGenerator(
+			"capi_cluster_version_skew_violation",
+			"The version of a machinedeployment or machine violates the Kubernetes version skew policy towards the kubeadmcontrolplane of its cluster.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				skews := getClusterVersionSkews(o)
+				ms := make([]*metric.Metric, len(skews))
+
+				for i, s := range skews {
+					ms[i] = &metric.Metric{
+						LabelKeys:   descClusterVersionSkewLabels,
+						LabelValues: s.labelValues(),
+						Value:       boolFloat64(s.violation),
+					}
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+	}
+}
+
+// clusterVersionSkew is the version skew of a single object towards the
+// control plane version of its cluster.
+type clusterVersionSkew struct {
+	namespace           string
+	cluster             string
+	kind                string
+	name                string
+	version             string
+	controlPlaneVersion string
+	minorSkew           int
+	violation           bool
+}
+
+func (s clusterVersionSkew) labelValues() []string {
+	return []string{s.namespace, s.cluster, s.kind, s.name, s.version, s.controlPlaneVersion}
+}
+
+// getClusterVersionSkews returns the version skew of all machinedeployments
+// and machines which belong to a cluster with a kubeadmcontrolplane. Objects
+// without a parsable version are skipped.
+func getClusterVersionSkews(o Objects) []clusterVersionSkew {
+	kcps := map[string]*controlplanev1.KubeadmControlPlane{}
+	for _, obj := range o.List("kubeadmcontrolplanes") {
+		kcp := obj.(*controlplanev1.KubeadmControlPlane)
+		kcps[kcp.Namespace+"/"+kcp.Name] = kcp
+	}
+
+	// controlPlaneVersions maps namespace/cluster to the version of its
+	// kubeadmcontrolplane. This is the oldest version of its machines, as the
+	// desired version in the spec is not running yet during an upgrade.
+	controlPlaneVersions := map[string]string{}
+	for _, obj := range o.List("clusters") {
+		c := obj.(*clusterv1.Cluster)
+		ref := c.Spec.ControlPlaneRef
+		if ref == nil || ref.Kind != "KubeadmControlPlane" {
+			continue
+		}
+		ns := ref.Namespace
+		if ns == "" {
+			ns = c.Namespace
+		}
+		if kcp, ok := kcps[ns+"/"+ref.Name]; ok {
+			controlPlaneVersions[c.Namespace+"/"+c.Name] = getKubeadmControlPlaneRunningVersion(kcp)
+		}
+	}
+
+	skews := []clusterVersionSkew{}
+	add := func(namespace, cluster, kind, name string, objVersion *string, controlPlane bool) {
+		controlPlaneVersion, ok := controlPlaneVersions[namespace+"/"+cluster]
+		if !ok || objVersion == nil {
+			return
+		}
+		cpv, err := version.ParseGeneric(controlPlaneVersion)
+		if err != nil {
+			return
+		}
+		v, err := version.ParseGeneric(*objVersion)
+		if err != nil {
+			return
+		}
+
+		minorSkew := int(cpv.Minor()) - int(v.Minor())
+		violation := cpv.Major() != v.Major()
+		if controlPlane {
+			violation = violation || minorSkew > maxControlPlaneMinorVersionSkew || minorSkew < -maxControlPlaneMinorVersionSkew
+		} else {
+			violation = violation || minorSkew > getMaxKubeletMinorVersionSkew(cpv) || minorSkew < 0
+		}
+
+		skews = append(skews, clusterVersionSkew{
+			namespace:           namespace,
+			cluster:             cluster,
+			kind:                kind,
+			name:                name,
+			version:             *objVersion,
+			controlPlaneVersion: controlPlaneVersion,
+			minorSkew:           minorSkew,
+			violation:           violation,
+		})
+	}
+
+	for _, obj := range o.List("machinedeployments") {
+		md := obj.(*clusterv1.MachineDeployment)
+		add(md.Namespace, md.Spec.ClusterName, "MachineDeployment", md.Name, md.Spec.Template.Spec.Version, false)
+	}
+	for _, obj := range o.List("machines") {
+		m := obj.(*clusterv1.Machine)
+		_, controlPlane := m.Labels[clusterv1.MachineControlPlaneLabelName]
+		add(m.Namespace, m.Spec.ClusterName, "Machine", m.Name, m.Status.Version, controlPlane)
+	}
+
+	return skews
+}
+
+// getKubeadmControlPlaneRunningVersion returns the oldest version of the
+// control plane machines, which falls back to the desired version until it is
+// reported.
+func getKubeadmControlPlaneRunningVersion(kcp *controlplanev1.KubeadmControlPlane) string {
+	if kcp.Status.Version != nil && *kcp.Status.Version != "" {
+		return *kcp.Status.Version
+	}
+	return kcp.Spec.Version
+}
+
+// getMaxKubeletMinorVersionSkew returns the maximum number of minor versions a
+// kubelet may be older than kube-apiserver of the given version.
+func getMaxKubeletMinorVersionSkew(apiserverVersion *version.Version) int {
+	if apiserverVersion.Major() > 1 || apiserverVersion.Minor() >= 28 {
+		return maxKubeletMinorVersionSkewSince128
+	}
+	return maxKubeletMinorVersionSkew
+}
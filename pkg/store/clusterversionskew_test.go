@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	"k8s.io/utils/pointer"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha4"
+)
+
+func TestClusterVersionSkewStore(t *testing.T) {
+	newCluster := func(name, controlPlaneKind string) *clusterv1.Cluster {
+		return &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns1",
+			},
+			Spec: clusterv1.ClusterSpec{
+				ControlPlaneRef: &corev1.ObjectReference{
+					Kind: controlPlaneKind,
+					Name: name + "-control-plane",
+				},
+			},
+		}
+	}
+	newMachine := func(name, cluster string, version *string, controlPlane bool) *clusterv1.Machine {
+		m := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns1",
+				Labels:    map[string]string{},
+			},
+			Spec: clusterv1.MachineSpec{
+				ClusterName: cluster,
+			},
+			Status: clusterv1.MachineStatus{
+				Version: version,
+			},
+		}
+		if controlPlane {
+			m.Labels[clusterv1.MachineControlPlaneLabelName] = ""
+		}
+		return m
+	}
+
+	objects := newObjects(map[string][]interface{}{
+		"clusters": {
+			newCluster("c1", "KubeadmControlPlane"),
+			newCluster("c2", "OtherControlPlane"),
+			newCluster("c3", "KubeadmControlPlane"),
+		},
+		"kubeadmcontrolplanes": {
+			&controlplanev1.KubeadmControlPlane{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "c1-control-plane",
+					Namespace: "ns1",
+				},
+				Spec: controlplanev1.KubeadmControlPlaneSpec{
+					Version: "v1.22.4",
+				},
+			},
+			&controlplanev1.KubeadmControlPlane{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "c3-control-plane",
+					Namespace: "ns1",
+				},
+				Spec: controlplanev1.KubeadmControlPlaneSpec{
+					Version: "v1.29.0",
+				},
+				Status: controlplanev1.KubeadmControlPlaneStatus{
+					Version: pointer.String("v1.28.3"),
+				},
+			},
+		},
+		"machinedeployments": {
+			&clusterv1.MachineDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "md1",
+					Namespace: "ns1",
+				},
+				Spec: clusterv1.MachineDeploymentSpec{
+					ClusterName: "c1",
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Version: pointer.String("v1.20.7"),
+						},
+					},
+				},
+			},
+			&clusterv1.MachineDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "md2",
+					Namespace: "ns1",
+				},
+				Spec: clusterv1.MachineDeploymentSpec{
+					ClusterName: "c2",
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Version: pointer.String("v1.20.7"),
+						},
+					},
+				},
+			},
+		},
+		"machines": {
+			newMachine("cp1", "c1", pointer.String("v1.22.4"), true),
+			newMachine("cp2", "c1", pointer.String("v1.20.7"), true),
+			newMachine("w1", "c1", pointer.String("v1.19.3"), false),
+			newMachine("w2", "c1", pointer.String("v1.23.0"), false),
+			newMachine("w3", "c1", nil, false),
+			newMachine("w4", "c2", pointer.String("v1.22.4"), false),
+			newMachine("cp3", "c3", pointer.String("v1.29.0"), true),
+			newMachine("w5", "c3", pointer.String("v1.25.9"), false),
+			newMachine("w6", "c3", pointer.String("v1.29.0"), false),
+		},
+	})
+
+	f := &ClusterVersionSkewFactory{}
+	tc := generateMetricsTestCase{
+		Obj: objects,
+		Want: `
+			# HELP capi_cluster_version_skew The number of minor versions a machinedeployment or machine is behind the kubeadmcontrolplane of its cluster.
+			# HELP capi_cluster_version_skew_violation The version of a machinedeployment or machine violates the Kubernetes version skew policy towards the kubeadmcontrolplane of its cluster.
+			# TYPE capi_cluster_version_skew gauge
+			# TYPE capi_cluster_version_skew_violation gauge
+			capi_cluster_version_skew{cluster="c1",control_plane_version="v1.22.4",kind="Machine",name="cp1",namespace="ns1",version="v1.22.4"} 0
+			capi_cluster_version_skew{cluster="c1",control_plane_version="v1.22.4",kind="Machine",name="cp2",namespace="ns1",version="v1.20.7"} 2
+			capi_cluster_version_skew{cluster="c1",control_plane_version="v1.22.4",kind="Machine",name="w1",namespace="ns1",version="v1.19.3"} 3
+			capi_cluster_version_skew{cluster="c1",control_plane_version="v1.22.4",kind="Machine",name="w2",namespace="ns1",version="v1.23.0"} -1
+			capi_cluster_version_skew{cluster="c1",control_plane_version="v1.22.4",kind="MachineDeployment",name="md1",namespace="ns1",version="v1.20.7"} 2
+			capi_cluster_version_skew{cluster="c3",control_plane_version="v1.28.3",kind="Machine",name="cp3",namespace="ns1",version="v1.29.0"} -1
+			capi_cluster_version_skew{cluster="c3",control_plane_version="v1.28.3",kind="Machine",name="w5",namespace="ns1",version="v1.25.9"} 3
+			capi_cluster_version_skew{cluster="c3",control_plane_version="v1.28.3",kind="Machine",name="w6",namespace="ns1",version="v1.29.0"} -1
+			capi_cluster_version_skew_violation{cluster="c1",control_plane_version="v1.22.4",kind="Machine",name="cp1",namespace="ns1",version="v1.22.4"} 0
+			capi_cluster_version_skew_violation{cluster="c1",control_plane_version="v1.22.4",kind="Machine",name="cp2",namespace="ns1",version="v1.20.7"} 1
+			capi_cluster_version_skew_violation{cluster="c1",control_plane_version="v1.22.4",kind="Machine",name="w1",namespace="ns1",version="v1.19.3"} 1
+			capi_cluster_version_skew_violation{cluster="c1",control_plane_version="v1.22.4",kind="Machine",name="w2",namespace="ns1",version="v1.23.0"} 1
+			capi_cluster_version_skew_violation{cluster="c1",control_plane_version="v1.22.4",kind="MachineDeployment",name="md1",namespace="ns1",version="v1.20.7"} 0
+			capi_cluster_version_skew_violation{cluster="c3",control_plane_version="v1.28.3",kind="Machine",name="cp3",namespace="ns1",version="v1.29.0"} 0
+			capi_cluster_version_skew_violation{cluster="c3",control_plane_version="v1.28.3",kind="Machine",name="w5",namespace="ns1",version="v1.25.9"} 0
+			capi_cluster_version_skew_violation{cluster="c3",control_plane_version="v1.28.3",kind="Machine",name="w6",namespace="ns1",version="v1.29.0"} 1
+		`,
+		Func:    generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil)),
+		Headers: generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil)),
+	}
+	if err := tc.run(); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
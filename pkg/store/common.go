@@ -3,9 +3,11 @@
 package store
 
 import (
+	"fmt"
 	"strconv"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 	"k8s.io/kube-state-metrics/v2/pkg/metric"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
 )
@@ -66,3 +68,23 @@ func getOwnerMetric(owners []metav1.OwnerReference) *metric.Family {
 		Metrics: ms,
 	}
 }
+
+// failedFamily logs the error of a metric family generator of the object and
+// returns nil. The Builder skips the family for the object and counts it as a
+// generation error.
+func failedFamily(kind string, obj metav1.Object, err error) *metric.Family {
+	if obj == nil {
+		klog.Errorf("Failed to generate metrics of %s: %v", kind, err)
+	} else {
+		klog.Errorf("Failed to generate metrics of %s %s/%s: %v", kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+	return nil
+}
+
+// recoverFamily recovers a panic of a metric family generator of the object,
+// logs it and sets the family to nil. It has to be deferred by the generator.
+func recoverFamily(family **metric.Family, kind string, obj metav1.Object) {
+	if r := recover(); r != nil {
+		*family = failedFamily(kind, obj, fmt.Errorf("panic: %v", r))
+	}
+}
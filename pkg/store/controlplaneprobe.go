@@ -0,0 +1,257 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/cluster-api/util/secret"
+)
+
+// ControlPlaneProbeFactory generates metrics about the reachability of the
+// control plane endpoints of the clusters. The /readyz endpoint of each
+// cluster is probed periodically, verifying the serving certificate with the
+// certificate authority of the cluster.
+type ControlPlaneProbeFactory struct {
+	// Interval is the interval in which the control plane endpoints are probed.
+	Interval time.Duration
+	// Timeout is the timeout of a single probe.
+	Timeout time.Duration
+
+	kubeClient clientset.Interface
+	mtx        sync.Mutex
+	results    map[string]*controlPlaneProbeResult
+}
+
+// controlPlaneProbeResult is the result of the last probe of a cluster.
+type controlPlaneProbeResult struct {
+	namespace string
+	name      string
+	uid       types.UID
+	success   bool
+	duration  time.Duration
+	// certificateExpiry is the expiry of the serving certificate, it is zero
+	// if no TLS connection could be established.
+	certificateExpiry time.Time
+}
+
+// NewControlPlaneProbeFactory returns a new ControlPlaneProbeFactory which uses
+// the given client to get the certificate authority secrets of the clusters.
+func NewControlPlaneProbeFactory(kubeClient clientset.Interface, interval, timeout time.Duration) *ControlPlaneProbeFactory {
+	return &ControlPlaneProbeFactory{
+		Interval:   interval,
+		Timeout:    timeout,
+		kubeClient: kubeClient,
+		results:    map[string]*controlPlaneProbeResult{},
+	}
+}
+
+func (f *ControlPlaneProbeFactory) Name() string {
+	return "controlplaneprobes"
+}
+
+func (f *ControlPlaneProbeFactory) Resources() []string {
+	return []string{"clusters"}
+}
+
+func (f *ControlPlaneProbeFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_cluster_control_plane_probe_success",
+			"The last probe of the /readyz endpoint of the control plane of a cluster was successful.",
+			metric.Gauge,
+			"",
+			f.wrapResultsFunc(func(r *controlPlaneProbeResult) (float64, bool) {
+				return boolFloat64(r.success), true
+			}),
+		),
+		*generator.NewFamilyGenerator(
+			"capi_cluster_control_plane_probe_duration_seconds",
+			"Duration of the last probe of the /readyz endpoint of the control plane of a cluster.",
+			metric.Gauge,
+			"",
+			f.wrapResultsFunc(func(r *controlPlaneProbeResult) (float64, bool) {
+				return r.duration.Seconds(), true
+			}),
+		),
+		*generator.NewFamilyGenerator(
+			"capi_cluster_control_plane_certificate_expiry",
+			"Unix timestamp when the serving certificate of the control plane endpoint of a cluster expires.",
+			metric.Gauge,
+			"",
+			f.wrapResultsFunc(func(r *controlPlaneProbeResult) (float64, bool) {
+				return float64(r.certificateExpiry.Unix()), !r.certificateExpiry.IsZero()
+			}),
+		),
+	}
+}
+
+// Run probes the control plane endpoints of all clusters every Interval until
+// the context is done.
+func (f *ControlPlaneProbeFactory) Run(ctx context.Context, o Objects) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		f.probeAll(ctx, o)
+	}, f.Interval)
+}
+
+// probeAll probes all clusters with a control plane endpoint concurrently.
+// Clusters which no longer exist are dropped.
+func (f *ControlPlaneProbeFactory) probeAll(ctx context.Context, o Objects) {
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	results := map[string]*controlPlaneProbeResult{}
+
+	for _, obj := range o.List("clusters") {
+		c := obj.(*clusterv1.Cluster)
+		if !c.Spec.ControlPlaneEndpoint.IsValid() {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := f.probe(ctx, c)
+			mtx.Lock()
+			results[c.Namespace+"/"+c.Name] = r
+			mtx.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	f.mtx.Lock()
+	f.results = results
+	f.mtx.Unlock()
+}
+
+// probe sends a request to the /readyz endpoint of the control plane of the
+// cluster. The probe is successful if the endpoint returns 200.
+func (f *ControlPlaneProbeFactory) probe(ctx context.Context, c *clusterv1.Cluster) *controlPlaneProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	r := &controlPlaneProbeResult{
+		namespace: c.Namespace,
+		name:      c.Name,
+		uid:       c.UID,
+	}
+
+	rootCAs, err := f.getCertificateAuthority(ctx, c)
+	if err != nil {
+		klog.Errorf("Failed to get certificate authority of cluster %s/%s: %v", c.Namespace, c.Name, err)
+		return r
+	}
+
+	endpoint := c.Spec.ControlPlaneEndpoint
+	url := "https://" + net.JoinHostPort(endpoint.Host, strconv.Itoa(int(endpoint.Port))) + "/readyz"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		klog.Errorf("Failed to create probe request for cluster %s/%s: %v", c.Namespace, c.Name, err)
+		return r
+	}
+
+	// The serving certificate is verified manually after its expiry was
+	// recorded, so the expiry is also known if the certificate is invalid.
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("no serving certificate")
+			}
+			r.certificateExpiry = cs.PeerCertificates[0].NotAfter
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         rootCAs,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			DisableKeepAlives: true,
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	r.duration = time.Since(start)
+	if err != nil {
+		klog.V(2).Infof("Failed to probe control plane endpoint of cluster %s/%s: %v", c.Namespace, c.Name, err)
+		return r
+	}
+	defer resp.Body.Close()
+
+	r.success = resp.StatusCode == http.StatusOK
+
+	return r
+}
+
+// getCertificateAuthority returns the certificate pool with the certificate
+// authority of the cluster.
+func (f *ControlPlaneProbeFactory) getCertificateAuthority(ctx context.Context, c *clusterv1.Cluster) (*x509.CertPool, error) {
+	s, err := f.kubeClient.CoreV1().Secrets(c.Namespace).Get(ctx, secret.Name(c.Name, secret.ClusterCA), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(s.Data[secret.TLSCrtDataName]) {
+		return nil, fmt.Errorf("no certificate found in %s of secret %s", secret.TLSCrtDataName, s.Name)
+	}
+	return pool, nil
+}
+
+// wrapResultsFunc generates a metric with the given value of the last probe
+// result of every cluster. No metric is generated if value returns false.
+func (f *ControlPlaneProbeFactory) wrapResultsFunc(value func(*controlPlaneProbeResult) (float64, bool)) func(interface{}) *metric.Family {
+	return wrapObjectsFunc(func(o Objects) *metric.Family {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+
+		keys := make([]string, 0, len(f.results))
+		for key := range f.results {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		ms := []*metric.Metric{}
+
+		for _, key := range keys {
+			r := f.results[key]
+			v, ok := value(r)
+			if !ok {
+				continue
+			}
+			ms = append(ms, &metric.Metric{
+				LabelKeys:   descClusterLabelsDefaultLabels,
+				LabelValues: []string{r.namespace, r.name, string(r.uid)},
+				Value:       v,
+			})
+		}
+
+		return &metric.Family{
+			Metrics: ms,
+		}
+	})
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestControlPlaneProbeStore(t *testing.T) {
+	ready := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ready.Close()
+	notReady := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer notReady.Close()
+
+	newCluster := func(name string, server *httptest.Server) *clusterv1.Cluster {
+		c := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns1",
+				UID:       types.UID(name),
+			},
+		}
+		if server != nil {
+			host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, _ := strconv.Atoi(port)
+			c.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{Host: host, Port: int32(p)}
+		}
+		return c
+	}
+	newCASecret := func(cluster string, ca []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      cluster + "-ca",
+				Namespace: "ns1",
+			},
+			Data: map[string][]byte{
+				"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca}),
+			},
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	otherCA, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate of c3 is not signed by its certificate authority and
+	// c4 has no certificate authority secret.
+	f := NewControlPlaneProbeFactory(fake.NewSimpleClientset(
+		newCASecret("c1", ready.Certificate().Raw),
+		newCASecret("c2", notReady.Certificate().Raw),
+		newCASecret("c3", otherCA),
+	), time.Minute, 5*time.Second)
+
+	f.probeAll(context.Background(), newObjects(map[string][]interface{}{
+		"clusters": {
+			newCluster("c1", ready),
+			newCluster("c2", notReady),
+			newCluster("c3", ready),
+			newCluster("c4", ready),
+			newCluster("c5", nil),
+		},
+	}))
+
+	expiry := ready.Certificate().NotAfter
+	cases := []struct {
+		cluster           string
+		success           bool
+		certificateExpiry time.Time
+	}{
+		{"c1", true, expiry},
+		{"c2", false, notReady.Certificate().NotAfter},
+		{"c3", false, expiry},
+		{"c4", false, time.Time{}},
+	}
+
+	if len(f.results) != len(cases) {
+		t.Fatalf("expected %d probe results, got %d", len(cases), len(f.results))
+	}
+	for _, c := range cases {
+		r, ok := f.results["ns1/"+c.cluster]
+		if !ok {
+			t.Errorf("expected probe result for cluster %s", c.cluster)
+			continue
+		}
+		if r.success != c.success {
+			t.Errorf("expected success %t for cluster %s, got %t", c.success, c.cluster, r.success)
+		}
+		if !r.certificateExpiry.Equal(c.certificateExpiry) {
+			t.Errorf("expected certificate expiry %s for cluster %s, got %s", c.certificateExpiry, c.cluster, r.certificateExpiry)
+		}
+	}
+
+	f.results = map[string]*controlPlaneProbeResult{
+		"ns1/c1": {namespace: "ns1", name: "c1", uid: "c1", success: true, duration: 1500 * time.Millisecond, certificateExpiry: time.Unix(1900000000, 0)},
+		"ns1/c2": {namespace: "ns1", name: "c2", uid: "c2", duration: 5 * time.Second},
+	}
+	tc := generateMetricsTestCase{
+		Obj: Objects{},
+		Want: `
+			# HELP capi_cluster_control_plane_certificate_expiry Unix timestamp when the serving certificate of the control plane endpoint of a cluster expires.
+			# HELP capi_cluster_control_plane_probe_duration_seconds Duration of the last probe of the /readyz endpoint of the control plane of a cluster.
+			# HELP capi_cluster_control_plane_probe_success The last probe of the /readyz endpoint of the control plane of a cluster was successful.
+			# TYPE capi_cluster_control_plane_certificate_expiry gauge
+			# TYPE capi_cluster_control_plane_probe_duration_seconds gauge
+			# TYPE capi_cluster_control_plane_probe_success gauge
+			capi_cluster_control_plane_certificate_expiry{cluster="c1",namespace="ns1",uid="c1"} 1.9e+09
+			capi_cluster_control_plane_probe_duration_seconds{cluster="c1",namespace="ns1",uid="c1"} 1.5
+			capi_cluster_control_plane_probe_duration_seconds{cluster="c2",namespace="ns1",uid="c2"} 5
+			capi_cluster_control_plane_probe_success{cluster="c1",namespace="ns1",uid="c1"} 1
+			capi_cluster_control_plane_probe_success{cluster="c2",namespace="ns1",uid="c2"} 0
+		`,
+		Func:    generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil)),
+		Headers: generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil)),
+	}
+	if err := tc.run(); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+)
+
+// CrossResourceFactory is a registry interface for metric families which are
+// generated at scrape time from the cached objects of one or more resources.
+// The GenerateFunc of each metric family generator gets passed the Objects
+// of all required resources.
+type CrossResourceFactory interface {
+	// Name returns the name of the cross resource metrics.
+	Name() string
+
+	// Resources returns the names of the resources whose objects are required
+	// to generate the metric families. The metric families are only exposed
+	// when all of these resources are enabled.
+	Resources() []string
+
+	// MetricFamilyGenerators returns the metric family generators to generate
+	// metric families with the Objects of the required resources.
+	MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator
+}
+
+// CrossResourceRunner is implemented by cross resource factories which need to
+// collect additional data in the background, e.g. from the workload clusters.
+// Run is started when the metrics writers are built and has to return when
+// the context is done.
+type CrossResourceRunner interface {
+	Run(ctx context.Context, objects Objects)
+}
+
+// CrossResourceObserver is implemented by cross resource factories which need to
+// track the changes of the objects of their resources, e.g. when an object last
+// changed. The methods are called by the object stores when the objects are
+// added, updated, replaced or deleted and must not block.
+type CrossResourceObserver interface {
+	ObjectUpdated(resource string, obj interface{})
+	ObjectDeleted(resource string, obj interface{})
+}
+
+// Objects holds the object stores per resource name.
+type Objects map[string][]cache.Store
+
+// List returns all cached objects of the given resource.
+func (o Objects) List(resource string) []interface{} {
+	objs := []interface{}{}
+	for _, s := range o[resource] {
+		objs = append(objs, s.List()...)
+	}
+	return objs
+}
+
+// HasSynced returns true if the stores of all given resources were populated
+// by their initial list. Stores which do not track this are considered as
+// synced.
+func (o Objects) HasSynced(resources ...string) bool {
+	for _, r := range resources {
+		for _, s := range o[r] {
+			if s, ok := s.(interface{ HasSynced() bool }); ok && !s.HasSynced() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// syncingStore is a store which records whether it was populated by the
+// initial list of its reflector, which replaces all objects.
+type syncingStore struct {
+	cache.Store
+	synced int32
+}
+
+func newSyncingStore() *syncingStore {
+	return &syncingStore{Store: cache.NewStore(cache.MetaNamespaceKeyFunc)}
+}
+
+func (s *syncingStore) Replace(list []interface{}, resourceVersion string) error {
+	if err := s.Store.Replace(list, resourceVersion); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&s.synced, 1)
+	return nil
+}
+
+// HasSynced returns true if the objects were replaced at least once.
+func (s *syncingStore) HasSynced() bool {
+	return atomic.LoadInt32(&s.synced) == 1
+}
+
+// objectCachingStore passes all changes to the embedded store and additionally
+// keeps the objects, so they can be used by cross resource factories. The
+// observers are notified about the changes of the kept objects.
+type objectCachingStore struct {
+	cache.Store
+	objects   cache.Store
+	resource  string
+	observers []CrossResourceObserver
+}
+
+func (s *objectCachingStore) Add(obj interface{}) error {
+	if err := s.objects.Add(obj); err != nil {
+		return err
+	}
+	s.updated(obj)
+	return s.Store.Add(obj)
+}
+
+func (s *objectCachingStore) Update(obj interface{}) error {
+	if err := s.objects.Update(obj); err != nil {
+		return err
+	}
+	s.updated(obj)
+	return s.Store.Update(obj)
+}
+
+func (s *objectCachingStore) Delete(obj interface{}) error {
+	if err := s.objects.Delete(obj); err != nil {
+		return err
+	}
+	s.deleted(obj)
+	return s.Store.Delete(obj)
+}
+
+func (s *objectCachingStore) Replace(list []interface{}, resourceVersion string) error {
+	previous := s.objects.List()
+	if err := s.objects.Replace(list, resourceVersion); err != nil {
+		return err
+	}
+	for _, obj := range previous {
+		if _, exists, _ := s.objects.Get(obj); !exists {
+			s.deleted(obj)
+		}
+	}
+	for _, obj := range list {
+		s.updated(obj)
+	}
+	return s.Store.Replace(list, resourceVersion)
+}
+
+func (s *objectCachingStore) updated(obj interface{}) {
+	for _, o := range s.observers {
+		o.ObjectUpdated(s.resource, obj)
+	}
+}
+
+func (s *objectCachingStore) deleted(obj interface{}) {
+	for _, o := range s.observers {
+		o.ObjectDeleted(s.resource, obj)
+	}
+}
+
+// crossResourceMetricsWriter writes the metric families of a cross resource
+// factory. In contrast to the MetricsStore the metrics are generated on every
+// write, so metrics which depend on the current time stay up to date.
+type crossResourceMetricsWriter struct {
+	name                string
+	resources           []string
+	headers             []string
+	generateMetricsFunc func(interface{}) []metric.FamilyInterface
+	objects             Objects
+}
+
+func newCrossResourceMetricsWriter(name string, resources []string, metricFamilies []generator.FamilyGenerator, objects Objects) *crossResourceMetricsWriter {
+	return &crossResourceMetricsWriter{
+		name:                name,
+		resources:           resources,
+		headers:             generator.ExtractMetricFamilyHeaders(metricFamilies),
+		generateMetricsFunc: generator.ComposeMetricGenFuncs(metricFamilies),
+		objects:             objects,
+	}
+}
+
+// WriteAll generates the metric families and writes them into the given
+// writer, zipped with the help text of each metric family.
+func (w *crossResourceMetricsWriter) WriteAll(writer io.Writer) {
+	w.WriteFiltered(writer, MetricsFilter{})
+}
+
+// WriteFiltered generates the metric families and writes the metrics whose
+// namespace and cluster labels match the filter into the given writer.
+func (w *crossResourceMetricsWriter) WriteFiltered(writer io.Writer, filter MetricsFilter) {
+	families := w.generateMetricsFunc(w.objects)
+	for i, help := range w.headers {
+		writer.Write([]byte(help))
+		writer.Write([]byte{'\n'})
+		writer.Write(filter.filterFamily(families[i], w.resources).ByteSlice())
+	}
+}
+
+// Namespaces returns the namespaces of the objects the metric families are
+// generated from.
+func (w *crossResourceMetricsWriter) Namespaces() []string {
+	namespaces := stringSet{}
+	for _, r := range w.resources {
+		namespaces.addNamespaces(w.objects.List(r))
+	}
+	return namespaces.list()
+}
+
+// Resources returns the resources the metric families are generated from.
+func (w *crossResourceMetricsWriter) Resources() []string {
+	return w.resources
+}
+
+// multiCrossResourceMetricsWriter writes the metric families of the same cross
+// resource factory of multiple management clusters. Like the kube-state-metrics
+// MultiStoreMetricsWriter it groups the metrics of each metric family and only
+// writes the help text once.
+type multiCrossResourceMetricsWriter struct {
+	writers []*crossResourceMetricsWriter
+}
+
+func (m *multiCrossResourceMetricsWriter) WriteAll(writer io.Writer) {
+	m.WriteFiltered(writer, MetricsFilter{})
+}
+
+func (m *multiCrossResourceMetricsWriter) WriteFiltered(writer io.Writer, filter MetricsFilter) {
+	if len(m.writers) == 0 {
+		return
+	}
+
+	resources := m.writers[0].resources
+	families := make([][]metric.FamilyInterface, 0, len(m.writers))
+	for _, w := range m.writers {
+		families = append(families, w.generateMetricsFunc(w.objects))
+	}
+
+	for i, help := range m.writers[0].headers {
+		writer.Write([]byte(help))
+		writer.Write([]byte{'\n'})
+		for _, f := range families {
+			writer.Write(filter.filterFamily(f[i], resources).ByteSlice())
+		}
+	}
+}
+
+func (m *multiCrossResourceMetricsWriter) Namespaces() []string {
+	namespaces := stringSet{}
+	for _, w := range m.writers {
+		namespaces.add(w.Namespaces())
+	}
+	return namespaces.list()
+}
+
+func (m *multiCrossResourceMetricsWriter) Resources() []string {
+	resources := stringSet{}
+	for _, w := range m.writers {
+		resources.add(w.Resources())
+	}
+	return resources.list()
+}
+
+func wrapObjectsFunc(f func(Objects) *metric.Family) func(interface{}) *metric.Family {
+	return func(obj interface{}) (metricFamily *metric.Family) {
+		defer recoverFamily(&metricFamily, "cross resource objects", nil)
+
+		return f(obj.(Objects))
+	}
+}
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+// newObjects returns Objects which contain the given objects per resource.
+func newObjects(objs map[string][]interface{}) Objects {
+	o := Objects{}
+	for resource, list := range objs {
+		s := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		for _, obj := range list {
+			if err := s.Add(obj); err != nil {
+				panic(err)
+			}
+		}
+		o[resource] = []cache.Store{s}
+	}
+	return o
+}
+
+// recordingObserver records the observed changes as strings.
+type recordingObserver struct {
+	changes []string
+}
+
+func (o *recordingObserver) ObjectUpdated(resource string, obj interface{}) {
+	o.changes = append(o.changes, "updated "+resource+"/"+obj.(metav1.Object).GetName())
+}
+
+func (o *recordingObserver) ObjectDeleted(resource string, obj interface{}) {
+	o.changes = append(o.changes, "deleted "+resource+"/"+obj.(metav1.Object).GetName())
+}
+
+func TestObjectCachingStore(t *testing.T) {
+	metricsStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	objects := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	observer := &recordingObserver{}
+	s := &objectCachingStore{Store: metricsStore, objects: objects, resource: "clusters", observers: []CrossResourceObserver{observer}}
+
+	c1 := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1", UID: types.UID("c1")}}
+	c2 := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c2", Namespace: "ns1", UID: types.UID("c2")}}
+
+	if err := s.Replace([]interface{}{c1, c2}, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(c1); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]cache.Store{"metrics store": metricsStore, "object store": objects} {
+		if keys := store.ListKeys(); len(keys) != 1 || keys[0] != "ns1/c2" {
+			t.Errorf("expected %s to only contain ns1/c2, got %v", name, keys)
+		}
+	}
+
+	c3 := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c3", Namespace: "ns1", UID: types.UID("c3")}}
+	if err := s.Replace([]interface{}{c3}, "2"); err != nil {
+		t.Fatal(err)
+	}
+	want := "updated clusters/c1,updated clusters/c2,deleted clusters/c1,deleted clusters/c2,updated clusters/c3"
+	if got := strings.Join(observer.changes, ","); got != want {
+		t.Errorf("expected the changes %s, got %s", want, got)
+	}
+}
+
+func TestCrossResourceMetricsWriter(t *testing.T) {
+	families := []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_test_clusters",
+			"Number of clusters.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				return &metric.Family{
+					Metrics: []*metric.Metric{
+						{
+							Value: float64(len(o.List("clusters"))),
+						},
+					},
+				}
+			}),
+		),
+	}
+
+	objects := newObjects(map[string][]interface{}{
+		"clusters": {
+			&clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1"}},
+		},
+	})
+	w := newCrossResourceMetricsWriter("test", []string{"clusters"}, families, objects)
+
+	want := "# HELP capi_test_clusters Number of clusters.\n# TYPE capi_test_clusters gauge\ncapi_test_clusters 1\n"
+
+	buf := &bytes.Buffer{}
+	w.WriteAll(buf)
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output, want:\n%s\ngot:\n%s", want, got)
+	}
+
+	// the metrics are generated on every write
+	if err := objects["clusters"][0].Add(&clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c2", Namespace: "ns1"}}); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	w.WriteAll(buf)
+	if got := buf.String(); got != want[:len(want)-2]+"2\n" {
+		t.Errorf("unexpected output after adding an object, got:\n%s", got)
+	}
+}
@@ -0,0 +1,291 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+var exporterMetricsLabels = []string{"management_cluster", "resource"}
+
+// ExporterMetrics holds the self metrics of the stores, like the metrics of
+// their list and watch requests. They are exposed on the telemetry port.
+type ExporterMetrics struct {
+	ListTotal     *prometheus.CounterVec
+	ListDuration  *prometheus.HistogramVec
+	WatchTotal    *prometheus.CounterVec
+	WatchRestarts *prometheus.CounterVec
+	LastSync      *prometheus.GaugeVec
+	CachedObjects *prometheus.GaugeVec
+	LastEvent     *prometheus.GaugeVec
+	StoreObjects  *prometheus.GaugeVec
+	// GenerationErrors is labeled by the metric instead of the resource, as
+	// cross resource metrics are generated from several resources.
+	GenerationErrors *prometheus.CounterVec
+}
+
+// NewExporterMetrics registers the self metrics of the stores with the
+// registerer.
+func NewExporterMetrics(r prometheus.Registerer) *ExporterMetrics {
+	return &ExporterMetrics{
+		ListTotal: promauto.With(r).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "capi_exporter_list_total",
+				Help: "Number of list requests of a resource by result.",
+			},
+			append(exporterMetricsLabels, "result"),
+		),
+		ListDuration: promauto.With(r).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "capi_exporter_list_duration_seconds",
+				Help:    "Duration of the list requests of a resource. Lists in chunks are measured per request.",
+				Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+			},
+			exporterMetricsLabels,
+		),
+		WatchTotal: promauto.With(r).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "capi_exporter_watch_total",
+				Help: "Number of watch requests of a resource by result.",
+			},
+			append(exporterMetricsLabels, "result"),
+		),
+		WatchRestarts: promauto.With(r).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "capi_exporter_watch_restarts_total",
+				Help: "Number of watch requests of a resource which followed an ended or failed watch.",
+			},
+			exporterMetricsLabels,
+		),
+		LastSync: promauto.With(r).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "capi_exporter_last_sync_timestamp_seconds",
+				Help: "Unix timestamp of the last completed list or received watch event of a resource, including bookmarks.",
+			},
+			exporterMetricsLabels,
+		),
+		CachedObjects: promauto.With(r).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "capi_exporter_cached_objects",
+				Help: "Number of cached objects of a resource.",
+			},
+			exporterMetricsLabels,
+		),
+		LastEvent: promauto.With(r).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "capi_exporter_store_last_event_timestamp",
+				Help: "Unix timestamp of the last add, update, delete or replace of the objects in a store of a resource.",
+			},
+			exporterMetricsLabels,
+		),
+		StoreObjects: promauto.With(r).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "capi_exporter_store_objects",
+				Help: "Number of objects of a resource whose metrics are exposed.",
+			},
+			exporterMetricsLabels,
+		),
+		GenerationErrors: promauto.With(r).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "capi_exporter_generation_errors_total",
+				Help: "Number of objects whose metric family could not be generated and was skipped.",
+			},
+			[]string{"management_cluster", "metric"},
+		),
+	}
+}
+
+// instrumentedListWatch records the list and watch metrics of a single
+// reflector. Its cached objects are subtracted from the metric when the context
+// is done, as the reflector stops then.
+type instrumentedListWatch struct {
+	lw      cache.ListerWatcher
+	metrics *ExporterMetrics
+	labels  []string
+	now     func() time.Time
+
+	mtx     sync.Mutex
+	stopped bool
+	// objects is the number of cached objects of the reflector.
+	objects int
+	// listedObjects counts the objects of a list in chunks until it is
+	// complete.
+	listedObjects int
+	watched       bool
+}
+
+// newInstrumentedListWatch returns a ListerWatcher which records the metrics
+// of the resource.
+func newInstrumentedListWatch(ctx context.Context, lw cache.ListerWatcher, metrics *ExporterMetrics, managementCluster, resource string) cache.ListerWatcher {
+	i := &instrumentedListWatch{
+		lw:      lw,
+		metrics: metrics,
+		labels:  []string{managementCluster, resource},
+		now:     time.Now,
+	}
+	go func() {
+		<-ctx.Done()
+		i.setObjects(0)
+		i.mtx.Lock()
+		i.stopped = true
+		i.mtx.Unlock()
+	}()
+	return i
+}
+
+func (i *instrumentedListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	start := i.now()
+	list, err := i.lw.List(options)
+	i.metrics.ListDuration.WithLabelValues(i.labels...).Observe(i.now().Sub(start).Seconds())
+	if err != nil {
+		i.metrics.ListTotal.WithLabelValues(append(i.labels, "error")...).Inc()
+		return nil, err
+	}
+	i.metrics.ListTotal.WithLabelValues(append(i.labels, "success")...).Inc()
+
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return list, nil
+	}
+
+	i.mtx.Lock()
+	if options.Continue == "" {
+		i.listedObjects = 0
+	}
+	i.listedObjects += meta.LenList(list)
+	listedObjects := i.listedObjects
+	i.mtx.Unlock()
+
+	if listMeta.GetContinue() == "" {
+		i.setObjects(listedObjects)
+		i.metrics.LastSync.WithLabelValues(i.labels...).Set(float64(i.now().Unix()))
+	}
+	return list, nil
+}
+
+func (i *instrumentedListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	i.mtx.Lock()
+	if i.watched {
+		i.metrics.WatchRestarts.WithLabelValues(i.labels...).Inc()
+	}
+	i.watched = true
+	i.mtx.Unlock()
+
+	w, err := i.lw.Watch(options)
+	if err != nil {
+		i.metrics.WatchTotal.WithLabelValues(append(i.labels, "error")...).Inc()
+		return nil, err
+	}
+	i.metrics.WatchTotal.WithLabelValues(append(i.labels, "success")...).Inc()
+
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		switch in.Type {
+		case watch.Error:
+			return in, true
+		case watch.Added:
+			i.addObjects(1)
+		case watch.Deleted:
+			i.addObjects(-1)
+		}
+		i.metrics.LastSync.WithLabelValues(i.labels...).Set(float64(i.now().Unix()))
+		return in, true
+	}), nil
+}
+
+// setObjects sets the number of cached objects of the reflector.
+func (i *instrumentedListWatch) setObjects(n int) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	if i.stopped {
+		return
+	}
+	i.metrics.CachedObjects.WithLabelValues(i.labels...).Add(float64(n - i.objects))
+	i.objects = n
+}
+
+func (i *instrumentedListWatch) addObjects(n int) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	if i.stopped {
+		return
+	}
+	i.metrics.CachedObjects.WithLabelValues(i.labels...).Add(float64(n))
+	i.objects += n
+}
+
+// instrumentedStore records the events of the reflector of a metrics store and
+// the number of its objects. Its objects are subtracted from the metric when
+// the context is done, as the reflector stops then.
+type instrumentedStore struct {
+	cache.Store
+	metricsStore *MetricsStore
+	metrics      *ExporterMetrics
+	labels       []string
+	now          func() time.Time
+
+	mtx     sync.Mutex
+	stopped bool
+	objects int
+}
+
+// newInstrumentedStore returns a store which records the metrics of the
+// metrics store, which is part of the given store.
+func newInstrumentedStore(ctx context.Context, store cache.Store, metricsStore *MetricsStore, metrics *ExporterMetrics, managementCluster, resource string) cache.Store {
+	s := &instrumentedStore{
+		Store:        store,
+		metricsStore: metricsStore,
+		metrics:      metrics,
+		labels:       []string{managementCluster, resource},
+		now:          time.Now,
+	}
+	go func() {
+		<-ctx.Done()
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.metrics.StoreObjects.WithLabelValues(s.labels...).Sub(float64(s.objects))
+		s.stopped = true
+	}()
+	return s
+}
+
+func (s *instrumentedStore) Add(obj interface{}) error {
+	defer s.record()
+	return s.Store.Add(obj)
+}
+
+func (s *instrumentedStore) Update(obj interface{}) error {
+	defer s.record()
+	return s.Store.Update(obj)
+}
+
+func (s *instrumentedStore) Delete(obj interface{}) error {
+	defer s.record()
+	return s.Store.Delete(obj)
+}
+
+func (s *instrumentedStore) Replace(list []interface{}, resourceVersion string) error {
+	defer s.record()
+	return s.Store.Replace(list, resourceVersion)
+}
+
+func (s *instrumentedStore) record() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.stopped {
+		return
+	}
+	s.metrics.LastEvent.WithLabelValues(s.labels...).Set(float64(s.now().Unix()))
+	objects := s.metricsStore.Len()
+	s.metrics.StoreObjects.WithLabelValues(s.labels...).Add(float64(objects - s.objects))
+	s.objects = objects
+}
@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestInstrumentedListWatch(t *testing.T) {
+	newMachine := func(name string) *clusterv1.Machine {
+		return &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1", UID: types.UID(name)}}
+	}
+
+	fake := watch.NewFake()
+	failList := false
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			if failList {
+				return nil, fmt.Errorf("the server could not find the requested resource")
+			}
+			if opts.Continue == "" {
+				return &clusterv1.MachineList{
+					ListMeta: metav1.ListMeta{Continue: "m2"},
+					Items:    []clusterv1.Machine{*newMachine("m1"), *newMachine("m2")},
+				}, nil
+			}
+			return &clusterv1.MachineList{Items: []clusterv1.Machine{*newMachine("m3")}}, nil
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			return fake, nil
+		},
+	}
+
+	metrics := NewExporterMetrics(prometheus.NewRegistry())
+	ctx, cancel := context.WithCancel(context.Background())
+	i := newInstrumentedListWatch(ctx, lw, metrics, "mgmt", "machines").(*instrumentedListWatch)
+	i.now = func() time.Time { return time.Unix(1501569018, 0) }
+
+	if _, err := i.List(metav1.ListOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.CachedObjects.WithLabelValues("mgmt", "machines")); got != 0 {
+		t.Errorf("expected no cached objects before the list is complete, got %v", got)
+	}
+	if _, err := i.List(metav1.ListOptions{Continue: "m2"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	failList = true
+	if _, err := i.List(metav1.ListOptions{}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	for n := 0; n < 2; n++ {
+		w, err := i.Watch(metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n == 0 {
+			go func() {
+				fake.Add(newMachine("m4"))
+				fake.Delete(newMachine("m1"))
+				fake.Delete(newMachine("m2"))
+			}()
+			for e := 0; e < 3; e++ {
+				<-w.ResultChan()
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		collector prometheus.Collector
+		want      float64
+	}{
+		{name: "successful lists", collector: metrics.ListTotal.WithLabelValues("mgmt", "machines", "success"), want: 2},
+		{name: "failed lists", collector: metrics.ListTotal.WithLabelValues("mgmt", "machines", "error"), want: 1},
+		{name: "watches", collector: metrics.WatchTotal.WithLabelValues("mgmt", "machines", "success"), want: 2},
+		{name: "watch restarts", collector: metrics.WatchRestarts.WithLabelValues("mgmt", "machines"), want: 1},
+		{name: "last sync", collector: metrics.LastSync.WithLabelValues("mgmt", "machines"), want: 1501569018},
+		{name: "cached objects", collector: metrics.CachedObjects.WithLabelValues("mgmt", "machines"), want: 2},
+	} {
+		if got := testutil.ToFloat64(tc.collector); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+	if got := testutil.CollectAndCount(metrics.ListDuration); got != 1 {
+		t.Errorf("expected one list duration histogram, got %d", got)
+	}
+
+	cancel()
+	err := wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		return testutil.ToFloat64(metrics.CachedObjects.WithLabelValues("mgmt", "machines")) == 0, nil
+	})
+	if err != nil {
+		t.Errorf("expected no cached objects after the reflector stopped")
+	}
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	newMachine := func(name string) *clusterv1.Machine {
+		return &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1", UID: types.UID(name)}}
+	}
+
+	metrics := NewExporterMetrics(prometheus.NewRegistry())
+	ctx, cancel := context.WithCancel(context.Background())
+	metricsStore := NewMetricsStore(nil, func(interface{}) []metric.FamilyInterface { return nil })
+	s := newInstrumentedStore(ctx, metricsStore, metricsStore, metrics, "", "machines").(*instrumentedStore)
+	s.now = func() time.Time { return time.Unix(1501569018, 0) }
+
+	if err := s.Replace([]interface{}{newMachine("m1"), newMachine("m2")}, "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Add(newMachine("m3")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Delete(newMachine("m1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.StoreObjects.WithLabelValues("", "machines")); got != 2 {
+		t.Errorf("expected 2 objects, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.LastEvent.WithLabelValues("", "machines")); got != 1501569018 {
+		t.Errorf("expected the last event at 1501569018, got %v", got)
+	}
+
+	cancel()
+	err := wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		return testutil.ToFloat64(metrics.StoreObjects.WithLabelValues("", "machines")) == 0, nil
+	})
+	if err != nil {
+		t.Errorf("expected no objects after the reflector stopped")
+	}
+}
@@ -3,8 +3,13 @@
 package store
 
 import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/kube-state-metrics/v2/pkg/customresource"
 	"k8s.io/kube-state-metrics/v2/pkg/options"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
@@ -15,6 +20,7 @@ import (
 var scheme = runtime.NewScheme()
 
 func init() {
+	_ = corev1.AddToScheme(scheme)
 	_ = clusterv1.AddToScheme(scheme)
 	_ = controlplanev1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
@@ -22,6 +28,32 @@ func init() {
 
 type ControllerRuntimeClientFactory struct{}
 
+// ContextListWatcher is implemented by factories whose list and watch requests
+// are cancelled with the given context, e.g. when the stores are rebuilt or
+// the process shuts down.
+type ContextListWatcher interface {
+	ListWatchWithContext(ctx context.Context, customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher
+}
+
+// ObjectTransformer is implemented by factories which strip the fields of their
+// objects that are not read by any metric, so they are not kept in memory.
+// TransformObject is called with every listed and watched object before it is
+// stored and modifies the object in place.
+type ObjectTransformer interface {
+	TransformObject(obj interface{})
+}
+
+// stripObjectMeta removes the managed fields and the annotations which are not
+// read by any metric. The annotations may hold a copy of the whole object, like
+// the last applied configuration of kubectl or the conversion data of cluster
+// api.
+func stripObjectMeta(o metav1.Object) {
+	o.SetManagedFields(nil)
+	annotations := o.GetAnnotations()
+	delete(annotations, corev1.LastAppliedConfigAnnotation)
+	delete(annotations, conversionDataAnnotation)
+}
+
 func Factories() []customresource.RegistryFactory {
 	return []customresource.RegistryFactory{
 		&ClusterFactory{},
@@ -29,7 +61,34 @@ func Factories() []customresource.RegistryFactory {
 		&MachineDeploymentFactory{},
 		&MachineSetFactory{},
 		&MachineFactory{},
+		&SecretFactory{},
+	}
+}
+
+// CrossResourceFactories returns the factories for metrics which are generated
+// from the objects of one or more resources.
+func CrossResourceFactories(thresholds StuckThresholds) []CrossResourceFactory {
+	return []CrossResourceFactory{
+		NewMachineDeploymentRolloutFactory(thresholds.MachineDeploymentProgressDeadline),
+		NewMachineStuckFactory(thresholds.MachineProvisioning, thresholds.MachineDeletion),
+		&ClusterVersionSkewFactory{},
+		&ClusterFailureDomainFactory{},
+		&OrphanedObjectsFactory{},
+		NewMachineCertificatesFactory(),
+	}
+}
+
+// ResourceGroups returns the API group of the resource of each factory.
+func ResourceGroups(factories ...customresource.RegistryFactory) (map[string]string, error) {
+	groups := map[string]string{}
+	for _, f := range factories {
+		gvks, _, err := scheme.ObjectKinds(f.ExpectedType().(runtime.Object))
+		if err != nil {
+			return nil, err
+		}
+		groups[f.Name()] = gvks[0].Group
 	}
+	return groups, nil
 }
 
 func (f *ControllerRuntimeClientFactory) CreateClient(cfg *rest.Config) (interface{}, error) {
@@ -40,10 +99,18 @@ func (f *ControllerRuntimeClientFactory) CreateClient(cfg *rest.Config) (interfa
 
 var DefaultResources = options.ResourceSet{}
 
+// optInResources are the resources which are only enabled if they are listed
+// explicitly, as their objects hold sensitive data.
+var optInResources = map[string]bool{
+	"secrets": true,
+}
+
 func init() {
 	if len(DefaultResources) == 0 {
 		for _, factory := range Factories() {
-			DefaultResources[factory.Name()] = struct{}{}
+			if !optInResources[factory.Name()] {
+				DefaultResources[factory.Name()] = struct{}{}
+			}
 		}
 	}
 }
@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	goruntime "runtime"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	bootstrapv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha4"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha4"
+)
+
+// newUnreadObjectMeta returns object metadata with the fields which are not
+// read by any metric, in the size of objects applied with kubectl.
+func newUnreadObjectMeta(name string, spec interface{}) metav1.ObjectMeta {
+	lastApplied, _ := json.Marshal(spec)
+	fields := metav1.FieldsV1{Raw: []byte(`{"f:spec":{` + strings.Repeat(`"f:field":{},`, 100) + `"f:last":{}}}`)}
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "ns1",
+		UID:       types.UID(name),
+		Annotations: map[string]string{
+			corev1.LastAppliedConfigAnnotation: string(lastApplied),
+		},
+		ManagedFields: []metav1.ManagedFieldsEntry{
+			{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationApply, FieldsType: "FieldsV1", FieldsV1: &fields},
+			{Manager: "manager", Operation: metav1.ManagedFieldsOperationUpdate, FieldsType: "FieldsV1", FieldsV1: &fields},
+		},
+	}
+}
+
+func newLargeKubeadmControlPlane(name string) *controlplanev1.KubeadmControlPlane {
+	spec := controlplanev1.KubeadmControlPlaneSpec{
+		Replicas: pointer.Int32(3),
+		Version:  "v1.22.4",
+		KubeadmConfigSpec: bootstrapv1.KubeadmConfigSpec{
+			PreKubeadmCommands: []string{"systemctl restart containerd"},
+		},
+	}
+	for i := 0; i < 10; i++ {
+		spec.KubeadmConfigSpec.Files = append(spec.KubeadmConfigSpec.Files, bootstrapv1.File{
+			Path:    fmt.Sprintf("/etc/kubernetes/file-%d", i),
+			Content: strings.Repeat("x", 2048),
+		})
+	}
+	conversionData, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"kubeadmConfigSpec": spec.KubeadmConfigSpec,
+			"rolloutBefore":     map[string]interface{}{"certificatesExpiryDays": 21},
+		},
+	})
+
+	kcp := &controlplanev1.KubeadmControlPlane{
+		ObjectMeta: newUnreadObjectMeta(name, spec),
+		Spec:       spec,
+	}
+	kcp.Annotations[conversionDataAnnotation] = string(conversionData)
+	return kcp
+}
+
+func newLargeMachine(name string) *clusterv1.Machine {
+	spec := clusterv1.MachineSpec{
+		ClusterName: "cluster1",
+		Version:     pointer.String("v1.22.4"),
+		ProviderID:  pointer.String("openstack:///" + name),
+	}
+	m := &clusterv1.Machine{
+		ObjectMeta: newUnreadObjectMeta(name, spec),
+		Spec:       spec,
+	}
+	m.Annotations[machineCertificatesExpiryDateAnnotation] = "2022-01-01T00:00:00Z"
+	m.Annotations[conversionDataAnnotation] = m.Annotations[corev1.LastAppliedConfigAnnotation]
+	return m
+}
+
+func TestTransformObject(t *testing.T) {
+	kcp := newLargeKubeadmControlPlane("kcp1")
+	(&KubeadmControlPlaneFactory{}).TransformObject(kcp)
+
+	if len(kcp.ManagedFields) != 0 {
+		t.Errorf("expected no managed fields, got %d", len(kcp.ManagedFields))
+	}
+	if _, ok := kcp.Annotations[corev1.LastAppliedConfigAnnotation]; ok {
+		t.Error("expected no last applied configuration")
+	}
+	if len(kcp.Spec.KubeadmConfigSpec.Files) != 0 {
+		t.Errorf("expected no kubeadm config files, got %d", len(kcp.Spec.KubeadmConfigSpec.Files))
+	}
+	if got, want := kcp.Annotations[conversionDataAnnotation], `{"spec":{"rolloutBefore":{"certificatesExpiryDays":21}}}`; got != want {
+		t.Errorf("expected conversion data %s, got %s", want, got)
+	}
+	if *kcp.Spec.Replicas != 3 || kcp.Spec.Version != "v1.22.4" {
+		t.Errorf("expected the replicas and version to be kept, got %d and %s", *kcp.Spec.Replicas, kcp.Spec.Version)
+	}
+
+	kcp = newLargeKubeadmControlPlane("kcp2")
+	kcp.Annotations[conversionDataAnnotation] = `{"spec":{"replicas":3}}`
+	(&KubeadmControlPlaneFactory{}).TransformObject(kcp)
+	if _, ok := kcp.Annotations[conversionDataAnnotation]; ok {
+		t.Error("expected no conversion data without certificates expiry days")
+	}
+
+	m := newLargeMachine("m1")
+	(&MachineFactory{}).TransformObject(m)
+	if len(m.Annotations) != 1 || m.Annotations[machineCertificatesExpiryDateAnnotation] == "" {
+		t.Errorf("expected only the certificates expiry annotation, got %v", m.Annotations)
+	}
+
+	s := &corev1.Secret{
+		Data: map[string][]byte{
+			"tls.crt": []byte("crt"),
+			"tls.key": []byte("key"),
+		},
+	}
+	(&SecretFactory{}).TransformObject(s)
+	if len(s.Data) != 1 || string(s.Data["tls.crt"]) != "crt" {
+		t.Errorf("expected only the certificate, got %v", s.Data)
+	}
+}
+
+func TestTransformingListWatch(t *testing.T) {
+	lw := newTransformingListWatch(&cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			return &clusterv1.MachineList{Items: []clusterv1.Machine{*newLargeMachine("m1")}}, nil
+		},
+	}, (&MachineFactory{}).TransformObject)
+
+	list, err := lw.List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m := list.(*clusterv1.MachineList).Items[0]; len(m.ManagedFields) != 0 {
+		t.Errorf("expected the listed machine to be transformed, got %d managed fields", len(m.ManagedFields))
+	}
+}
+
+// BenchmarkTransformObject reports the heap which is retained by the stored
+// objects with and without the transforms.
+func BenchmarkTransformObject(b *testing.B) {
+	const objects = 100
+
+	for _, tc := range []struct {
+		name      string
+		newObject func(name string) interface{}
+		factory   ObjectTransformer
+	}{
+		{
+			name:      "kubeadmcontrolplanes",
+			newObject: func(name string) interface{} { return newLargeKubeadmControlPlane(name) },
+			factory:   &KubeadmControlPlaneFactory{},
+		},
+		{
+			name:      "machines",
+			newObject: func(name string) interface{} { return newLargeMachine(name) },
+			factory:   &MachineFactory{},
+		},
+	} {
+		for _, transform := range []bool{false, true} {
+			name := tc.name + "/raw"
+			if transform {
+				name = tc.name + "/transformed"
+			}
+			b.Run(name, func(b *testing.B) {
+				var retained int64
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					before := heapAlloc()
+					store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+					objs := make([]interface{}, 0, objects)
+					for j := 0; j < objects; j++ {
+						objs = append(objs, tc.newObject(fmt.Sprintf("object-%d", j)))
+					}
+					b.StartTimer()
+
+					for _, obj := range objs {
+						if transform {
+							tc.factory.TransformObject(obj)
+						}
+						if err := store.Add(obj); err != nil {
+							b.Fatal(err)
+						}
+					}
+
+					b.StopTimer()
+					objs = nil
+					retained += heapAlloc() - before
+					goruntime.KeepAlive(store)
+					b.StartTimer()
+				}
+				b.ReportMetric(float64(retained)/float64(b.N*objects), "retained-B/object")
+			})
+		}
+	}
+}
+
+// heapAlloc returns the allocated heap after a garbage collection.
+func heapAlloc() int64 {
+	goruntime.GC()
+	var stats goruntime.MemStats
+	goruntime.ReadMemStats(&stats)
+	return int64(stats.HeapAlloc)
+}
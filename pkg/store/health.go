@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Health tracks whether the reflectors of the stores completed their initial
+// list, since when their list or watch requests fail and when they last
+// succeeded. Reflectors are tracked until the context of their Builder is done,
+// so the stores of the last Build are considered.
+type Health struct {
+	mtx        sync.Mutex
+	reflectors map[*reflectorHealth]struct{}
+	now        func() time.Time
+}
+
+type reflectorHealth struct {
+	resource string
+	synced   bool
+	// failingSince is the time of the first failed request after the last
+	// successful one. It is zero if the last request succeeded.
+	failingSince time.Time
+	// lastSuccess is the time of the last successful request or received
+	// watch event, including bookmarks. It is initially the time the
+	// reflector is tracked.
+	lastSuccess time.Time
+}
+
+// NewHealth returns a new Health without reflectors.
+func NewHealth() *Health {
+	return &Health{
+		reflectors: map[*reflectorHealth]struct{}{},
+		now:        time.Now,
+	}
+}
+
+// Ready returns an error if no reflector is tracked or a reflector did not
+// complete its initial list yet.
+func (h *Health) Ready() error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if len(h.reflectors) == 0 {
+		return fmt.Errorf("no stores are built")
+	}
+	var pending []string
+	for r := range h.reflectors {
+		if !r.synced {
+			pending = append(pending, r.resource)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("initial list of %s is not complete", joinUnique(pending))
+	}
+	return nil
+}
+
+// Live returns an error if the requests of a reflector fail for longer than the
+// threshold.
+func (h *Health) Live(threshold time.Duration) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	var failing []string
+	for r := range h.reflectors {
+		if !r.failingSince.IsZero() && h.now().Sub(r.failingSince) > threshold {
+			failing = append(failing, r.resource)
+		}
+	}
+	if len(failing) > 0 {
+		return fmt.Errorf("list and watch requests of %s fail for more than %s", joinUnique(failing), threshold)
+	}
+	return nil
+}
+
+// Stale returns an error if a reflector had no successful request and received
+// no watch event for longer than the threshold.
+func (h *Health) Stale(threshold time.Duration) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	var stale []string
+	for r := range h.reflectors {
+		if h.now().Sub(r.lastSuccess) > threshold {
+			stale = append(stale, r.resource)
+		}
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("stores of %s were not updated for more than %s", joinUnique(stale), threshold)
+	}
+	return nil
+}
+
+// track returns a ListerWatcher which records the health of a reflector of the
+// resource until the context is done.
+func (h *Health) track(ctx context.Context, lw cache.ListerWatcher, resource string) cache.ListerWatcher {
+	h.mtx.Lock()
+	r := &reflectorHealth{resource: resource, lastSuccess: h.now()}
+	h.reflectors[r] = struct{}{}
+	h.mtx.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mtx.Lock()
+		delete(h.reflectors, r)
+		h.mtx.Unlock()
+	}()
+
+	return &healthListWatch{lw: lw, health: h, reflector: r}
+}
+
+// update records the result of a request of the reflector.
+func (h *Health) update(r *reflectorHealth, err error, synced bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if err != nil {
+		if r.failingSince.IsZero() {
+			r.failingSince = h.now()
+		}
+		return
+	}
+	r.failingSince = time.Time{}
+	r.lastSuccess = h.now()
+	if synced {
+		r.synced = true
+	}
+}
+
+// healthListWatch records the results of the list and watch requests of a
+// reflector.
+type healthListWatch struct {
+	lw        cache.ListerWatcher
+	health    *Health
+	reflector *reflectorHealth
+}
+
+func (l *healthListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	list, err := l.lw.List(options)
+	if err != nil {
+		l.health.update(l.reflector, err, false)
+		return nil, err
+	}
+	// A list in chunks is complete with its last chunk.
+	complete := true
+	if listMeta, err := meta.ListAccessor(list); err == nil {
+		complete = listMeta.GetContinue() == ""
+	}
+	l.health.update(l.reflector, nil, complete)
+	return list, nil
+}
+
+func (l *healthListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := l.lw.Watch(options)
+	l.health.update(l.reflector, err, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		if in.Type == watch.Error {
+			l.health.update(l.reflector, fmt.Errorf("watch error: %v", in.Object), false)
+		} else {
+			l.health.update(l.reflector, nil, false)
+		}
+		return in, true
+	}), nil
+}
+
+// joinUnique returns the sorted and comma separated unique values.
+func joinUnique(values []string) string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, ", ")
+}
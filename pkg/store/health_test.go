@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestHealth(t *testing.T) {
+	now := time.Unix(1501569018, 0)
+	h := NewHealth()
+	h.now = func() time.Time { return now }
+
+	if err := h.Ready(); err == nil {
+		t.Error("expected not to be ready without stores")
+	}
+
+	var listErr, watchErr error
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			if listErr != nil {
+				return nil, listErr
+			}
+			list := &clusterv1.MachineList{}
+			if opts.Continue == "" {
+				list.Continue = "next"
+			}
+			return list, nil
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			if watchErr != nil {
+				return nil, watchErr
+			}
+			return watch.NewFake(), nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	machines := h.track(ctx, lw, "machines")
+	clusters := h.track(ctx, lw, "clusters")
+
+	if _, err := machines.List(metav1.ListOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := h.Ready(); err == nil || err.Error() != "initial list of clusters, machines is not complete" {
+		t.Errorf("expected no store to be ready before the last chunk, got %v", err)
+	}
+	if _, err := machines.List(metav1.ListOptions{Continue: "next"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := h.Ready(); err == nil || err.Error() != "initial list of clusters is not complete" {
+		t.Errorf("expected the clusters not to be ready, got %v", err)
+	}
+
+	listErr = fmt.Errorf("the server could not find the requested resource")
+	watchErr = listErr
+	if _, err := clusters.List(metav1.ListOptions{}); err == nil {
+		t.Fatal("expected an error")
+	}
+	now = now.Add(5 * time.Minute)
+	if _, err := clusters.Watch(metav1.ListOptions{}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := h.Live(10 * time.Minute); err != nil {
+		t.Errorf("expected to be live within the threshold, got %v", err)
+	}
+	now = now.Add(6 * time.Minute)
+	if err := h.Live(10 * time.Minute); err == nil {
+		t.Error("expected not to be live after the threshold")
+	}
+
+	watchErr = nil
+	if _, err := clusters.Watch(metav1.ListOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := h.Live(10 * time.Minute); err != nil {
+		t.Errorf("expected to be live after a successful watch, got %v", err)
+	}
+	if err := h.Stale(10 * time.Minute); err == nil || err.Error() != "stores of machines were not updated for more than 10m0s" {
+		t.Errorf("expected the machines to be stale, got %v", err)
+	}
+	if err := h.Stale(15 * time.Minute); err != nil {
+		t.Errorf("expected no store to be stale within the threshold, got %v", err)
+	}
+
+	cancel()
+	err := wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		return len(h.reflectors) == 0, nil
+	})
+	if err != nil {
+		t.Error("expected the reflectors not to be tracked after the context is done")
+	}
+}
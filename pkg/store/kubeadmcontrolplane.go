@@ -4,6 +4,8 @@ package store
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -12,6 +14,7 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/kube-state-metrics/v2/pkg/metric"
 	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	bootstrapv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha4"
 	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha4"
 	"sigs.k8s.io/cluster-api/util/annotations"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -19,6 +22,11 @@ import (
 
 // +kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=kubeadmcontrolplanes,verbs=get;list;watch
 
+// conversionDataAnnotation holds the object in the hub version of the api if it
+// was converted to an older version, so fields which are missing in the older
+// version are not lost.
+const conversionDataAnnotation = "cluster.x-k8s.io/conversion-data"
+
 var descKubeadmControlPlaneLabelsDefaultLabels = []string{"namespace", "kubeadmcontrolplane", "uid"}
 
 type KubeadmControlPlaneFactory struct {
@@ -180,6 +188,25 @@ func (f *KubeadmControlPlaneFactory) MetricFamilyGenerators(allowAnnotationsList
 				}
 			}),
 		),
+		*generator.NewFamilyGenerator(
+			"capi_kubeadmcontrolplane_spec_rollout_before_certificates_expiry_days",
+			"Number of days before the certificates of the machines of a kubeadmcontrolplane expire when a rollout is triggered.",
+			metric.Gauge,
+			"",
+			wrapKubeadmControlPlaneFunc(func(kcp *controlplanev1.KubeadmControlPlane) *metric.Family {
+				ms := []*metric.Metric{}
+
+				if days := getKubeadmControlPlaneCertificatesExpiryDays(kcp); days != nil {
+					ms = append(ms, &metric.Metric{
+						Value: float64(*days),
+					})
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
 		*generator.NewFamilyGenerator(
 			"capi_kubeadmcontrolplane_spec_strategy_rollingupdate_max_surge",
 			"Maximum number of replicas that can be scheduled above the desired number of replicas during a rolling update of a kubeadmcontrolplane.",
@@ -192,7 +219,7 @@ func (f *KubeadmControlPlaneFactory) MetricFamilyGenerators(allowAnnotationsList
 
 				maxSurge, err := intstr.GetScaledValueFromIntOrPercent(kcp.Spec.RolloutStrategy.RollingUpdate.MaxSurge, int(*kcp.Spec.Replicas), true)
 				if err != nil {
-					panic(err)
+					return failedFamily("kubeadmcontrolplane", kcp, fmt.Errorf("invalid max surge: %w", err))
 				}
 
 				return &metric.Family{
@@ -241,27 +268,55 @@ func (f *KubeadmControlPlaneFactory) MetricFamilyGenerators(allowAnnotationsList
 }
 
 func (f *KubeadmControlPlaneFactory) ListWatch(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+	return f.ListWatchWithContext(context.Background(), customResourceClient, ns, fieldSelector)
+}
+
+func (f *KubeadmControlPlaneFactory) ListWatchWithContext(ctx context.Context, customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
 	ctrlClient := customResourceClient.(client.WithWatch)
 	return &cache.ListWatch{
 		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
 			kubeadmControlPlaneList := controlplanev1.KubeadmControlPlaneList{}
 			opts.FieldSelector = fieldSelector
-			err := ctrlClient.List(context.TODO(), &kubeadmControlPlaneList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			err := ctrlClient.List(ctx, &kubeadmControlPlaneList, &client.ListOptions{Raw: &opts, Namespace: ns, Limit: opts.Limit, Continue: opts.Continue})
 			return &kubeadmControlPlaneList, err
 		},
 		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
 			kubeadmControlPlaneList := controlplanev1.KubeadmControlPlaneList{}
 			opts.FieldSelector = fieldSelector
-			return ctrlClient.Watch(context.TODO(), &kubeadmControlPlaneList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			return ctrlClient.Watch(ctx, &kubeadmControlPlaneList, &client.ListOptions{Raw: &opts, Namespace: ns})
 		},
 	}
 }
 
+// TransformObject removes the kubeadm config, which holds the files and
+// commands of the machines, and reduces the conversion data to the fields which
+// are read.
+func (f *KubeadmControlPlaneFactory) TransformObject(obj interface{}) {
+	kcp := obj.(*controlplanev1.KubeadmControlPlane)
+	expiryDays := getKubeadmControlPlaneCertificatesExpiryDays(kcp)
+
+	stripObjectMeta(kcp)
+	kcp.Spec.KubeadmConfigSpec = bootstrapv1.KubeadmConfigSpec{}
+
+	if expiryDays == nil {
+		return
+	}
+	data := kubeadmControlPlaneConversionData{}
+	data.Spec.RolloutBefore = &kubeadmControlPlaneRolloutBefore{CertificatesExpiryDays: expiryDays}
+	if b, err := json.Marshal(data); err == nil {
+		kcp.Annotations[conversionDataAnnotation] = string(b)
+	}
+}
+
 func wrapKubeadmControlPlaneFunc(f func(*controlplanev1.KubeadmControlPlane) *metric.Family) func(interface{}) *metric.Family {
-	return func(obj interface{}) *metric.Family {
+	return func(obj interface{}) (metricFamily *metric.Family) {
 		kubeadmControlPlane := obj.(*controlplanev1.KubeadmControlPlane)
+		defer recoverFamily(&metricFamily, "kubeadmcontrolplane", kubeadmControlPlane)
 
-		metricFamily := f(kubeadmControlPlane)
+		metricFamily = f(kubeadmControlPlane)
+		if metricFamily == nil {
+			return nil
+		}
 
 		for _, m := range metricFamily.Metrics {
 			m.LabelKeys = append(descKubeadmControlPlaneLabelsDefaultLabels, m.LabelKeys...)
@@ -271,3 +326,32 @@ func wrapKubeadmControlPlaneFunc(f func(*controlplanev1.KubeadmControlPlane) *me
 		return metricFamily
 	}
 }
+
+// kubeadmControlPlaneConversionData holds the fields of the conversion data
+// of a kubeadmcontrolplane which are read.
+type kubeadmControlPlaneConversionData struct {
+	Spec struct {
+		RolloutBefore *kubeadmControlPlaneRolloutBefore `json:"rolloutBefore,omitempty"`
+	} `json:"spec"`
+}
+
+type kubeadmControlPlaneRolloutBefore struct {
+	CertificatesExpiryDays *int32 `json:"certificatesExpiryDays,omitempty"`
+}
+
+// getKubeadmControlPlaneCertificatesExpiryDays returns the
+// Spec.RolloutBefore.CertificatesExpiryDays of the kubeadmcontrolplane. The
+// field does not exist in v1alpha4, so it is read from the conversion data
+// which is stored by cluster api when the object is read in an older version.
+func getKubeadmControlPlaneCertificatesExpiryDays(kcp *controlplanev1.KubeadmControlPlane) *int32 {
+	data, ok := kcp.Annotations[conversionDataAnnotation]
+	if !ok {
+		return nil
+	}
+
+	hub := kubeadmControlPlaneConversionData{}
+	if err := json.Unmarshal([]byte(data), &hub); err != nil || hub.Spec.RolloutBefore == nil {
+		return nil
+	}
+	return hub.Spec.RolloutBefore.CertificatesExpiryDays
+}
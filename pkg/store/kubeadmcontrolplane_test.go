@@ -5,6 +5,8 @@ package store
 import (
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -124,13 +126,67 @@ func TestKubeadmControlPlaneStore(t *testing.T) {
 			`,
 			MetricNames: []string{"capi_kubeadmcontrolplane_info"},
 		},
+		{
+			Obj: &controlplanev1.KubeadmControlPlane{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "kcp5",
+					Namespace: "ns5",
+					UID:       types.UID("foo"),
+					Annotations: map[string]string{
+						"cluster.x-k8s.io/conversion-data": `{"spec":{"rolloutBefore":{"certificatesExpiryDays":21}}}`,
+					},
+				},
+			},
+			Want: `
+				# HELP capi_kubeadmcontrolplane_spec_rollout_before_certificates_expiry_days Number of days before the certificates of the machines of a kubeadmcontrolplane expire when a rollout is triggered.
+				# TYPE capi_kubeadmcontrolplane_spec_rollout_before_certificates_expiry_days gauge
+				capi_kubeadmcontrolplane_spec_rollout_before_certificates_expiry_days{kubeadmcontrolplane="kcp5",namespace="ns5",uid="foo"} 21
+			`,
+			MetricNames: []string{"capi_kubeadmcontrolplane_spec_rollout_before_certificates_expiry_days"},
+		},
+		{
+			Obj: &controlplanev1.KubeadmControlPlane{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "kcp6",
+					Namespace:         "ns6",
+					CreationTimestamp: metav1StartTime,
+					UID:               types.UID("foo"),
+				},
+				Spec: controlplanev1.KubeadmControlPlaneSpec{
+					Replicas: pointer.Int32Ptr(3),
+					RolloutStrategy: &controlplanev1.RolloutStrategy{
+						RollingUpdate: &controlplanev1.RollingUpdate{
+							MaxSurge: &intstr.IntOrString{Type: intstr.String, StrVal: "abc%"},
+						},
+					},
+				},
+			},
+			Want: `
+				# HELP capi_kubeadmcontrolplane_spec_strategy_rollingupdate_max_surge Maximum number of replicas that can be scheduled above the desired number of replicas during a rolling update of a kubeadmcontrolplane.
+				# HELP capi_kubeadmcontrolplane_created Unix creation timestamp
+				# TYPE capi_kubeadmcontrolplane_spec_strategy_rollingupdate_max_surge gauge
+				# TYPE capi_kubeadmcontrolplane_created gauge
+				capi_kubeadmcontrolplane_created{kubeadmcontrolplane="kcp6",namespace="ns6",uid="foo"} 1.501569018e+09
+			`,
+			MetricNames: []string{"capi_kubeadmcontrolplane_spec_strategy_rollingupdate_max_surge", "capi_kubeadmcontrolplane_created"},
+			GenerationErrors: map[string]float64{
+				"capi_kubeadmcontrolplane_spec_strategy_rollingupdate_max_surge": 1,
+			},
+		},
 	}
 	for i, c := range cases {
 		f := KubeadmControlPlaneFactory{}
-		c.Func = generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil))
+		b := NewBuilder()
+		b.WithMetrics(prometheus.NewRegistry())
+		c.Func = generator.ComposeMetricGenFuncs(b.withGenerationErrors(f.MetricFamilyGenerators(nil, nil)))
 		c.Headers = generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil))
 		if err := c.run(); err != nil {
 			t.Errorf("unexpected collecting result in %vth run:\n%s", i, err)
 		}
+		for _, name := range c.MetricNames {
+			if got := testutil.ToFloat64(b.exporterMetrics.GenerationErrors.WithLabelValues("", name)); got != c.GenerationErrors[name] {
+				t.Errorf("expected %v generation errors of %s in %vth run, got %v", c.GenerationErrors[name], name, i, got)
+			}
+		}
 	}
 }
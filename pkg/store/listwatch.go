@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"hash/fnv"
+
+	jump "github.com/dgryski/go-jump"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newReflector returns a reflector which lists the objects in chunks of the
+// page size if it is not 0.
+func newReflector(lw cache.ListerWatcher, expectedType interface{}, store cache.Store, pageSize int64) *cache.Reflector {
+	if pageSize == 0 {
+		return cache.NewReflector(lw, expectedType, store, 0)
+	}
+	reflector := cache.NewReflector(&pagedListWatch{ListerWatcher: lw}, expectedType, store, 0)
+	reflector.WatchListPageSize = pageSize
+	return reflector
+}
+
+// pagedListWatch lists the objects with a consistent read instead of
+// resourceVersion=0 on the initial list. The API server ignores the limit of
+// lists with resourceVersion=0 and returns all objects of its watch cache in a
+// single response. It is still set by --use-apiserver-cache, which is applied
+// by the wrapped ListerWatcher.
+type pagedListWatch struct {
+	cache.ListerWatcher
+}
+
+func (p *pagedListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	if options.ResourceVersion == "0" && options.Continue == "" {
+		options.ResourceVersion = ""
+	}
+	return p.ListerWatcher.List(options)
+}
+
+// newShardedListWatch returns a ListerWatcher which only lists and watches the
+// objects of the shard. It follows the sharding of kube-state-metrics, but
+// keeps the continue token of lists and the bookmark events of watches, so the
+// objects can be listed in chunks and watches are resumed at the resource
+// version of the last bookmark.
+func newShardedListWatch(shard int32, totalShards int, lw cache.ListerWatcher) cache.ListerWatcher {
+	if shard == 0 && totalShards == 1 {
+		return lw
+	}
+	return &shardedListWatch{shard: shard, totalShards: totalShards, lw: lw}
+}
+
+type shardedListWatch struct {
+	shard       int32
+	totalShards int
+	lw          cache.ListerWatcher
+}
+
+func (s *shardedListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	list, err := s.lw.List(options)
+	if err != nil {
+		return nil, err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return nil, err
+	}
+	res := &metav1.List{
+		Items: []runtime.RawExtension{},
+	}
+	for _, item := range items {
+		o, err := meta.Accessor(item)
+		if err != nil {
+			return nil, err
+		}
+		if s.keep(o) {
+			res.Items = append(res.Items, runtime.RawExtension{Object: item})
+		}
+	}
+	res.ResourceVersion = listMeta.GetResourceVersion()
+	res.Continue = listMeta.GetContinue()
+	res.RemainingItemCount = listMeta.GetRemainingItemCount()
+
+	return res, nil
+}
+
+func (s *shardedListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := s.lw.Watch(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		if in.Type == watch.Bookmark || in.Type == watch.Error {
+			return in, true
+		}
+		o, err := meta.Accessor(in.Object)
+		if err != nil {
+			return in, true
+		}
+		return in, s.keep(o)
+	}), nil
+}
+
+func (s *shardedListWatch) keep(o metav1.Object) bool {
+	h := fnv.New64a()
+	h.Write([]byte(o.GetUID()))
+	return jump.Hash(h.Sum64(), s.totalShards) == s.shard
+}
+
+// newTransformingListWatch returns a ListerWatcher which transforms the listed
+// and watched objects before they are passed on.
+func newTransformingListWatch(lw cache.ListerWatcher, transform func(interface{})) cache.ListerWatcher {
+	return &transformingListWatch{lw: lw, transform: transform}
+}
+
+type transformingListWatch struct {
+	lw        cache.ListerWatcher
+	transform func(interface{})
+}
+
+func (t *transformingListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	list, err := t.lw.List(options)
+	if err != nil {
+		return nil, err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+	// The items are pointers to the elements of the list, so the list holds
+	// the transformed objects.
+	for _, item := range items {
+		t.transform(item)
+	}
+	return list, nil
+}
+
+func (t *transformingListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := t.lw.Watch(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		if in.Type != watch.Bookmark && in.Type != watch.Error {
+			t.transform(in.Object)
+		}
+		return in, true
+	}), nil
+}
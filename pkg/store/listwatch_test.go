@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestReflectorListsInChunks(t *testing.T) {
+	var machines []clusterv1.Machine
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("machine-%d", i)
+		machines = append(machines, clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1", UID: types.UID(name)},
+		})
+	}
+
+	var requests []metav1.ListOptions
+	var stopCh chan struct{}
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			requests = append(requests, opts)
+			start := 0
+			if opts.Continue != "" {
+				fmt.Sscanf(opts.Continue, "%d", &start)
+			}
+			end := start + int(opts.Limit)
+			list := &clusterv1.MachineList{ListMeta: metav1.ListMeta{ResourceVersion: "10"}}
+			if opts.Limit == 0 || end >= len(machines) {
+				end = len(machines)
+			} else {
+				list.Continue = fmt.Sprintf("%d", end)
+			}
+			list.Items = machines[start:end]
+			return list, nil
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			// The initial list is complete, stop the reflector.
+			close(stopCh)
+			return watch.NewFake(), nil
+		},
+	}
+
+	for _, tc := range []struct {
+		pageSize    int64
+		totalShards int
+		requests    int
+	}{
+		{pageSize: 0, totalShards: 1, requests: 1},
+		{pageSize: 2, totalShards: 1, requests: 3},
+		{pageSize: 2, totalShards: 2, requests: 3},
+	} {
+		requests = nil
+		var objects []interface{}
+		for shard := 0; shard < tc.totalShards; shard++ {
+			store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+			reflector := newReflector(newShardedListWatch(int32(shard), tc.totalShards, lw), &clusterv1.Machine{}, store, tc.pageSize)
+			stopCh = make(chan struct{})
+			if err := reflector.ListAndWatch(stopCh); err != nil {
+				t.Fatalf("page size %d: unexpected error: %v", tc.pageSize, err)
+			}
+			objects = append(objects, store.List()...)
+		}
+
+		if len(objects) != len(machines) {
+			t.Errorf("page size %d, %d shards: expected %d objects, got %d", tc.pageSize, tc.totalShards, len(machines), len(objects))
+		}
+		if len(requests) != tc.requests*tc.totalShards {
+			t.Errorf("page size %d, %d shards: expected %d list requests, got %d", tc.pageSize, tc.totalShards, tc.requests*tc.totalShards, len(requests))
+		}
+		if tc.pageSize != 0 && requests[0].ResourceVersion != "" {
+			t.Errorf("page size %d: expected the initial list without resource version, got %q", tc.pageSize, requests[0].ResourceVersion)
+		}
+	}
+}
+
+func TestShardedListWatchKeepsBookmarks(t *testing.T) {
+	fake := watch.NewFake()
+	lw := newShardedListWatch(0, 2, &cache.ListWatch{
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			return fake, nil
+		},
+	})
+
+	w, err := lw.Watch(metav1.ListOptions{AllowWatchBookmarks: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Stop()
+
+	go fake.Action(watch.Bookmark, &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "42"}})
+
+	event := <-w.ResultChan()
+	if event.Type != watch.Bookmark {
+		t.Fatalf("expected a bookmark event, got %s", event.Type)
+	}
+	o, err := meta.Accessor(event.Object)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.GetResourceVersion() != "42" {
+		t.Errorf("expected resource version 42, got %s", o.GetResourceVersion())
+	}
+}
@@ -4,6 +4,7 @@ package store
 
 import (
 	"context"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -18,6 +19,10 @@ import (
 
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=get;list;watch
 
+// machineCertificatesExpiryDateAnnotation is set by the kubeadmcontrolplane
+// controller on control plane machines to the expiry date of their certificates.
+const machineCertificatesExpiryDateAnnotation = "machine.cluster.x-k8s.io/certificates-expiry"
+
 var descMachineLabelsDefaultLabels = []string{"namespace", "machine", "uid"}
 
 type MachineFactory struct {
@@ -73,6 +78,27 @@ func (f *MachineFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabel
 				}
 			}),
 		),
+		*generator.NewFamilyGenerator(
+			"capi_machine_certificates_expiry",
+			"Unix timestamp when the certificates of the machine expire.",
+			metric.Gauge,
+			"",
+			wrapMachineFunc(func(m *clusterv1.Machine) *metric.Family {
+				ms := []*metric.Metric{}
+
+				if expiry, ok := getMachineCertificatesExpiry(m); ok {
+					ms = append(ms, &metric.Metric{
+						LabelKeys:   []string{},
+						LabelValues: []string{},
+						Value:       float64(expiry.Unix()),
+					})
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
 		*generator.NewFamilyGenerator(
 			"capi_machine_paused",
 			"The machine is paused and not reconciled.",
@@ -226,27 +252,39 @@ func (f *MachineFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabel
 }
 
 func (f *MachineFactory) ListWatch(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+	return f.ListWatchWithContext(context.Background(), customResourceClient, ns, fieldSelector)
+}
+
+func (f *MachineFactory) ListWatchWithContext(ctx context.Context, customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
 	ctrlClient := customResourceClient.(client.WithWatch)
 	return &cache.ListWatch{
 		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
 			machineList := clusterv1.MachineList{}
 			opts.FieldSelector = fieldSelector
-			err := ctrlClient.List(context.TODO(), &machineList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			err := ctrlClient.List(ctx, &machineList, &client.ListOptions{Raw: &opts, Namespace: ns, Limit: opts.Limit, Continue: opts.Continue})
 			return &machineList, err
 		},
 		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
 			machineList := clusterv1.MachineList{}
 			opts.FieldSelector = fieldSelector
-			return ctrlClient.Watch(context.TODO(), &machineList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			return ctrlClient.Watch(ctx, &machineList, &client.ListOptions{Raw: &opts, Namespace: ns})
 		},
 	}
 }
 
+func (f *MachineFactory) TransformObject(obj interface{}) {
+	stripObjectMeta(obj.(*clusterv1.Machine))
+}
+
 func wrapMachineFunc(f func(*clusterv1.Machine) *metric.Family) func(interface{}) *metric.Family {
-	return func(obj interface{}) *metric.Family {
+	return func(obj interface{}) (metricFamily *metric.Family) {
 		machine := obj.(*clusterv1.Machine)
+		defer recoverFamily(&metricFamily, "machine", machine)
 
-		metricFamily := f(machine)
+		metricFamily = f(machine)
+		if metricFamily == nil {
+			return nil
+		}
 
 		for _, m := range metricFamily.Metrics {
 			m.LabelKeys = append(descMachineLabelsDefaultLabels, m.LabelKeys...)
@@ -256,3 +294,17 @@ func wrapMachineFunc(f func(*clusterv1.Machine) *metric.Family) func(interface{}
 		return metricFamily
 	}
 }
+
+// getMachineCertificatesExpiry returns the expiry date of the certificates of
+// the machine from its annotation.
+func getMachineCertificatesExpiry(m *clusterv1.Machine) (time.Time, bool) {
+	value, ok := m.Annotations[machineCertificatesExpiryDateAnnotation]
+	if !ok {
+		return time.Time{}, false
+	}
+	expiry, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
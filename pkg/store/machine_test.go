@@ -170,6 +170,24 @@ func TestMachineStore(t *testing.T) {
 			`,
 			MetricNames: []string{"capi_machine_info"},
 		},
+		{
+			Obj: &clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "m5",
+					Namespace: "ns5",
+					UID:       types.UID("foo"),
+					Annotations: map[string]string{
+						"machine.cluster.x-k8s.io/certificates-expiry": "2023-08-01T06:30:18Z",
+					},
+				},
+			},
+			Want: `
+				# HELP capi_machine_certificates_expiry Unix timestamp when the certificates of the machine expire.
+				# TYPE capi_machine_certificates_expiry gauge
+				capi_machine_certificates_expiry{machine="m5",namespace="ns5",uid="foo"} 1.690871418e+09
+			`,
+			MetricNames: []string{"capi_machine_certificates_expiry"},
+		},
 	}
 	for i, c := range cases {
 		f := MachineFactory{}
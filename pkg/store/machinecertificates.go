@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"time"
+
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha4"
+)
+
+// MachineCertificatesFactory generates metrics about the certificate renewal
+// of control plane machines. A kubeadmcontrolplane rolls out machines whose
+// certificates expire within Spec.RolloutBefore.CertificatesExpiryDays.
+type MachineCertificatesFactory struct {
+	now func() time.Time
+}
+
+// NewMachineCertificatesFactory returns a new MachineCertificatesFactory.
+func NewMachineCertificatesFactory() *MachineCertificatesFactory {
+	return &MachineCertificatesFactory{
+		now: time.Now,
+	}
+}
+
+func (f *MachineCertificatesFactory) Name() string {
+	return "machinecertificates"
+}
+
+func (f *MachineCertificatesFactory) Resources() []string {
+	return []string{"kubeadmcontrolplanes", "machines"}
+}
+
+func (f *MachineCertificatesFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_machine_certificates_renewal_due",
+			"The certificates of the machine expire within the certificates expiry days of its kubeadmcontrolplane.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				expiryDays := map[string]int32{}
+				for _, obj := range o.List("kubeadmcontrolplanes") {
+					kcp := obj.(*controlplanev1.KubeadmControlPlane)
+					if days := getKubeadmControlPlaneCertificatesExpiryDays(kcp); days != nil {
+						expiryDays[kcp.Namespace+"/"+kcp.Name] = *days
+					}
+				}
+
+				ms := []*metric.Metric{}
+
+				for _, obj := range o.List("machines") {
+					m := obj.(*clusterv1.Machine)
+					expiry, ok := getMachineCertificatesExpiry(m)
+					if !ok {
+						continue
+					}
+					days, ok := expiryDays[m.Namespace+"/"+getMachineKubeadmControlPlaneName(m)]
+					if !ok {
+						continue
+					}
+					renewalDue := !f.now().Add(time.Duration(days) * 24 * time.Hour).Before(expiry)
+					ms = append(ms, &metric.Metric{
+						LabelKeys:   append(append([]string{}, descMachineLabelsDefaultLabels...), "cluster"),
+						LabelValues: []string{m.Namespace, m.Name, string(m.UID), m.Spec.ClusterName},
+						Value:       boolFloat64(renewalDue),
+					})
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+	}
+}
+
+// getMachineKubeadmControlPlaneName returns the name of the kubeadmcontrolplane
+// owning the machine or an empty string.
+func getMachineKubeadmControlPlaneName(m *clusterv1.Machine) string {
+	for _, ref := range m.OwnerReferences {
+		if ref.Kind == "KubeadmControlPlane" {
+			return ref.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha4"
+)
+
+func TestMachineCertificatesStore(t *testing.T) {
+	newKubeadmControlPlane := func(name, conversionData string) *controlplanev1.KubeadmControlPlane {
+		return &controlplanev1.KubeadmControlPlane{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns1",
+				Annotations: map[string]string{
+					"cluster.x-k8s.io/conversion-data": conversionData,
+				},
+			},
+		}
+	}
+	newMachine := func(name, kcp, expiry string) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns1",
+				UID:       types.UID(name),
+				Annotations: map[string]string{
+					"machine.cluster.x-k8s.io/certificates-expiry": expiry,
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: "KubeadmControlPlane", Name: kcp},
+				},
+			},
+			Spec: clusterv1.MachineSpec{
+				ClusterName: "c1",
+			},
+		}
+	}
+
+	f := NewMachineCertificatesFactory()
+	f.now = func() time.Time { return time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC) }
+
+	tc := generateMetricsTestCase{
+		Obj: newObjects(map[string][]interface{}{
+			"kubeadmcontrolplanes": {
+				newKubeadmControlPlane("kcp1", `{"spec":{"rolloutBefore":{"certificatesExpiryDays":21}}}`),
+				newKubeadmControlPlane("kcp2", `{"spec":{}}`),
+			},
+			"machines": {
+				newMachine("m1", "kcp1", "2023-07-15T00:00:00Z"),
+				newMachine("m2", "kcp1", "2023-08-15T00:00:00Z"),
+				newMachine("m3", "kcp1", "invalid"),
+				newMachine("m4", "kcp2", "2023-07-15T00:00:00Z"),
+			},
+		}),
+		Want: `
+			# HELP capi_machine_certificates_renewal_due The certificates of the machine expire within the certificates expiry days of its kubeadmcontrolplane.
+			# TYPE capi_machine_certificates_renewal_due gauge
+			capi_machine_certificates_renewal_due{cluster="c1",machine="m1",namespace="ns1",uid="m1"} 1
+			capi_machine_certificates_renewal_due{cluster="c1",machine="m2",namespace="ns1",uid="m2"} 0
+		`,
+		Func:    generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil)),
+		Headers: generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil)),
+	}
+	if err := tc.run(); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
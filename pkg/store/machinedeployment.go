@@ -4,6 +4,7 @@ package store
 
 import (
 	"context"
+	"fmt"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -92,6 +93,23 @@ func (f *MachineDeploymentFactory) MetricFamilyGenerators(allowAnnotationsList,
 				}
 			}),
 		),
+		*generator.NewFamilyGenerator(
+			"capi_machinedeployment_paused_rollout",
+			"The rollout of the machinedeployment is paused via its spec.",
+			metric.Gauge,
+			"",
+			wrapMachineDeploymentFunc(func(md *clusterv1.MachineDeployment) *metric.Family {
+				return &metric.Family{
+					Metrics: []*metric.Metric{
+						{
+							LabelKeys:   []string{},
+							LabelValues: []string{},
+							Value:       boolFloat64(md.Spec.Paused),
+						},
+					},
+				}
+			}),
+		),
 		*generator.NewFamilyGenerator(
 			"capi_machinedeployment_status_phase",
 			"The machinedeployments current phase.",
@@ -192,6 +210,30 @@ func (f *MachineDeploymentFactory) MetricFamilyGenerators(allowAnnotationsList,
 				}
 			}),
 		),
+		*generator.NewFamilyGenerator(
+			"capi_machinedeployment_rollout_updated_ratio",
+			"The fraction of desired replicas of a machinedeployment which are updated.",
+			metric.Gauge,
+			"",
+			wrapMachineDeploymentFunc(func(md *clusterv1.MachineDeployment) *metric.Family {
+				if md.Spec.Replicas == nil {
+					return &metric.Family{}
+				}
+
+				ratio := 1.0
+				if *md.Spec.Replicas > 0 {
+					ratio = float64(md.Status.UpdatedReplicas) / float64(*md.Spec.Replicas)
+				}
+
+				return &metric.Family{
+					Metrics: []*metric.Metric{
+						{
+							Value: ratio,
+						},
+					},
+				}
+			}),
+		),
 		*generator.NewFamilyGenerator(
 			"capi_machinedeployment_spec_replicas",
 			"Number of desired replicas for a machinedeployment.",
@@ -223,7 +265,7 @@ func (f *MachineDeploymentFactory) MetricFamilyGenerators(allowAnnotationsList,
 
 				maxSurge, err := intstr.GetScaledValueFromIntOrPercent(md.Spec.Strategy.RollingUpdate.MaxSurge, int(*md.Spec.Replicas), true)
 				if err != nil {
-					panic(err)
+					return failedFamily("machinedeployment", md, fmt.Errorf("invalid max surge: %w", err))
 				}
 
 				return &metric.Family{
@@ -241,13 +283,13 @@ func (f *MachineDeploymentFactory) MetricFamilyGenerators(allowAnnotationsList,
 			metric.Gauge,
 			"",
 			wrapMachineDeploymentFunc(func(md *clusterv1.MachineDeployment) *metric.Family {
-				if md.Spec.Strategy == nil || md.Spec.Strategy.RollingUpdate == nil {
+				if md.Spec.Strategy == nil || md.Spec.Strategy.RollingUpdate == nil || md.Spec.Replicas == nil {
 					return &metric.Family{}
 				}
 
 				maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(md.Spec.Strategy.RollingUpdate.MaxUnavailable, int(*md.Spec.Replicas), false)
 				if err != nil {
-					panic(err)
+					return failedFamily("machinedeployment", md, fmt.Errorf("invalid max unavailable: %w", err))
 				}
 
 				return &metric.Family{
@@ -268,31 +310,88 @@ func (f *MachineDeploymentFactory) MetricFamilyGenerators(allowAnnotationsList,
 				return getOwnerMetric(md.GetOwnerReferences())
 			}),
 		),
+		*generator.NewFamilyGenerator(
+			"capi_machinedeployment_info",
+			"Information about a machinedeployment.",
+			metric.Gauge,
+			"",
+			wrapMachineDeploymentFunc(func(md *clusterv1.MachineDeployment) *metric.Family {
+				labelKeys := []string{}
+				labelValues := []string{}
+
+				if md.Spec.Strategy != nil && md.Spec.Strategy.Type != "" {
+					labelKeys = append(labelKeys, "strategy")
+					labelValues = append(labelValues, string(md.Spec.Strategy.Type))
+				}
+				if md.Spec.Template.Spec.Version != nil {
+					labelKeys = append(labelKeys, "version")
+					labelValues = append(labelValues, *md.Spec.Template.Spec.Version)
+				}
+				if md.Spec.Template.Spec.FailureDomain != nil {
+					labelKeys = append(labelKeys, "failure_domain")
+					labelValues = append(labelValues, *md.Spec.Template.Spec.FailureDomain)
+				}
+				if configRef := md.Spec.Template.Spec.Bootstrap.ConfigRef; configRef != nil {
+					labelKeys = append(labelKeys, "bootstrap_config_kind", "bootstrap_config_name")
+					labelValues = append(labelValues, configRef.Kind, configRef.Name)
+				}
+				if infraRef := md.Spec.Template.Spec.InfrastructureRef; infraRef.Name != "" {
+					labelKeys = append(labelKeys, "infrastructure_kind", "infrastructure_name")
+					labelValues = append(labelValues, infraRef.Kind, infraRef.Name)
+				}
+				if revision, ok := md.Annotations[clusterv1.RevisionAnnotation]; ok {
+					labelKeys = append(labelKeys, "revision")
+					labelValues = append(labelValues, revision)
+				}
+
+				return &metric.Family{
+					Metrics: []*metric.Metric{
+						{
+							LabelKeys:   labelKeys,
+							LabelValues: labelValues,
+							Value:       1,
+						},
+					},
+				}
+			}),
+		),
 	}
 }
 
 func (f *MachineDeploymentFactory) ListWatch(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+	return f.ListWatchWithContext(context.Background(), customResourceClient, ns, fieldSelector)
+}
+
+func (f *MachineDeploymentFactory) ListWatchWithContext(ctx context.Context, customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
 	ctrlClient := customResourceClient.(client.WithWatch)
 	return &cache.ListWatch{
 		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
 			machineDeploymentList := clusterv1.MachineDeploymentList{}
 			opts.FieldSelector = fieldSelector
-			err := ctrlClient.List(context.TODO(), &machineDeploymentList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			err := ctrlClient.List(ctx, &machineDeploymentList, &client.ListOptions{Raw: &opts, Namespace: ns, Limit: opts.Limit, Continue: opts.Continue})
 			return &machineDeploymentList, err
 		},
 		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
 			machineDeploymentList := clusterv1.MachineDeploymentList{}
 			opts.FieldSelector = fieldSelector
-			return ctrlClient.Watch(context.TODO(), &machineDeploymentList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			return ctrlClient.Watch(ctx, &machineDeploymentList, &client.ListOptions{Raw: &opts, Namespace: ns})
 		},
 	}
 }
 
+func (f *MachineDeploymentFactory) TransformObject(obj interface{}) {
+	stripObjectMeta(obj.(*clusterv1.MachineDeployment))
+}
+
 func wrapMachineDeploymentFunc(f func(*clusterv1.MachineDeployment) *metric.Family) func(interface{}) *metric.Family {
-	return func(obj interface{}) *metric.Family {
+	return func(obj interface{}) (metricFamily *metric.Family) {
 		machineDeployment := obj.(*clusterv1.MachineDeployment)
+		defer recoverFamily(&metricFamily, "machinedeployment", machineDeployment)
 
-		metricFamily := f(machineDeployment)
+		metricFamily = f(machineDeployment)
+		if metricFamily == nil {
+			return nil
+		}
 
 		for _, m := range metricFamily.Metrics {
 			m.LabelKeys = append(descMachineDeploymentLabelsDefaultLabels, m.LabelKeys...)
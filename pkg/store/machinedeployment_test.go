@@ -5,6 +5,9 @@ package store
 import (
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -93,23 +96,26 @@ func TestMachineDeploymentStore(t *testing.T) {
 				},
 			},
 			Want: `
+				# HELP capi_machinedeployment_rollout_updated_ratio The fraction of desired replicas of a machinedeployment which are updated.
 				# HELP capi_machinedeployment_spec_replicas Number of desired replicas for a machinedeployment.
 				# HELP capi_machinedeployment_status_replicas The number of replicas per machinedeployment.
 				# HELP capi_machinedeployment_status_replicas_available The number of available replicas per machinedeployment.
 				# HELP capi_machinedeployment_status_replicas_unavailable The number of unavailable replicas per machinedeployment.
 				# HELP capi_machinedeployment_status_replicas_updated The number of updated replicas per machinedeployment.
+				# TYPE capi_machinedeployment_rollout_updated_ratio gauge
 				# TYPE capi_machinedeployment_spec_replicas gauge
 				# TYPE capi_machinedeployment_status_replicas gauge
 				# TYPE capi_machinedeployment_status_replicas_available gauge
 				# TYPE capi_machinedeployment_status_replicas_unavailable gauge
 				# TYPE capi_machinedeployment_status_replicas_updated gauge
+				capi_machinedeployment_rollout_updated_ratio{machinedeployment="md2",namespace="ns2",uid="foo"} 0.3333333333333333
 				capi_machinedeployment_spec_replicas{machinedeployment="md2",namespace="ns2",uid="foo"} 3
 				capi_machinedeployment_status_replicas_available{machinedeployment="md2",namespace="ns2",uid="foo"} 1
 				capi_machinedeployment_status_replicas_unavailable{machinedeployment="md2",namespace="ns2",uid="foo"} 1
 				capi_machinedeployment_status_replicas_updated{machinedeployment="md2",namespace="ns2",uid="foo"} 1
 				capi_machinedeployment_status_replicas{machinedeployment="md2",namespace="ns2",uid="foo"} 3
 			`,
-			MetricNames: []string{"capi_machinedeployment_status_replicas", "capi_machinedeployment_status_replicas_available", "capi_machinedeployment_status_replicas_unavailable", "capi_machinedeployment_status_replicas_updated", "capi_machinedeployment_spec_replicas"},
+			MetricNames: []string{"capi_machinedeployment_status_replicas", "capi_machinedeployment_status_replicas_available", "capi_machinedeployment_status_replicas_unavailable", "capi_machinedeployment_status_replicas_updated", "capi_machinedeployment_spec_replicas", "capi_machinedeployment_rollout_updated_ratio"},
 		},
 		{
 			Obj: &clusterv1.MachineDeployment{
@@ -140,13 +146,99 @@ func TestMachineDeploymentStore(t *testing.T) {
 			`,
 			MetricNames: []string{"capi_machinedeployment_spec_strategy_rollingupdate_max_surge", "capi_machinedeployment_spec_strategy_rollingupdate_max_unavailable"},
 		},
+		{
+			Obj: &clusterv1.MachineDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "md4",
+					Namespace:         "ns4",
+					CreationTimestamp: metav1StartTime,
+					ResourceVersion:   "10596",
+					UID:               types.UID("foo"),
+					Annotations: map[string]string{
+						clusterv1.RevisionAnnotation: "3",
+					},
+				},
+				Spec: clusterv1.MachineDeploymentSpec{
+					Paused: true,
+					Strategy: &clusterv1.MachineDeploymentStrategy{
+						Type: clusterv1.RollingUpdateMachineDeploymentStrategyType,
+					},
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Version:       pointer.String("v1.22.4"),
+							FailureDomain: pointer.String("az1"),
+							Bootstrap: clusterv1.Bootstrap{
+								ConfigRef: &corev1.ObjectReference{
+									Kind: "KubeadmConfigTemplate",
+									Name: "md4-bootstrap",
+								},
+							},
+							InfrastructureRef: corev1.ObjectReference{
+								Kind: "DockerMachineTemplate",
+								Name: "md4-infra",
+							},
+						},
+					},
+				},
+			},
+			Want: `
+				# HELP capi_machinedeployment_info Information about a machinedeployment.
+				# HELP capi_machinedeployment_paused_rollout The rollout of the machinedeployment is paused via its spec.
+				# TYPE capi_machinedeployment_info gauge
+				# TYPE capi_machinedeployment_paused_rollout gauge
+				capi_machinedeployment_info{bootstrap_config_kind="KubeadmConfigTemplate",bootstrap_config_name="md4-bootstrap",failure_domain="az1",infrastructure_kind="DockerMachineTemplate",infrastructure_name="md4-infra",machinedeployment="md4",namespace="ns4",revision="3",strategy="RollingUpdate",uid="foo",version="v1.22.4"} 1
+				capi_machinedeployment_paused_rollout{machinedeployment="md4",namespace="ns4",uid="foo"} 1
+			`,
+			MetricNames: []string{"capi_machinedeployment_info", "capi_machinedeployment_paused_rollout"},
+		},
+		{
+			Obj: &clusterv1.MachineDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "md5",
+					Namespace:         "ns5",
+					CreationTimestamp: metav1StartTime,
+					ResourceVersion:   "10596",
+					UID:               types.UID("foo"),
+				},
+				Spec: clusterv1.MachineDeploymentSpec{
+					Replicas: pointer.Int32Ptr(3),
+					Strategy: &clusterv1.MachineDeploymentStrategy{
+						RollingUpdate: &clusterv1.MachineRollingUpdateDeployment{
+							MaxSurge:       &intstr.IntOrString{Type: intstr.String, StrVal: "abc%"},
+							MaxUnavailable: &intstr.IntOrString{Type: intstr.String, StrVal: "1"},
+						},
+					},
+				},
+			},
+			Want: `
+				# HELP capi_machinedeployment_spec_strategy_rollingupdate_max_surge Maximum number of replicas that can be scheduled above the desired number of replicas during a rolling update of a machinedeployment.
+				# HELP capi_machinedeployment_spec_strategy_rollingupdate_max_unavailable Maximum number of unavailable replicas during a rolling update of a machinedeployment.
+				# HELP capi_machinedeployment_created Unix creation timestamp
+				# TYPE capi_machinedeployment_spec_strategy_rollingupdate_max_surge gauge
+				# TYPE capi_machinedeployment_spec_strategy_rollingupdate_max_unavailable gauge
+				# TYPE capi_machinedeployment_created gauge
+				capi_machinedeployment_created{machinedeployment="md5",namespace="ns5",uid="foo"} 1.501569018e+09
+			`,
+			MetricNames: []string{"capi_machinedeployment_spec_strategy_rollingupdate_max_surge", "capi_machinedeployment_spec_strategy_rollingupdate_max_unavailable", "capi_machinedeployment_created"},
+			GenerationErrors: map[string]float64{
+				"capi_machinedeployment_spec_strategy_rollingupdate_max_surge":       1,
+				"capi_machinedeployment_spec_strategy_rollingupdate_max_unavailable": 1,
+			},
+		},
 	}
 	for i, c := range cases {
 		f := MachineDeploymentFactory{}
-		c.Func = generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil))
+		b := NewBuilder()
+		b.WithMetrics(prometheus.NewRegistry())
+		c.Func = generator.ComposeMetricGenFuncs(b.withGenerationErrors(f.MetricFamilyGenerators(nil, nil)))
 		c.Headers = generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil))
 		if err := c.run(); err != nil {
 			t.Errorf("unexpected collecting result in %vth run:\n%s", i, err)
 		}
+		for _, name := range c.MetricNames {
+			if got := testutil.ToFloat64(b.exporterMetrics.GenerationErrors.WithLabelValues("", name)); got != c.GenerationErrors[name] {
+				t.Errorf("expected %v generation errors of %s in %vth run, got %v", c.GenerationErrors[name], name, i, got)
+			}
+		}
 	}
 }
@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+// MachineDeploymentRolloutFactory generates metrics about the progress of
+// machinedeployment rollouts. In contrast to Deployments, MachineDeployments
+// do not have a progress deadline. Because of that the factory observes the
+// watched machinedeployments and records when their updated replicas or
+// observed generation changed.
+type MachineDeploymentRolloutFactory struct {
+	// ProgressDeadline is the duration after which a rollout without progress
+	// is considered as stalled.
+	ProgressDeadline time.Duration
+
+	now      func() time.Time
+	mtx      sync.Mutex
+	progress map[types.UID]machineDeploymentProgress
+}
+
+// machineDeploymentProgress is the last observed progress of a machinedeployment.
+type machineDeploymentProgress struct {
+	observedGeneration int64
+	updatedReplicas    int32
+	lastProgress       time.Time
+}
+
+// NewMachineDeploymentRolloutFactory returns a new MachineDeploymentRolloutFactory.
+func NewMachineDeploymentRolloutFactory(progressDeadline time.Duration) *MachineDeploymentRolloutFactory {
+	return &MachineDeploymentRolloutFactory{
+		ProgressDeadline: progressDeadline,
+		now:              time.Now,
+		progress:         map[types.UID]machineDeploymentProgress{},
+	}
+}
+
+func (f *MachineDeploymentRolloutFactory) Name() string {
+	return "machinedeploymentrollouts"
+}
+
+func (f *MachineDeploymentRolloutFactory) Resources() []string {
+	return []string{"machinedeployments"}
+}
+
+func (f *MachineDeploymentRolloutFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_machinedeployment_rollout_last_progress_seconds",
+			"Seconds since the updated replicas or the observed generation of a machinedeployment last changed.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				mds, lastProgress := f.lastProgress(o)
+				ms := make([]*metric.Metric, len(mds))
+
+				for i, md := range mds {
+					ms[i] = &metric.Metric{
+						LabelKeys:   append(append([]string{}, descMachineDeploymentLabelsDefaultLabels...), "cluster"),
+						LabelValues: []string{md.Namespace, md.Name, string(md.UID), md.Spec.ClusterName},
+						Value:       f.now().Sub(lastProgress[i]).Seconds(),
+					}
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+		*generator.NewFamilyGenerator(
+			"capi_machinedeployment_rollout_stalled",
+			"The rollout of the machinedeployment did not progress within the progress deadline.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				mds, lastProgress := f.lastProgress(o)
+				ms := make([]*metric.Metric, len(mds))
+
+				for i, md := range mds {
+					stalled := machineDeploymentRolloutInProgress(md) && f.now().Sub(lastProgress[i]) > f.ProgressDeadline
+					ms[i] = &metric.Metric{
+						LabelKeys:   append(append([]string{}, descMachineDeploymentLabelsDefaultLabels...), "cluster"),
+						LabelValues: []string{md.Namespace, md.Name, string(md.UID), md.Spec.ClusterName},
+						Value:       boolFloat64(stalled),
+					}
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+	}
+}
+
+// ObjectUpdated records the time of the last progress of the machinedeployment.
+// A machinedeployment which was not observed before, e.g. after a restart,
+// progressed at the latest transition of its conditions.
+func (f *MachineDeploymentRolloutFactory) ObjectUpdated(resource string, obj interface{}) {
+	md, ok := obj.(*clusterv1.MachineDeployment)
+	if !ok {
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	p, ok := f.progress[md.UID]
+	switch {
+	case !ok:
+		p.lastProgress = lastTransitionTime(md)
+	case p.observedGeneration != md.Status.ObservedGeneration || p.updatedReplicas != md.Status.UpdatedReplicas:
+		p.lastProgress = f.now()
+	}
+	p.observedGeneration = md.Status.ObservedGeneration
+	p.updatedReplicas = md.Status.UpdatedReplicas
+	f.progress[md.UID] = p
+}
+
+// ObjectDeleted drops the progress of the machinedeployment.
+func (f *MachineDeploymentRolloutFactory) ObjectDeleted(resource string, obj interface{}) {
+	md, ok := obj.(*clusterv1.MachineDeployment)
+	if !ok {
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	delete(f.progress, md.UID)
+}
+
+// lastProgress returns all machinedeployments together with the time of their
+// last progress.
+func (f *MachineDeploymentRolloutFactory) lastProgress(o Objects) ([]*clusterv1.MachineDeployment, []time.Time) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	objs := o.List("machinedeployments")
+	mds := make([]*clusterv1.MachineDeployment, len(objs))
+	lastProgress := make([]time.Time, len(objs))
+
+	for i, obj := range objs {
+		md := obj.(*clusterv1.MachineDeployment)
+		mds[i] = md
+		if p, ok := f.progress[md.UID]; ok {
+			lastProgress[i] = p.lastProgress
+		} else {
+			lastProgress[i] = lastTransitionTime(md)
+		}
+	}
+
+	return mds, lastProgress
+}
+
+// lastTransitionTime returns the latest transition time of the conditions of
+// the machinedeployment or its creation time if it has no conditions.
+func lastTransitionTime(md *clusterv1.MachineDeployment) time.Time {
+	t := md.CreationTimestamp.Time
+	for _, c := range md.Status.Conditions {
+		if c.LastTransitionTime.After(t) {
+			t = c.LastTransitionTime.Time
+		}
+	}
+	return t
+}
+
+// machineDeploymentRolloutInProgress returns true if the machinedeployment is
+// not paused and not all of its replicas are updated and available yet.
+func machineDeploymentRolloutInProgress(md *clusterv1.MachineDeployment) bool {
+	if md.Spec.Paused || md.Spec.Replicas == nil {
+		return false
+	}
+
+	desired := *md.Spec.Replicas
+	return md.Status.ObservedGeneration < md.Generation ||
+		md.Status.UpdatedReplicas != desired ||
+		md.Status.Replicas != desired ||
+		md.Status.AvailableReplicas != desired
+}
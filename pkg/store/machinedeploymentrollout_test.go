@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	"k8s.io/utils/pointer"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestMachineDeploymentRolloutStore(t *testing.T) {
+	start := time.Unix(1501569018, 0)
+	now := start
+
+	f := NewMachineDeploymentRolloutFactory(10 * time.Minute)
+	f.now = func() time.Time { return now }
+
+	newMachineDeployment := func(name string, updatedReplicas int32, conditions ...clusterv1.Condition) *clusterv1.MachineDeployment {
+		return &clusterv1.MachineDeployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "ns1",
+				UID:               types.UID(name),
+				Generation:        2,
+				CreationTimestamp: metav1.NewTime(start.Add(-20 * time.Minute)),
+			},
+			Spec: clusterv1.MachineDeploymentSpec{
+				ClusterName: "c1",
+				Replicas:    pointer.Int32(3),
+			},
+			Status: clusterv1.MachineDeploymentStatus{
+				ObservedGeneration: 2,
+				Replicas:           3,
+				UpdatedReplicas:    updatedReplicas,
+				AvailableReplicas:  3,
+				Conditions:         conditions,
+			},
+		}
+	}
+	available := clusterv1.Condition{
+		Type:               clusterv1.MachineDeploymentAvailableCondition,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(start.Add(-5 * time.Minute)),
+	}
+
+	cases := []struct {
+		elapsed time.Duration
+		updated []interface{}
+		deleted []interface{}
+		want    string
+	}{
+		{
+			// Machinedeployments which were not observed before progressed at
+			// the latest transition of their conditions or their creation.
+			elapsed: 0,
+			updated: []interface{}{newMachineDeployment("md1", 1, available), newMachineDeployment("md2", 3)},
+			want: `
+				# HELP capi_machinedeployment_rollout_last_progress_seconds Seconds since the updated replicas or the observed generation of a machinedeployment last changed.
+				# HELP capi_machinedeployment_rollout_stalled The rollout of the machinedeployment did not progress within the progress deadline.
+				# TYPE capi_machinedeployment_rollout_last_progress_seconds gauge
+				# TYPE capi_machinedeployment_rollout_stalled gauge
+				capi_machinedeployment_rollout_last_progress_seconds{cluster="c1",machinedeployment="md1",namespace="ns1",uid="md1"} 300
+				capi_machinedeployment_rollout_last_progress_seconds{cluster="c1",machinedeployment="md2",namespace="ns1",uid="md2"} 1200
+				capi_machinedeployment_rollout_stalled{cluster="c1",machinedeployment="md1",namespace="ns1",uid="md1"} 0
+				capi_machinedeployment_rollout_stalled{cluster="c1",machinedeployment="md2",namespace="ns1",uid="md2"} 0
+			`,
+		},
+		{
+			// Updates without progress, e.g. resyncs, keep the last progress.
+			elapsed: 15 * time.Minute,
+			updated: []interface{}{newMachineDeployment("md1", 1, available), newMachineDeployment("md2", 3)},
+			want: `
+				# HELP capi_machinedeployment_rollout_last_progress_seconds Seconds since the updated replicas or the observed generation of a machinedeployment last changed.
+				# HELP capi_machinedeployment_rollout_stalled The rollout of the machinedeployment did not progress within the progress deadline.
+				# TYPE capi_machinedeployment_rollout_last_progress_seconds gauge
+				# TYPE capi_machinedeployment_rollout_stalled gauge
+				capi_machinedeployment_rollout_last_progress_seconds{cluster="c1",machinedeployment="md1",namespace="ns1",uid="md1"} 1200
+				capi_machinedeployment_rollout_last_progress_seconds{cluster="c1",machinedeployment="md2",namespace="ns1",uid="md2"} 2100
+				capi_machinedeployment_rollout_stalled{cluster="c1",machinedeployment="md1",namespace="ns1",uid="md1"} 1
+				capi_machinedeployment_rollout_stalled{cluster="c1",machinedeployment="md2",namespace="ns1",uid="md2"} 0
+			`,
+		},
+		{
+			elapsed: 20 * time.Minute,
+			updated: []interface{}{newMachineDeployment("md1", 2, available)},
+			deleted: []interface{}{newMachineDeployment("md2", 3)},
+			want: `
+				# HELP capi_machinedeployment_rollout_last_progress_seconds Seconds since the updated replicas or the observed generation of a machinedeployment last changed.
+				# HELP capi_machinedeployment_rollout_stalled The rollout of the machinedeployment did not progress within the progress deadline.
+				# TYPE capi_machinedeployment_rollout_last_progress_seconds gauge
+				# TYPE capi_machinedeployment_rollout_stalled gauge
+				capi_machinedeployment_rollout_last_progress_seconds{cluster="c1",machinedeployment="md1",namespace="ns1",uid="md1"} 0
+				capi_machinedeployment_rollout_stalled{cluster="c1",machinedeployment="md1",namespace="ns1",uid="md1"} 0
+			`,
+		},
+	}
+	for i, c := range cases {
+		now = start.Add(c.elapsed)
+		for _, obj := range c.updated {
+			f.ObjectUpdated("machinedeployments", obj)
+		}
+		for _, obj := range c.deleted {
+			f.ObjectDeleted("machinedeployments", obj)
+		}
+		tc := generateMetricsTestCase{
+			Obj:     newObjects(map[string][]interface{}{"machinedeployments": c.updated}),
+			Want:    c.want,
+			Func:    generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil)),
+			Headers: generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil)),
+		}
+		if err := tc.run(); err != nil {
+			t.Errorf("unexpected collecting result in %vth run:\n%s", i, err)
+		}
+	}
+
+	if _, ok := f.progress[types.UID("md2")]; ok {
+		t.Errorf("expected progress of deleted machinedeployment md2 to be dropped")
+	}
+}
@@ -183,27 +183,39 @@ func (f *MachineSetFactory) MetricFamilyGenerators(allowAnnotationsList, allowLa
 }
 
 func (f *MachineSetFactory) ListWatch(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+	return f.ListWatchWithContext(context.Background(), customResourceClient, ns, fieldSelector)
+}
+
+func (f *MachineSetFactory) ListWatchWithContext(ctx context.Context, customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
 	ctrlClient := customResourceClient.(client.WithWatch)
 	return &cache.ListWatch{
 		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
 			machineSetList := clusterv1.MachineSetList{}
 			opts.FieldSelector = fieldSelector
-			err := ctrlClient.List(context.TODO(), &machineSetList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			err := ctrlClient.List(ctx, &machineSetList, &client.ListOptions{Raw: &opts, Namespace: ns, Limit: opts.Limit, Continue: opts.Continue})
 			return &machineSetList, err
 		},
 		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
 			machineSetList := clusterv1.MachineSetList{}
 			opts.FieldSelector = fieldSelector
-			return ctrlClient.Watch(context.TODO(), &machineSetList, &client.ListOptions{Raw: &opts, Namespace: ns})
+			return ctrlClient.Watch(ctx, &machineSetList, &client.ListOptions{Raw: &opts, Namespace: ns})
 		},
 	}
 }
 
+func (f *MachineSetFactory) TransformObject(obj interface{}) {
+	stripObjectMeta(obj.(*clusterv1.MachineSet))
+}
+
 func wrapMachineSetFunc(f func(*clusterv1.MachineSet) *metric.Family) func(interface{}) *metric.Family {
-	return func(obj interface{}) *metric.Family {
+	return func(obj interface{}) (metricFamily *metric.Family) {
 		machineSet := obj.(*clusterv1.MachineSet)
+		defer recoverFamily(&metricFamily, "machineset", machineSet)
 
-		metricFamily := f(machineSet)
+		metricFamily = f(machineSet)
+		if metricFamily == nil {
+			return nil
+		}
 
 		for _, m := range metricFamily.Metrics {
 			m.LabelKeys = append(descMachineSetLabelsDefaultLabels, m.LabelKeys...)
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"time"
+
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+// StuckThresholds are the durations after which objects which did not reach
+// their desired state are considered as stuck.
+type StuckThresholds struct {
+	// MachineDeploymentProgressDeadline is the duration after which a
+	// machinedeployment rollout without progress is considered as stalled.
+	MachineDeploymentProgressDeadline time.Duration
+	// MachineProvisioning is the duration after which a machine in the
+	// Pending, Provisioning or Provisioned phase is considered as stuck.
+	MachineProvisioning time.Duration
+	// MachineDeletion is the duration after which a deleted machine which
+	// still exists is considered as stuck.
+	MachineDeletion time.Duration
+}
+
+// MachineStuckFactory generates metrics about machines which did not finish
+// their provisioning or deletion within the thresholds.
+type MachineStuckFactory struct {
+	ProvisioningThreshold time.Duration
+	DeletionThreshold     time.Duration
+
+	now func() time.Time
+}
+
+// NewMachineStuckFactory returns a new MachineStuckFactory.
+func NewMachineStuckFactory(provisioningThreshold, deletionThreshold time.Duration) *MachineStuckFactory {
+	return &MachineStuckFactory{
+		ProvisioningThreshold: provisioningThreshold,
+		DeletionThreshold:     deletionThreshold,
+		now:                   time.Now,
+	}
+}
+
+func (f *MachineStuckFactory) Name() string {
+	return "stuckmachines"
+}
+
+func (f *MachineStuckFactory) Resources() []string {
+	return []string{"machines"}
+}
+
+func (f *MachineStuckFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_machine_stuck",
+			"The machine did not finish its provisioning or deletion within the threshold.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				objs := o.List("machines")
+				ms := make([]*metric.Metric, 0, len(objs))
+
+				for _, obj := range objs {
+					m := obj.(*clusterv1.Machine)
+					phase, since := machinePhaseSince(m)
+					if phase == "" {
+						continue
+					}
+
+					stuck := false
+					switch clusterv1.MachinePhase(phase) {
+					case clusterv1.MachinePhasePending, clusterv1.MachinePhaseProvisioning, clusterv1.MachinePhaseProvisioned:
+						stuck = f.now().Sub(since) > f.ProvisioningThreshold
+					case clusterv1.MachinePhaseDeleting:
+						stuck = f.now().Sub(since) > f.DeletionThreshold
+					}
+
+					ms = append(ms, &metric.Metric{
+						LabelKeys:   append(append([]string{}, descMachineLabelsDefaultLabels...), "cluster", "phase"),
+						LabelValues: []string{m.Namespace, m.Name, string(m.UID), m.Spec.ClusterName, phase},
+						Value:       boolFloat64(stuck),
+					})
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+	}
+}
+
+// machinePhaseSince returns the phase of the machine and the time since when
+// it is in that phase. Deleted machines are in the Deleting phase since their
+// deletion timestamp, even if the status was not updated yet.
+func machinePhaseSince(m *clusterv1.Machine) (string, time.Time) {
+	if m.DeletionTimestamp != nil {
+		return string(clusterv1.MachinePhaseDeleting), m.DeletionTimestamp.Time
+	}
+	if m.Status.LastUpdated != nil {
+		return m.Status.Phase, m.Status.LastUpdated.Time
+	}
+	return m.Status.Phase, m.CreationTimestamp.Time
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestMachineStuckStore(t *testing.T) {
+	now := time.Unix(1501569018, 0)
+
+	f := NewMachineStuckFactory(30*time.Minute, 10*time.Minute)
+	f.now = func() time.Time { return now }
+
+	newMachine := func(name string, phase clusterv1.MachinePhase, lastUpdated time.Duration) *clusterv1.Machine {
+		m := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "ns1",
+				UID:               types.UID(name),
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Hour)),
+			},
+			Spec: clusterv1.MachineSpec{
+				ClusterName: "c1",
+			},
+			Status: clusterv1.MachineStatus{
+				Phase: string(phase),
+			},
+		}
+		if lastUpdated != 0 {
+			ts := metav1.NewTime(now.Add(-lastUpdated))
+			m.Status.LastUpdated = &ts
+		}
+		return m
+	}
+
+	deleted := newMachine("deleted", clusterv1.MachinePhaseRunning, time.Hour)
+	deletionTimestamp := metav1.NewTime(now.Add(-15 * time.Minute))
+	deleted.DeletionTimestamp = &deletionTimestamp
+
+	tc := generateMetricsTestCase{
+		Obj: newObjects(map[string][]interface{}{
+			"machines": {
+				newMachine("provisioning", clusterv1.MachinePhaseProvisioning, 5*time.Minute),
+				newMachine("provisioning-stuck", clusterv1.MachinePhaseProvisioning, 45*time.Minute),
+				newMachine("pending-without-last-updated", clusterv1.MachinePhasePending, 0),
+				newMachine("running", clusterv1.MachinePhaseRunning, 2*time.Hour),
+				newMachine("deleting", clusterv1.MachinePhaseDeleting, 5*time.Minute),
+				newMachine("without-phase", "", 0),
+				deleted,
+			},
+		}),
+		Want: `
+			# HELP capi_machine_stuck The machine did not finish its provisioning or deletion within the threshold.
+			# TYPE capi_machine_stuck gauge
+			capi_machine_stuck{cluster="c1",machine="deleted",namespace="ns1",phase="Deleting",uid="deleted"} 1
+			capi_machine_stuck{cluster="c1",machine="deleting",namespace="ns1",phase="Deleting",uid="deleting"} 0
+			capi_machine_stuck{cluster="c1",machine="pending-without-last-updated",namespace="ns1",phase="Pending",uid="pending-without-last-updated"} 1
+			capi_machine_stuck{cluster="c1",machine="provisioning",namespace="ns1",phase="Provisioning",uid="provisioning"} 0
+			capi_machine_stuck{cluster="c1",machine="provisioning-stuck",namespace="ns1",phase="Provisioning",uid="provisioning-stuck"} 1
+			capi_machine_stuck{cluster="c1",machine="running",namespace="ns1",phase="Running",uid="running"} 0
+		`,
+		Func:    generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil)),
+		Headers: generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil)),
+	}
+	if err := tc.run(); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2018 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The original file is located at [1].
+[1]: https://github.com/kubernetes/kube-state-metrics/blob/41eea36f69ef/pkg/metrics_store/metrics_store.go
+
+The original source was adjusted to:
+- keep the namespace and cluster of each object.
+- write the metrics of the objects matching a MetricsFilter.
+- keep the name of the resource.
+- keep the name of the management cluster.
+- count the objects of the store.
+- list the namespaces and the resource of the objects.
+- keep the objects, so the metrics can be regenerated when the metric families change.
+- list the objects of a cluster.
+*/
+
+package store
+
+import (
+	"io"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+)
+
+// MetricsStore implements the k8s.io/client-go/tools/cache.Store
+// interface. Instead of storing entire Kubernetes objects, it stores metrics
+// generated based on those objects.
+type MetricsStore struct {
+	// Protects metrics
+	mutex sync.RWMutex
+	// metrics is a map indexed by Kubernetes object id, containing the
+	// namespace and cluster of the object and a slice of metric families,
+	// containing a slice of metrics. We need to keep metrics grouped by metric
+	// families in order to zip families with their help text in
+	// MetricsStore.WriteAll().
+	metrics map[types.UID]*objectMetrics
+	// headers contains the header (TYPE and HELP) of each metric family. It is
+	// later on zipped with with their corresponding metric families in
+	// MetricStore.WriteAll().
+	headers []string
+
+	// generateMetricsFunc generates metrics based on a given Kubernetes object
+	// and returns them grouped by metric family.
+	generateMetricsFunc func(interface{}) []metric.FamilyInterface
+
+	// resource is the name of the resource of the objects, it is used to
+	// authorize the metrics of the objects.
+	resource string
+	// managementCluster is the name of the management cluster the objects
+	// are watched from. It is empty if no management clusters are configured.
+	managementCluster string
+}
+
+// objectMetrics holds the metric families of an object. The object is kept,
+// so the metrics can be regenerated when the metric families change.
+type objectMetrics struct {
+	object    interface{}
+	namespace string
+	cluster   string
+	families  [][]byte
+}
+
+// NewMetricsStore returns a new MetricsStore
+func NewMetricsStore(headers []string, generateFunc func(interface{}) []metric.FamilyInterface) *MetricsStore {
+	return &MetricsStore{
+		generateMetricsFunc: generateFunc,
+		headers:             headers,
+		metrics:             map[types.UID]*objectMetrics{},
+	}
+}
+
+// Implementing k8s.io/client-go/tools/cache.Store interface
+
+// Add inserts adds to the MetricsStore by calling the metrics generator functions and
+// adding the generated metrics to the metrics map that underlies the MetricStore.
+func (s *MetricsStore) Add(obj interface{}) error {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.metrics[o.GetUID()] = &objectMetrics{
+		object:    obj,
+		namespace: o.GetNamespace(),
+		cluster:   getClusterName(obj),
+		families:  s.generate(obj),
+	}
+
+	return nil
+}
+
+// generate returns the metric families of the object. The mutex has to be
+// held by the caller.
+func (s *MetricsStore) generate(obj interface{}) [][]byte {
+	families := s.generateMetricsFunc(obj)
+	familyStrings := make([][]byte, len(families))
+
+	for i, f := range families {
+		familyStrings[i] = f.ByteSlice()
+	}
+
+	return familyStrings
+}
+
+// regenerate replaces the metric families of the store and regenerates the
+// metrics of all objects, so the store does not have to be relisted.
+func (s *MetricsStore) regenerate(headers []string, generateFunc func(interface{}) []metric.FamilyInterface) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.headers = headers
+	s.generateMetricsFunc = generateFunc
+	for _, m := range s.metrics {
+		m.families = s.generate(m.object)
+	}
+}
+
+// Update updates the existing entry in the MetricsStore.
+func (s *MetricsStore) Update(obj interface{}) error {
+	// TODO: For now, just call Add, in the future one could check if the resource version changed?
+	return s.Add(obj)
+}
+
+// Delete deletes an existing entry in the MetricsStore.
+func (s *MetricsStore) Delete(obj interface{}) error {
+
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.metrics, o.GetUID())
+
+	return nil
+}
+
+// List implements the List method of the store interface.
+func (s *MetricsStore) List() []interface{} {
+	return nil
+}
+
+// ListKeys implements the ListKeys method of the store interface.
+func (s *MetricsStore) ListKeys() []string {
+	return nil
+}
+
+// Get implements the Get method of the store interface.
+func (s *MetricsStore) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	return nil, false, nil
+}
+
+// GetByKey implements the GetByKey method of the store interface.
+func (s *MetricsStore) GetByKey(key string) (item interface{}, exists bool, err error) {
+	return nil, false, nil
+}
+
+// Replace will delete the contents of the store, using instead the
+// given list.
+func (s *MetricsStore) Replace(list []interface{}, _ string) error {
+	s.mutex.Lock()
+	s.metrics = map[types.UID]*objectMetrics{}
+	s.mutex.Unlock()
+
+	for _, o := range list {
+		err := s.Add(o)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of objects in the store.
+func (s *MetricsStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.metrics)
+}
+
+// Resync implements the Resync method of the store interface.
+func (s *MetricsStore) Resync() error {
+	return nil
+}
+
+// WriteAll writes all metrics of the store into the given writer, zipped with the
+// help text of each metric family.
+func (s *MetricsStore) WriteAll(w io.Writer) {
+	s.WriteFiltered(w, MetricsFilter{})
+}
+
+// WriteFiltered writes the metrics of the objects matching the filter into the
+// given writer, zipped with the help text of each metric family.
+func (s *MetricsStore) WriteFiltered(w io.Writer, filter MetricsFilter) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for i, help := range s.headers {
+		w.Write([]byte(help))
+		w.Write([]byte{'\n'})
+		for _, m := range s.metrics {
+			if filter.matches(s.managementCluster, m.namespace, m.cluster, s.resource) {
+				w.Write(m.families[i])
+			}
+		}
+	}
+}
+
+// Namespaces returns the namespaces of the objects of the store.
+func (s *MetricsStore) Namespaces() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	namespaces := stringSet{}
+	for _, m := range s.metrics {
+		namespaces[m.namespace] = true
+	}
+	return namespaces.list()
+}
+
+// Resources returns the resource of the objects of the store.
+func (s *MetricsStore) Resources() []string {
+	return []string{s.resource}
+}
+
+// clusterObjects returns the objects of the given cluster.
+func (s *MetricsStore) clusterObjects(namespace, cluster string) []interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var objs []interface{}
+	for _, m := range s.metrics {
+		if m.namespace == namespace && m.cluster == cluster {
+			objs = append(objs, m.object)
+		}
+	}
+	return objs
+}
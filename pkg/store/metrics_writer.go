@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The original file is located at [1].
+[1]: https://github.com/kubernetes/kube-state-metrics/blob/41eea36f69ef/pkg/metrics_store/metrics_writer.go
+
+The original source was adjusted to:
+- use the MetricsStore of cluster-api-state-metrics.
+- write the metrics of the objects matching a MetricsFilter.
+- list the namespaces and resources of the objects.
+*/
+
+package store
+
+import (
+	"io"
+
+	metricsstore "k8s.io/kube-state-metrics/v2/pkg/metrics_store"
+)
+
+// MultiStoreMetricsWriter is a struct that holds multiple MetricsStore(s) and
+// implements the MetricsWriter interface.
+// It should be used with stores which have the same metric headers.
+//
+// MultiStoreMetricsWriter writes out metrics from the underlying stores so that
+// metrics with the same name coming from different stores end up grouped together.
+// It also ensures that the metric headers are only written out once.
+type MultiStoreMetricsWriter struct {
+	stores []*MetricsStore
+}
+
+// NewMultiStoreMetricsWriter creates a new MultiStoreMetricsWriter.
+func NewMultiStoreMetricsWriter(stores []*MetricsStore) metricsstore.MetricsWriter {
+	return &MultiStoreMetricsWriter{
+		stores: stores,
+	}
+}
+
+// WriteAll writes out metrics from the underlying stores to the given writer.
+//
+// WriteAll writes metrics so that the ones with the same name
+// are grouped together when written out.
+func (m MultiStoreMetricsWriter) WriteAll(w io.Writer) {
+	m.WriteFiltered(w, MetricsFilter{})
+}
+
+// WriteFiltered writes out the metrics of the objects matching the filter from
+// the underlying stores to the given writer.
+func (m MultiStoreMetricsWriter) WriteFiltered(w io.Writer, filter MetricsFilter) {
+	if len(m.stores) == 0 {
+		return
+	}
+
+	for _, s := range m.stores {
+		s.mutex.RLock()
+		defer func(s *MetricsStore) {
+			s.mutex.RUnlock()
+		}(s)
+	}
+
+	for i, help := range m.stores[0].headers {
+		w.Write([]byte(help))
+		w.Write([]byte{'\n'})
+		for _, s := range m.stores {
+			for _, m := range s.metrics {
+				if filter.matches(s.managementCluster, m.namespace, m.cluster, s.resource) {
+					w.Write(m.families[i])
+				}
+			}
+		}
+	}
+}
+
+// Namespaces returns the namespaces of the objects of the underlying stores.
+func (m MultiStoreMetricsWriter) Namespaces() []string {
+	namespaces := stringSet{}
+	for _, s := range m.stores {
+		namespaces.add(s.Namespaces())
+	}
+	return namespaces.list()
+}
+
+// Resources returns the resources of the objects of the underlying stores.
+func (m MultiStoreMetricsWriter) Resources() []string {
+	resources := stringSet{}
+	for _, s := range m.stores {
+		resources.add(s.Resources())
+	}
+	return resources.list()
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"io"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+// MetricsFilter selects the objects whose metrics are written. The zero value
+// selects all objects.
+type MetricsFilter struct {
+	// ManagementCluster is the name of the management cluster the objects
+	// are watched from. All management clusters are selected if it is empty.
+	ManagementCluster string
+	// Namespace is the namespace of the objects. All namespaces are selected
+	// if it is empty.
+	Namespace string
+	// Cluster is the name of the cluster in the namespace the objects belong
+	// to. All objects of the namespace are selected if it is empty.
+	Cluster string
+	// Allowed holds the permissions of each management cluster, which are
+	// empty if no management clusters are configured. All objects are allowed
+	// if it is nil.
+	Allowed map[string]Permissions
+}
+
+// Permissions holds for each resource the namespaces in which the metrics of
+// its objects may be written. The empty namespace allows all namespaces and is
+// required for metrics without a namespace. The permissions are resolved
+// before the metrics are written, so no reviews are sent while the stores are
+// locked.
+type Permissions map[string]map[string]bool
+
+// allowed returns true if the metrics of the objects of the resource in the
+// namespace may be written.
+func (p Permissions) allowed(resource, namespace string) bool {
+	return p[resource][""] || namespace != "" && p[resource][namespace]
+}
+
+// FilteredMetricsWriter is implemented by metrics writers which can write the
+// metrics of the objects matching a MetricsFilter.
+type FilteredMetricsWriter interface {
+	WriteFiltered(w io.Writer, filter MetricsFilter)
+	// Namespaces returns the namespaces of the objects whose metrics are
+	// written, so the permissions can be resolved for them.
+	Namespaces() []string
+	// Resources returns the resources of the objects whose metrics are
+	// written, so only their permissions are resolved.
+	Resources() []string
+}
+
+// IsZero returns true if the filter selects all objects.
+func (f MetricsFilter) IsZero() bool {
+	return f.ManagementCluster == "" && f.Namespace == "" && f.Cluster == "" && f.Allowed == nil
+}
+
+// matches returns true if an object of the given management cluster with the
+// given namespace and cluster name is selected by the filter. The metrics of the
+// object are generated from the given resources.
+func (f MetricsFilter) matches(managementCluster, namespace, cluster string, resources ...string) bool {
+	if f.ManagementCluster != "" && f.ManagementCluster != managementCluster {
+		return false
+	}
+	if f.Namespace != "" && f.Namespace != namespace {
+		return false
+	}
+	if f.Cluster != "" && f.Cluster != cluster {
+		return false
+	}
+	if f.Allowed != nil {
+		for _, r := range resources {
+			if !f.Allowed[managementCluster].allowed(r, namespace) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// filterFamily returns the metrics of the family whose management cluster,
+// namespace and cluster labels match the filter. Metrics without these labels
+// only match filters which select all management clusters, namespaces or
+// clusters.
+func (f MetricsFilter) filterFamily(family metric.FamilyInterface, resources []string) metric.FamilyInterface {
+	if f.IsZero() {
+		return family
+	}
+
+	var filtered metric.Family
+	family.Inspect(func(family metric.Family) {
+		filtered = metric.Family{
+			Name:    family.Name,
+			Type:    family.Type,
+			Metrics: []*metric.Metric{},
+		}
+		for _, m := range family.Metrics {
+			var managementCluster, namespace, cluster string
+			for i, key := range m.LabelKeys {
+				switch key {
+				case "management_cluster":
+					managementCluster = m.LabelValues[i]
+				case "namespace":
+					namespace = m.LabelValues[i]
+				case "cluster":
+					cluster = m.LabelValues[i]
+				}
+			}
+			if f.matches(managementCluster, namespace, cluster, resources...) {
+				filtered.Metrics = append(filtered.Metrics, m)
+			}
+		}
+	})
+	return &filtered
+}
+
+// stringSet collects the namespaces or resources of objects.
+type stringSet map[string]bool
+
+// addNamespaces adds the namespaces of the objects.
+func (s stringSet) addNamespaces(objs []interface{}) {
+	for _, obj := range objs {
+		if o, err := meta.Accessor(obj); err == nil {
+			s[o.GetNamespace()] = true
+		}
+	}
+}
+
+// add adds the strings.
+func (s stringSet) add(strs []string) {
+	for _, str := range strs {
+		s[str] = true
+	}
+}
+
+// list returns the sorted strings.
+func (s stringSet) list() []string {
+	strs := make([]string, 0, len(s))
+	for str := range s {
+		strs = append(strs, str)
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+// getClusterName returns the name of the cluster the object belongs to. This
+// is the name of a cluster itself and the cluster name label of all other
+// objects.
+func getClusterName(obj interface{}) string {
+	if c, ok := obj.(*clusterv1.Cluster); ok {
+		return c.Name
+	}
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return o.GetLabels()[clusterv1.ClusterLabelName]
+}
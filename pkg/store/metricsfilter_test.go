@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestMetricsStoreWriteFiltered(t *testing.T) {
+	families := []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_test_info",
+			"Test info.",
+			metric.Gauge,
+			"",
+			func(obj interface{}) *metric.Family {
+				o := obj.(metav1.Object)
+				return &metric.Family{
+					Metrics: []*metric.Metric{
+						{
+							LabelKeys:   []string{"namespace", "name"},
+							LabelValues: []string{o.GetNamespace(), o.GetName()},
+							Value:       1,
+						},
+					},
+				}
+			},
+		),
+	}
+	s := NewMetricsStore(generator.ExtractMetricFamilyHeaders(families), generator.ComposeMetricGenFuncs(families))
+	s.resource = "machines"
+	s.managementCluster = "mc1"
+
+	objs := []interface{}{
+		&clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1", UID: types.UID("c1")}},
+		&clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "ns1", UID: types.UID("m1"), Labels: map[string]string{clusterv1.ClusterLabelName: "c1"}}},
+		&clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "m2", Namespace: "ns1", UID: types.UID("m2"), Labels: map[string]string{clusterv1.ClusterLabelName: "c2"}}},
+		&clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "m3", Namespace: "ns2", UID: types.UID("m3"), Labels: map[string]string{clusterv1.ClusterLabelName: "c1"}}},
+	}
+	if err := s.Replace(objs, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	header := "# HELP capi_test_info Test info.\n# TYPE capi_test_info gauge\n"
+	cases := []struct {
+		filter MetricsFilter
+		want   []string
+	}{
+		{
+			filter: MetricsFilter{Namespace: "ns1", Cluster: "c1"},
+			want:   []string{"c1", "m1"},
+		},
+		{
+			filter: MetricsFilter{Namespace: "ns1"},
+			want:   []string{"c1", "m1", "m2"},
+		},
+		{
+			filter: MetricsFilter{Namespace: "ns3"},
+		},
+		{
+			filter: MetricsFilter{ManagementCluster: "mc1", Namespace: "ns1", Cluster: "c1"},
+			want:   []string{"c1", "m1"},
+		},
+		{
+			filter: MetricsFilter{ManagementCluster: "mc2", Namespace: "ns1", Cluster: "c1"},
+		},
+		{
+			filter: MetricsFilter{Allowed: map[string]Permissions{"mc1": {"machines": {"ns1": true}}}},
+			want:   []string{"c1", "m1", "m2"},
+		},
+		{
+			filter: MetricsFilter{Allowed: map[string]Permissions{"mc2": {"machines": {"": true}}}},
+		},
+	}
+	for i, c := range cases {
+		buf := &bytes.Buffer{}
+		s.WriteFiltered(buf, c.filter)
+		got := buf.String()
+		if got[:len(header)] != header {
+			t.Errorf("expected header in %vth run, got:\n%s", i, got)
+		}
+		if lines := bytes.Count(buf.Bytes(), []byte("\n")) - 2; lines != len(c.want) {
+			t.Errorf("expected %d metrics in %vth run, got:\n%s", len(c.want), i, got)
+		}
+		for _, name := range c.want {
+			if !bytes.Contains(buf.Bytes(), []byte(`name="`+name+`"`)) {
+				t.Errorf("expected metric of %s in %vth run, got:\n%s", name, i, got)
+			}
+		}
+	}
+}
+
+func TestMetricsFilterFilterFamily(t *testing.T) {
+	family := &metric.Family{
+		Name: "capi_test_info",
+		Metrics: []*metric.Metric{
+			{LabelKeys: []string{"namespace", "cluster"}, LabelValues: []string{"ns1", "c1"}, Value: 1},
+			{LabelKeys: []string{"namespace", "cluster"}, LabelValues: []string{"ns1", "c2"}, Value: 2},
+			{LabelKeys: []string{"namespace"}, LabelValues: []string{"ns1"}, Value: 3},
+			{LabelKeys: []string{"kind"}, LabelValues: []string{"Machine"}, Value: 4},
+		},
+	}
+
+	cases := []struct {
+		filter MetricsFilter
+		want   string
+	}{
+		{
+			filter: MetricsFilter{},
+			want:   "capi_test_info{namespace=\"ns1\",cluster=\"c1\"} 1\ncapi_test_info{namespace=\"ns1\",cluster=\"c2\"} 2\ncapi_test_info{namespace=\"ns1\"} 3\ncapi_test_info{kind=\"Machine\"} 4\n",
+		},
+		{
+			filter: MetricsFilter{Namespace: "ns1"},
+			want:   "capi_test_info{namespace=\"ns1\",cluster=\"c1\"} 1\ncapi_test_info{namespace=\"ns1\",cluster=\"c2\"} 2\ncapi_test_info{namespace=\"ns1\"} 3\n",
+		},
+		{
+			filter: MetricsFilter{Namespace: "ns1", Cluster: "c1"},
+			want:   "capi_test_info{namespace=\"ns1\",cluster=\"c1\"} 1\n",
+		},
+		{
+			filter: MetricsFilter{Allowed: map[string]Permissions{"": {"clusters": {"": true}, "machines": {"ns2": true}}}},
+		},
+		{
+			filter: MetricsFilter{Allowed: map[string]Permissions{"": {"clusters": {"": true}, "machines": {"": true}}}},
+			want:   "capi_test_info{namespace=\"ns1\",cluster=\"c1\"} 1\ncapi_test_info{namespace=\"ns1\",cluster=\"c2\"} 2\ncapi_test_info{namespace=\"ns1\"} 3\ncapi_test_info{kind=\"Machine\"} 4\n",
+		},
+	}
+	for i, c := range cases {
+		if got := string(c.filter.filterFamily(family, []string{"clusters", "machines"}).ByteSlice()); got != c.want {
+			t.Errorf("unexpected result in %vth run, want:\n%s\ngot:\n%s", i, c.want, got)
+		}
+	}
+
+	// clusters with the same namespace and name of different management
+	// clusters are told apart by the management_cluster label
+	family = &metric.Family{
+		Name: "capi_test_info",
+		Metrics: []*metric.Metric{
+			{LabelKeys: []string{"management_cluster", "namespace", "cluster"}, LabelValues: []string{"mc1", "ns1", "c1"}, Value: 1},
+			{LabelKeys: []string{"management_cluster", "namespace", "cluster"}, LabelValues: []string{"mc2", "ns1", "c1"}, Value: 2},
+		},
+	}
+	filter := MetricsFilter{ManagementCluster: "mc2", Namespace: "ns1", Cluster: "c1"}
+	want := "capi_test_info{management_cluster=\"mc2\",namespace=\"ns1\",cluster=\"c1\"} 2\n"
+	if got := string(filter.filterFamily(family, []string{"machines"}).ByteSlice()); got != want {
+		t.Errorf("unexpected result of the management cluster, want:\n%s\ngot:\n%s", want, got)
+	}
+	// the permissions are resolved per management cluster
+	filter = MetricsFilter{Allowed: map[string]Permissions{"mc1": {"machines": {"": true}}, "mc2": {"machines": {"ns2": true}}}}
+	want = "capi_test_info{management_cluster=\"mc1\",namespace=\"ns1\",cluster=\"c1\"} 1\n"
+	if got := string(filter.filterFamily(family, []string{"machines"}).ByteSlice()); got != want {
+		t.Errorf("unexpected result of the permissions of the management clusters, want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestCrossResourceMetricsWriterFilteredByCluster(t *testing.T) {
+	newMachine := func(name, clusterName string) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1", UID: types.UID(name)},
+			Spec:       clusterv1.MachineSpec{ClusterName: clusterName},
+			Status:     clusterv1.MachineStatus{Phase: string(clusterv1.MachinePhaseRunning)},
+		}
+	}
+	f := NewMachineStuckFactory(time.Hour, time.Hour)
+	w := newCrossResourceMetricsWriter(f.Name(), f.Resources(), f.MetricFamilyGenerators(nil, nil), newObjects(map[string][]interface{}{
+		"machines": {newMachine("m1", "c1"), newMachine("m2", "c2")},
+	}))
+
+	buf := &bytes.Buffer{}
+	w.WriteFiltered(buf, MetricsFilter{Namespace: "ns1", Cluster: "c1"})
+	want := `# HELP capi_machine_stuck The machine did not finish its provisioning or deletion within the threshold.
+# TYPE capi_machine_stuck gauge
+capi_machine_stuck{namespace="ns1",machine="m1",uid="m1",cluster="c1",phase="Running"} 0
+`
+	if got := buf.String(); got != want {
+		t.Errorf("expected the metrics of the machines of the cluster, want:\n%s\ngot:\n%s", want, got)
+	}
+}
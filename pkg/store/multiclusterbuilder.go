@@ -0,0 +1,254 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	vpaclientset "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	ksmtypes "k8s.io/kube-state-metrics/v2/pkg/builder/types"
+	"k8s.io/kube-state-metrics/v2/pkg/customresource"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	metricsstore "k8s.io/kube-state-metrics/v2/pkg/metrics_store"
+	"k8s.io/kube-state-metrics/v2/pkg/options"
+	"k8s.io/kube-state-metrics/v2/pkg/sharding"
+	"k8s.io/kube-state-metrics/v2/pkg/watch"
+)
+
+// Make sure the MultiClusterBuilder implements the kube-state-metrics BuilderInterface.
+var _ ksmtypes.BuilderInterface = &MultiClusterBuilder{}
+
+// Make sure the MultiClusterBuilder implements the Rebuilder.
+var _ Rebuilder = &MultiClusterBuilder{}
+
+// MultiClusterBuilder combines the Builders of multiple management clusters,
+// so a single metrics handler exposes the metrics of all management clusters.
+// Each Builder has to be configured with its own clients, cross resource
+// factories and management cluster name. All other properties are passed to
+// every Builder.
+type MultiClusterBuilder struct {
+	builders []*Builder
+}
+
+// NewMultiClusterBuilder returns a new MultiClusterBuilder for the given builders.
+func NewMultiClusterBuilder(builders ...*Builder) *MultiClusterBuilder {
+	return &MultiClusterBuilder{
+		builders: builders,
+	}
+}
+
+// WithMetrics registers the metrics once and shares them between all builders.
+func (m *MultiClusterBuilder) WithMetrics(r prometheus.Registerer) {
+	listWatchMetrics := watch.NewListWatchMetrics(r)
+	shardingMetrics := sharding.NewShardingMetrics(r)
+	exporterMetrics := NewExporterMetrics(r)
+	for _, b := range m.builders {
+		b.listWatchMetrics = listWatchMetrics
+		b.shardingMetrics = shardingMetrics
+		b.exporterMetrics = exporterMetrics
+	}
+}
+
+// WithHealth configures the Health of all builders, so it tracks the
+// reflectors of all management clusters.
+func (m *MultiClusterBuilder) WithHealth(h *Health) {
+	for _, b := range m.builders {
+		b.WithHealth(h)
+	}
+}
+
+// WithEnabledResources sets the enabledResources property of all builders.
+func (m *MultiClusterBuilder) WithEnabledResources(r []string) error {
+	for _, b := range m.builders {
+		if err := b.WithEnabledResources(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithNamespaces sets the namespaces property of all builders.
+func (m *MultiClusterBuilder) WithNamespaces(n options.NamespaceList, nsFilter string) {
+	for _, b := range m.builders {
+		b.WithNamespaces(n, nsFilter)
+	}
+}
+
+// WithResourceNamespaces overrides the namespaces of single resources of all builders.
+func (m *MultiClusterBuilder) WithResourceNamespaces(n map[string]options.NamespaceList) {
+	for _, b := range m.builders {
+		b.WithResourceNamespaces(n)
+	}
+}
+
+// WithSharding sets the shard and totalShards property of all builders.
+func (m *MultiClusterBuilder) WithSharding(shard int32, totalShards int) {
+	for _, b := range m.builders {
+		b.WithSharding(shard, totalShards)
+	}
+}
+
+// WithListPageSize sets the listPageSize property of all builders.
+func (m *MultiClusterBuilder) WithListPageSize(pageSize int64) {
+	for _, b := range m.builders {
+		b.WithListPageSize(pageSize)
+	}
+}
+
+// WithContext sets the ctx property of all builders.
+func (m *MultiClusterBuilder) WithContext(ctx context.Context) {
+	for _, b := range m.builders {
+		b.WithContext(ctx)
+	}
+}
+
+// WithKubeClient is a no-op, as each builder has the client of its management cluster.
+func (m *MultiClusterBuilder) WithKubeClient(c clientset.Interface) {}
+
+// WithVPAClient is a no-op, as the Builder only supports custom resource stores.
+func (m *MultiClusterBuilder) WithVPAClient(c vpaclientset.Interface) {}
+
+// WithCustomResourceClients is a no-op, as each builder has the clients of its
+// management cluster.
+func (m *MultiClusterBuilder) WithCustomResourceClients(cs map[string]interface{}) {}
+
+// WithUsingAPIServerCache configures whether all builders use the APIServer cache or not.
+func (m *MultiClusterBuilder) WithUsingAPIServerCache(u bool) {
+	for _, b := range m.builders {
+		b.WithUsingAPIServerCache(u)
+	}
+}
+
+// WithFamilyGeneratorFilter configures the family generator filter of all builders.
+func (m *MultiClusterBuilder) WithFamilyGeneratorFilter(l generator.FamilyGeneratorFilter) {
+	for _, b := range m.builders {
+		b.WithFamilyGeneratorFilter(l)
+	}
+}
+
+// WithClusterSelector configures the label selector of the clusters of all builders.
+func (m *MultiClusterBuilder) WithClusterSelector(selector labels.Selector) {
+	for _, b := range m.builders {
+		b.WithClusterSelector(selector)
+	}
+}
+
+// WithAllowAnnotations configures which annotations can be returned for metrics.
+func (m *MultiClusterBuilder) WithAllowAnnotations(annotations map[string][]string) {
+	for _, b := range m.builders {
+		b.WithAllowAnnotations(annotations)
+	}
+}
+
+// WithAllowLabels configures which labels can be returned for metrics.
+func (m *MultiClusterBuilder) WithAllowLabels(labels map[string][]string) {
+	for _, b := range m.builders {
+		b.WithAllowLabels(labels)
+	}
+}
+
+// WithGenerateStoresFunc is a no-op, as the Builder only supports custom resource stores.
+func (m *MultiClusterBuilder) WithGenerateStoresFunc(f ksmtypes.BuildStoresFunc) {}
+
+// WithGenerateCustomResourceStoresFunc configures the generate custom resource
+// store function of all builders. If it is nil, every builder uses its default
+// function.
+func (m *MultiClusterBuilder) WithGenerateCustomResourceStoresFunc(f ksmtypes.BuildCustomResourceStoresFunc) {
+	for _, b := range m.builders {
+		if f == nil {
+			b.WithGenerateCustomResourceStoresFunc(b.DefaultGenerateCustomResourceStoresFunc())
+			continue
+		}
+		b.WithGenerateCustomResourceStoresFunc(f)
+	}
+}
+
+// DefaultGenerateStoresFunc returns nil, as the Builder only supports custom resource stores.
+func (m *MultiClusterBuilder) DefaultGenerateStoresFunc() ksmtypes.BuildStoresFunc {
+	return nil
+}
+
+// DefaultGenerateCustomResourceStoresFunc returns nil, as the default function
+// is bound to the client of each builder. Passing nil to
+// WithGenerateCustomResourceStoresFunc configures the default of each builder.
+func (m *MultiClusterBuilder) DefaultGenerateCustomResourceStoresFunc() ksmtypes.BuildCustomResourceStoresFunc {
+	return nil
+}
+
+// WithCustomResourceStoreFactories configures the custom resource store
+// factories of all builders.
+func (m *MultiClusterBuilder) WithCustomResourceStoreFactories(fs ...customresource.RegistryFactory) {
+	for _, b := range m.builders {
+		b.WithCustomResourceStoreFactories(fs...)
+	}
+}
+
+// Build initializes and registers all enabled stores of all builders. The
+// stores of the same resource and the writers of the same cross resource
+// factory are combined, so the help text of each metric family is only written
+// once and the metrics of all management clusters are grouped together.
+func (m *MultiClusterBuilder) Build() []metricsstore.MetricsWriter {
+	return m.build(false)
+}
+
+// Rebuild applies the changed configuration to the stores of the last Build of
+// all builders. The stores of a resource are only rebuilt if its list and
+// watch settings changed, otherwise the metrics of its objects are
+// regenerated.
+func (m *MultiClusterBuilder) Rebuild() []metricsstore.MetricsWriter {
+	return m.build(true)
+}
+
+func (m *MultiClusterBuilder) build(rebuild bool) []metricsstore.MetricsWriter {
+	var resourceNames []string
+	stores := map[string][]*MetricsStore{}
+	var crossResourceNames []string
+	crossResourceWriters := map[string][]*crossResourceMetricsWriter{}
+
+	for _, b := range m.builders {
+		resourceStores, writers := b.build(rebuild)
+		for _, r := range resourceStores {
+			if _, ok := stores[r.name]; !ok {
+				resourceNames = append(resourceNames, r.name)
+			}
+			stores[r.name] = append(stores[r.name], r.stores...)
+		}
+		for _, w := range writers {
+			if _, ok := crossResourceWriters[w.name]; !ok {
+				crossResourceNames = append(crossResourceNames, w.name)
+			}
+			crossResourceWriters[w.name] = append(crossResourceWriters[w.name], w)
+		}
+	}
+
+	var metricsWriters []metricsstore.MetricsWriter
+	for _, name := range resourceNames {
+		if len(stores[name]) == 1 {
+			metricsWriters = append(metricsWriters, stores[name][0])
+		} else {
+			metricsWriters = append(metricsWriters, NewMultiStoreMetricsWriter(stores[name]))
+		}
+	}
+	for _, name := range crossResourceNames {
+		if len(crossResourceWriters[name]) == 1 {
+			metricsWriters = append(metricsWriters, crossResourceWriters[name][0])
+		} else {
+			metricsWriters = append(metricsWriters, &multiCrossResourceMetricsWriter{writers: crossResourceWriters[name]})
+		}
+	}
+
+	return metricsWriters
+}
+
+// BuildStores initializes and registers all enabled stores of all builders.
+func (m *MultiClusterBuilder) BuildStores() [][]cache.Store {
+	var allStores [][]cache.Store
+	for _, b := range m.builders {
+		allStores = append(allStores, b.BuildStores()...)
+	}
+	return allStores
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestMultiCrossResourceMetricsWriter(t *testing.T) {
+	families := []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_test_clusters",
+			"Number of clusters.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				return &metric.Family{
+					Metrics: []*metric.Metric{
+						{
+							LabelKeys:   []string{"namespace"},
+							LabelValues: []string{"ns1"},
+							Value:       float64(len(o.List("clusters"))),
+						},
+					},
+				}
+			}),
+		),
+	}
+
+	newCluster := func(name string) *clusterv1.Cluster {
+		return &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1"}}
+	}
+
+	eu := NewBuilder()
+	eu.WithManagementCluster("eu")
+	us := NewBuilder()
+	us.WithManagementCluster("us")
+
+	w := &multiCrossResourceMetricsWriter{
+		writers: []*crossResourceMetricsWriter{
+			newCrossResourceMetricsWriter("test", []string{"clusters"}, eu.withManagementClusterLabel(families), newObjects(map[string][]interface{}{
+				"clusters": {newCluster("c1")},
+			})),
+			newCrossResourceMetricsWriter("test", []string{"clusters"}, us.withManagementClusterLabel(families), newObjects(map[string][]interface{}{
+				"clusters": {newCluster("c1"), newCluster("c2")},
+			})),
+		},
+	}
+
+	want := `# HELP capi_test_clusters Number of clusters.
+# TYPE capi_test_clusters gauge
+capi_test_clusters{management_cluster="eu",namespace="ns1"} 1
+capi_test_clusters{management_cluster="us",namespace="ns1"} 2
+`
+
+	buf := &bytes.Buffer{}
+	w.WriteAll(buf)
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output, want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestWithManagementClusterLabel(t *testing.T) {
+	families := []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_test_info",
+			"Test info.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				return &metric.Family{
+					Metrics: []*metric.Metric{
+						{
+							Value: 1,
+						},
+					},
+				}
+			}),
+		),
+	}
+
+	b := NewBuilder()
+	if got := b.withManagementClusterLabel(families)[0].GenerateFunc(Objects{}).Metrics[0].LabelKeys; len(got) != 0 {
+		t.Errorf("expected no labels without a management cluster, got %v", got)
+	}
+
+	b.WithManagementCluster("eu")
+	m := b.withManagementClusterLabel(families)[0].GenerateFunc(Objects{}).Metrics[0]
+	if len(m.LabelKeys) != 1 || m.LabelKeys[0] != "management_cluster" || m.LabelValues[0] != "eu" {
+		t.Errorf("expected management_cluster label eu, got %v=%v", m.LabelKeys, m.LabelValues)
+	}
+
+	// the original generators are not modified
+	if got := families[0].GenerateFunc(Objects{}).Metrics[0].LabelKeys; len(got) != 0 {
+		t.Errorf("expected original generator without labels, got %v", got)
+	}
+}
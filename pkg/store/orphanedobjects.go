@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha4"
+)
+
+const (
+	// orphanReasonOwnerMissing is used for objects whose owning machineset or
+	// machinedeployment does not exist.
+	orphanReasonOwnerMissing = "owner_missing"
+	// orphanReasonClusterMissing is used for objects whose cluster does not exist.
+	orphanReasonClusterMissing = "cluster_missing"
+	// orphanReasonControlPlaneMissing is used for clusters whose referenced
+	// kubeadmcontrolplane does not exist.
+	orphanReasonControlPlaneMissing = "control_plane_missing"
+	// orphanReasonClusterNameLabelMismatch is used for objects whose cluster
+	// name label differs from their Spec.ClusterName.
+	orphanReasonClusterNameLabelMismatch = "cluster_name_label_mismatch"
+)
+
+// orphanedObjectKinds holds all checked combinations of kind and reason, so
+// they are exposed even if no broken reference was found.
+var orphanedObjectKinds = []struct {
+	kind   string
+	reason string
+}{
+	{"Cluster", orphanReasonControlPlaneMissing},
+	{"MachineDeployment", orphanReasonClusterMissing},
+	{"MachineDeployment", orphanReasonClusterNameLabelMismatch},
+	{"MachineSet", orphanReasonClusterMissing},
+	{"MachineSet", orphanReasonClusterNameLabelMismatch},
+	{"MachineSet", orphanReasonOwnerMissing},
+	{"Machine", orphanReasonClusterMissing},
+	{"Machine", orphanReasonClusterNameLabelMismatch},
+	{"Machine", orphanReasonOwnerMissing},
+}
+
+// OrphanedObjectsFactory generates metrics about cluster api objects with
+// broken references to other objects, as they may be left over by a failed
+// `clusterctl move`.
+type OrphanedObjectsFactory struct{}
+
+func (f *OrphanedObjectsFactory) Name() string {
+	return "orphanedobjects"
+}
+
+func (f *OrphanedObjectsFactory) Resources() []string {
+	return []string{"clusters", "kubeadmcontrolplanes", "machinedeployments", "machinesets", "machines"}
+}
+
+func (f *OrphanedObjectsFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_orphaned_objects",
+			"The number of cluster api objects with a reference to a missing object or an inconsistent cluster name.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				// Objects would be reported as orphaned while the objects
+				// they reference are not listed yet.
+				if !o.HasSynced(f.Resources()...) {
+					return &metric.Family{}
+				}
+
+				counts := getOrphanedObjectCounts(o)
+				ms := make([]*metric.Metric, len(orphanedObjectKinds))
+
+				for i, k := range orphanedObjectKinds {
+					ms[i] = &metric.Metric{
+						LabelKeys:   []string{"kind", "reason"},
+						LabelValues: []string{k.kind, k.reason},
+						Value:       float64(counts[k.kind+"/"+k.reason]),
+					}
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+	}
+}
+
+// getOrphanedObjectCounts returns the number of objects with a broken
+// reference, keyed by kind and reason.
+func getOrphanedObjectCounts(o Objects) map[string]int {
+	counts := map[string]int{}
+
+	clusters := map[string]bool{}
+	for _, obj := range o.List("clusters") {
+		c := obj.(*clusterv1.Cluster)
+		clusters[c.Namespace+"/"+c.Name] = true
+	}
+
+	kcps := map[string]bool{}
+	for _, obj := range o.List("kubeadmcontrolplanes") {
+		kcp := obj.(*controlplanev1.KubeadmControlPlane)
+		kcps[kcp.Namespace+"/"+kcp.Name] = true
+	}
+
+	for _, obj := range o.List("clusters") {
+		c := obj.(*clusterv1.Cluster)
+		ref := c.Spec.ControlPlaneRef
+		// Only kubeadmcontrolplanes are cached, other control plane providers can't be checked.
+		if ref == nil || ref.Kind != "KubeadmControlPlane" {
+			continue
+		}
+		ns := ref.Namespace
+		if ns == "" {
+			ns = c.Namespace
+		}
+		if !kcps[ns+"/"+ref.Name] {
+			counts["Cluster/"+orphanReasonControlPlaneMissing]++
+		}
+	}
+
+	// checkCluster counts objects with a missing cluster or a mismatching cluster name label.
+	checkCluster := func(kind string, meta metav1.ObjectMeta, clusterName string) {
+		if !clusters[meta.Namespace+"/"+clusterName] {
+			counts[kind+"/"+orphanReasonClusterMissing]++
+		}
+		if label, ok := meta.Labels[clusterv1.ClusterLabelName]; ok && label != clusterName {
+			counts[kind+"/"+orphanReasonClusterNameLabelMismatch]++
+		}
+	}
+
+	machineDeployments := map[types.UID]bool{}
+	for _, obj := range o.List("machinedeployments") {
+		md := obj.(*clusterv1.MachineDeployment)
+		machineDeployments[md.UID] = true
+		checkCluster("MachineDeployment", md.ObjectMeta, md.Spec.ClusterName)
+	}
+
+	machineSets := map[types.UID]bool{}
+	for _, obj := range o.List("machinesets") {
+		ms := obj.(*clusterv1.MachineSet)
+		machineSets[ms.UID] = true
+		checkCluster("MachineSet", ms.ObjectMeta, ms.Spec.ClusterName)
+		if ownerMissing(ms.OwnerReferences, "MachineDeployment", machineDeployments) {
+			counts["MachineSet/"+orphanReasonOwnerMissing]++
+		}
+	}
+
+	for _, obj := range o.List("machines") {
+		m := obj.(*clusterv1.Machine)
+		checkCluster("Machine", m.ObjectMeta, m.Spec.ClusterName)
+		if ownerMissing(m.OwnerReferences, "MachineSet", machineSets) {
+			counts["Machine/"+orphanReasonOwnerMissing]++
+		}
+	}
+
+	return counts
+}
+
+// ownerMissing returns true if one of the owner references points to a cluster
+// api object of the given kind which does not exist. Like the garbage
+// collector, owners are identified by their UID. The version of the owner
+// reference is ignored, so references written by newer api versions match.
+func ownerMissing(refs []metav1.OwnerReference, kind string, owners map[types.UID]bool) bool {
+	for _, ref := range refs {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil || gv.Group != clusterv1.GroupVersion.Group || ref.Kind != kind {
+			continue
+		}
+		if !owners[ref.UID] {
+			return true
+		}
+	}
+	return false
+}
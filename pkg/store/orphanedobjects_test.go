@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha4"
+)
+
+func TestOrphanedObjectsStore(t *testing.T) {
+	newObjectMeta := func(name, clusterNameLabel string, owner *metav1.OwnerReference) metav1.ObjectMeta {
+		meta := metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns1",
+			UID:       types.UID(name),
+			Labels:    map[string]string{},
+		}
+		if clusterNameLabel != "" {
+			meta.Labels[clusterv1.ClusterLabelName] = clusterNameLabel
+		}
+		if owner != nil {
+			meta.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return meta
+	}
+	newOwnerReference := func(apiVersion, kind, uid string) *metav1.OwnerReference {
+		return &metav1.OwnerReference{
+			APIVersion: apiVersion,
+			Kind:       kind,
+			Name:       uid,
+			UID:        types.UID(uid),
+		}
+	}
+	newCluster := func(name, controlPlane string) *clusterv1.Cluster {
+		return &clusterv1.Cluster{
+			ObjectMeta: newObjectMeta(name, "", nil),
+			Spec: clusterv1.ClusterSpec{
+				ControlPlaneRef: &corev1.ObjectReference{
+					Kind: "KubeadmControlPlane",
+					Name: controlPlane,
+				},
+			},
+		}
+	}
+	newMachineSet := func(name, clusterName, clusterNameLabel string, owner *metav1.OwnerReference) *clusterv1.MachineSet {
+		return &clusterv1.MachineSet{
+			ObjectMeta: newObjectMeta(name, clusterNameLabel, owner),
+			Spec: clusterv1.MachineSetSpec{
+				ClusterName: clusterName,
+			},
+		}
+	}
+	newMachine := func(name, clusterName, clusterNameLabel string, owner *metav1.OwnerReference) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: newObjectMeta(name, clusterNameLabel, owner),
+			Spec: clusterv1.MachineSpec{
+				ClusterName: clusterName,
+			},
+		}
+	}
+
+	f := &OrphanedObjectsFactory{}
+	tc := generateMetricsTestCase{
+		Obj: newObjects(map[string][]interface{}{
+			"clusters": {
+				newCluster("c1", "c1-control-plane"),
+				newCluster("c2", "c2-control-plane"),
+			},
+			"kubeadmcontrolplanes": {
+				&controlplanev1.KubeadmControlPlane{
+					ObjectMeta: newObjectMeta("c1-control-plane", "c1", nil),
+				},
+			},
+			"machinedeployments": {
+				&clusterv1.MachineDeployment{
+					ObjectMeta: newObjectMeta("md1", "c1", nil),
+					Spec: clusterv1.MachineDeploymentSpec{
+						ClusterName: "c1",
+					},
+				},
+			},
+			"machinesets": {
+				newMachineSet("ms1", "c1", "c1", newOwnerReference("cluster.x-k8s.io/v1alpha4", "MachineDeployment", "md1")),
+				newMachineSet("ms2", "c1", "c1", newOwnerReference("cluster.x-k8s.io/v1beta1", "MachineDeployment", "md2")),
+				newMachineSet("ms3", "c3", "c3", nil),
+			},
+			"machines": {
+				newMachine("m1", "c1", "c1", newOwnerReference("cluster.x-k8s.io/v1alpha4", "MachineSet", "ms1")),
+				newMachine("m2", "c1", "c2", newOwnerReference("cluster.x-k8s.io/v1alpha4", "MachineSet", "ms4")),
+				newMachine("m3", "c1", "", newOwnerReference("controlplane.cluster.x-k8s.io/v1alpha4", "KubeadmControlPlane", "c1-control-plane")),
+				newMachine("m4", "c3", "c3", newOwnerReference("infrastructure.cluster.x-k8s.io/v1alpha4", "MachineSet", "ms5")),
+			},
+		}),
+		Want: `
+			# HELP capi_orphaned_objects The number of cluster api objects with a reference to a missing object or an inconsistent cluster name.
+			# TYPE capi_orphaned_objects gauge
+			capi_orphaned_objects{kind="Cluster",reason="control_plane_missing"} 1
+			capi_orphaned_objects{kind="MachineDeployment",reason="cluster_missing"} 0
+			capi_orphaned_objects{kind="MachineDeployment",reason="cluster_name_label_mismatch"} 0
+			capi_orphaned_objects{kind="MachineSet",reason="cluster_missing"} 1
+			capi_orphaned_objects{kind="MachineSet",reason="cluster_name_label_mismatch"} 0
+			capi_orphaned_objects{kind="MachineSet",reason="owner_missing"} 1
+			capi_orphaned_objects{kind="Machine",reason="cluster_missing"} 1
+			capi_orphaned_objects{kind="Machine",reason="cluster_name_label_mismatch"} 1
+			capi_orphaned_objects{kind="Machine",reason="owner_missing"} 1
+		`,
+		Func:    generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil)),
+		Headers: generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil)),
+	}
+	if err := tc.run(); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestOrphanedObjectsStoreWaitsForSync(t *testing.T) {
+	f := &OrphanedObjectsFactory{}
+	objects := Objects{}
+	for _, r := range f.Resources() {
+		objects[r] = []cache.Store{newSyncingStore()}
+	}
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "ns1"},
+		Spec: clusterv1.MachineSpec{
+			ClusterName: "c1",
+		},
+	}
+	if err := objects["machines"][0].Replace([]interface{}{machine}, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tc := generateMetricsTestCase{
+		Obj: objects,
+		Want: `
+			# HELP capi_orphaned_objects The number of cluster api objects with a reference to a missing object or an inconsistent cluster name.
+			# TYPE capi_orphaned_objects gauge
+		`,
+		Func:    generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil)),
+		Headers: generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil)),
+	}
+	if err := tc.run(); err != nil {
+		t.Errorf("expected no metrics before the clusters are listed:\n%s", err)
+	}
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1"}}
+	for _, r := range f.Resources() {
+		list := []interface{}{}
+		switch r {
+		case "clusters":
+			list = append(list, cluster)
+		case "machines":
+			list = append(list, machine)
+		}
+		if err := objects[r][0].Replace(list, "2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tc.Want = `
+		# HELP capi_orphaned_objects The number of cluster api objects with a reference to a missing object or an inconsistent cluster name.
+		# TYPE capi_orphaned_objects gauge
+		capi_orphaned_objects{kind="Cluster",reason="control_plane_missing"} 0
+		capi_orphaned_objects{kind="MachineDeployment",reason="cluster_missing"} 0
+		capi_orphaned_objects{kind="MachineDeployment",reason="cluster_name_label_mismatch"} 0
+		capi_orphaned_objects{kind="MachineSet",reason="cluster_missing"} 0
+		capi_orphaned_objects{kind="MachineSet",reason="cluster_name_label_mismatch"} 0
+		capi_orphaned_objects{kind="MachineSet",reason="owner_missing"} 0
+		capi_orphaned_objects{kind="Machine",reason="cluster_missing"} 0
+		capi_orphaned_objects{kind="Machine",reason="cluster_name_label_mismatch"} 0
+		capi_orphaned_objects{kind="Machine",reason="owner_missing"} 0
+	`
+	if err := tc.run(); err != nil {
+		t.Errorf("unexpected collecting result after the stores synced:\n%s", err)
+	}
+}
@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+var descSecretLabelsDefaultLabels = []string{"namespace", "secret", "uid"}
+
+// SecretFactory generates metrics for the secrets managed by cluster api, like
+// the kubeconfig and the certificate authorities of a cluster. Only secrets with
+// the cluster name label are watched.
+type SecretFactory struct {
+	*ControllerRuntimeClientFactory
+}
+
+func (f *SecretFactory) Name() string {
+	return "secrets"
+}
+
+func (f *SecretFactory) ExpectedType() interface{} {
+	return &corev1.Secret{}
+}
+
+func (f *SecretFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_cluster_certificate_expiry_seconds",
+			"Unix timestamp when a certificate of a cluster api managed secret expires.",
+			metric.Gauge,
+			"",
+			wrapSecretFunc(func(s *corev1.Secret) *metric.Family {
+				ms := []*metric.Metric{}
+
+				clusterName, purpose, err := secret.ParseSecretName(s.Name)
+				if err != nil || clusterName != s.Labels[clusterv1.ClusterLabelName] {
+					return &metric.Family{
+						Metrics: ms,
+					}
+				}
+
+				expiries := getSecretCertificateExpiries(s)
+				subjects := make([]string, 0, len(expiries))
+				for subject := range expiries {
+					subjects = append(subjects, subject)
+				}
+				sort.Strings(subjects)
+
+				for _, subject := range subjects {
+					ms = append(ms, &metric.Metric{
+						LabelKeys:   []string{"cluster", "purpose", "certificate"},
+						LabelValues: []string{clusterName, string(purpose), subject},
+						Value:       float64(expiries[subject].Unix()),
+					})
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+	}
+}
+
+func (f *SecretFactory) ListWatch(customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+	return f.ListWatchWithContext(context.Background(), customResourceClient, ns, fieldSelector)
+}
+
+func (f *SecretFactory) ListWatchWithContext(ctx context.Context, customResourceClient interface{}, ns string, fieldSelector string) cache.ListerWatcher {
+	ctrlClient := customResourceClient.(client.WithWatch)
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			secretList := corev1.SecretList{}
+			opts.FieldSelector = fieldSelector
+			opts.LabelSelector = clusterv1.ClusterLabelName
+			err := ctrlClient.List(ctx, &secretList, &client.ListOptions{Raw: &opts, Namespace: ns, Limit: opts.Limit, Continue: opts.Continue})
+			return &secretList, err
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			secretList := corev1.SecretList{}
+			opts.FieldSelector = fieldSelector
+			opts.LabelSelector = clusterv1.ClusterLabelName
+			return ctrlClient.Watch(ctx, &secretList, &client.ListOptions{Raw: &opts, Namespace: ns})
+		},
+	}
+}
+
+// TransformObject removes the data of the secret except for the certificates,
+// which are read to get their expiry. The kubeconfig of kubeconfig secrets is
+// replaced by the certificates it embeds, so neither the private keys nor the
+// tokens of a secret are kept in memory.
+func (f *SecretFactory) TransformObject(obj interface{}) {
+	s := obj.(*corev1.Secret)
+	stripObjectMeta(s)
+	certs := getSecretCertificates(s)
+	s.Data = nil
+	if len(certs) > 0 {
+		s.Data = map[string][]byte{secret.TLSCrtDataName: certs}
+	}
+}
+
+// getSecretCertificates returns the PEM encoded certificates of the secret.
+// Kubeconfig secrets which were not transformed yet contain the certificate
+// authority and the client certificate in the kubeconfig, all other secrets
+// contain the certificates in the tls.crt key.
+func getSecretCertificates(s *corev1.Secret) []byte {
+	kubeconfig, ok := s.Data[secret.KubeconfigDataName]
+	if _, purpose, err := secret.ParseSecretName(s.Name); err != nil || purpose != secret.Kubeconfig || !ok {
+		return s.Data[secret.TLSCrtDataName]
+	}
+
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		klog.Errorf("Failed to parse kubeconfig of secret %s/%s: %v", s.Namespace, s.Name, err)
+		return nil
+	}
+	var certs []byte
+	for _, c := range config.Clusters {
+		certs = appendPEM(certs, c.CertificateAuthorityData)
+	}
+	for _, a := range config.AuthInfos {
+		certs = appendPEM(certs, a.ClientCertificateData)
+	}
+	return certs
+}
+
+// appendPEM appends the PEM encoded data, so its blocks start on a new line.
+func appendPEM(data, block []byte) []byte {
+	if len(block) == 0 {
+		return data
+	}
+	data = append(data, block...)
+	if block[len(block)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	return data
+}
+
+// getSecretCertificateExpiries returns the expiry of the certificates in the
+// secret by their subject common name. If multiple certificates have the same
+// common name, the earliest expiry is returned.
+func getSecretCertificateExpiries(s *corev1.Secret) map[string]time.Time {
+	expiries := map[string]time.Time{}
+	for _, cert := range parseCertificates(getSecretCertificates(s)) {
+		if expiry, ok := expiries[cert.Subject.CommonName]; !ok || cert.NotAfter.Before(expiry) {
+			expiries[cert.Subject.CommonName] = cert.NotAfter
+		}
+	}
+	return expiries
+}
+
+// parseCertificates returns all certificates of the PEM encoded data. Blocks
+// which are no certificates or can't be parsed are skipped.
+func parseCertificates(data []byte) []*x509.Certificate {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func wrapSecretFunc(f func(*corev1.Secret) *metric.Family) func(interface{}) *metric.Family {
+	return func(obj interface{}) (metricFamily *metric.Family) {
+		secret := obj.(*corev1.Secret)
+		defer recoverFamily(&metricFamily, "secret", secret)
+
+		metricFamily = f(secret)
+		if metricFamily == nil {
+			return nil
+		}
+
+		for _, m := range metricFamily.Metrics {
+			m.LabelKeys = append(descSecretLabelsDefaultLabels, m.LabelKeys...)
+			m.LabelValues = append([]string{secret.Namespace, secret.Name, string(secret.UID)}, m.LabelValues...)
+		}
+
+		return metricFamily
+	}
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestSecretStore(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCertificate := func(commonName string, notAfter int64) []byte {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: commonName},
+			NotBefore:    time.Unix(1501569018, 0),
+			NotAfter:     time.Unix(notAfter, 0),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	newSecret := func(name, clusterName string, data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns1",
+				UID:       types.UID("foo"),
+				Labels: map[string]string{
+					clusterv1.ClusterLabelName: clusterName,
+				},
+			},
+			Data: data,
+		}
+	}
+
+	kubeconfig, err := clientcmd.Write(clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{
+			"c1": {CertificateAuthorityData: newCertificate("kubernetes", 1900000000)},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			"c1-admin": {
+				ClientCertificateData: newCertificate("kubernetes-admin", 1700000000),
+				ClientKeyData:         pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []generateMetricsTestCase{
+		{
+			Obj: newSecret("c1-kubeconfig", "c1", map[string][]byte{"value": kubeconfig}),
+			Want: `
+				# HELP capi_cluster_certificate_expiry_seconds Unix timestamp when a certificate of a cluster api managed secret expires.
+				# TYPE capi_cluster_certificate_expiry_seconds gauge
+				capi_cluster_certificate_expiry_seconds{certificate="kubernetes",cluster="c1",namespace="ns1",purpose="kubeconfig",secret="c1-kubeconfig",uid="foo"} 1.9e+09
+				capi_cluster_certificate_expiry_seconds{certificate="kubernetes-admin",cluster="c1",namespace="ns1",purpose="kubeconfig",secret="c1-kubeconfig",uid="foo"} 1.7e+09
+			`,
+			MetricNames: []string{"capi_cluster_certificate_expiry_seconds"},
+		},
+		{
+			Obj: newSecret("c1-etcd", "c1", map[string][]byte{
+				"tls.crt": append(newCertificate("etcd-ca", 1800000000), newCertificate("etcd-ca", 1750000000)...),
+				"tls.key": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			}),
+			Want: `
+				# HELP capi_cluster_certificate_expiry_seconds Unix timestamp when a certificate of a cluster api managed secret expires.
+				# TYPE capi_cluster_certificate_expiry_seconds gauge
+				capi_cluster_certificate_expiry_seconds{certificate="etcd-ca",cluster="c1",namespace="ns1",purpose="etcd",secret="c1-etcd",uid="foo"} 1.75e+09
+			`,
+			MetricNames: []string{"capi_cluster_certificate_expiry_seconds"},
+		},
+		{
+			Obj: newSecret("c1-sa", "c1", map[string][]byte{
+				"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("foo")}),
+			}),
+			Want: `
+				# HELP capi_cluster_certificate_expiry_seconds Unix timestamp when a certificate of a cluster api managed secret expires.
+				# TYPE capi_cluster_certificate_expiry_seconds gauge
+			`,
+			MetricNames: []string{"capi_cluster_certificate_expiry_seconds"},
+		},
+		{
+			Obj: newSecret("c1-user-kubeconfig", "c1", map[string][]byte{"value": kubeconfig}),
+			Want: `
+				# HELP capi_cluster_certificate_expiry_seconds Unix timestamp when a certificate of a cluster api managed secret expires.
+				# TYPE capi_cluster_certificate_expiry_seconds gauge
+			`,
+			MetricNames: []string{"capi_cluster_certificate_expiry_seconds"},
+		},
+	}
+	for i, c := range cases {
+		f := SecretFactory{}
+		c.Func = generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil))
+		c.Headers = generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil))
+		if err := c.run(); err != nil {
+			t.Errorf("unexpected collecting result in %vth run:\n%s", i, err)
+		}
+
+		// The metrics of the transformed secrets must not change.
+		s := c.Obj.(*corev1.Secret).DeepCopy()
+		f.TransformObject(s)
+		for k, v := range s.Data {
+			if k != "tls.crt" || bytes.Contains(v, []byte("PRIVATE KEY")) {
+				t.Errorf("expected only the certificates to be kept in the %vth run, got %s: %s", i, k, v)
+			}
+		}
+		c.Obj = s
+		if err := c.run(); err != nil {
+			t.Errorf("unexpected collecting result of the transformed secret in %vth run:\n%s", i, err)
+		}
+	}
+}
@@ -41,6 +41,8 @@ type generateMetricsTestCase struct {
 	Want            string
 	Headers         []string
 	Func            func(interface{}) []metric.FamilyInterface
+	// GenerationErrors are the expected generation errors by metric name.
+	GenerationErrors map[string]float64
 }
 
 func (testCase *generateMetricsTestCase) run() error {
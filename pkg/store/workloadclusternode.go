@@ -0,0 +1,358 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-state-metrics/v2/pkg/metric"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/cluster-api/util/secret"
+)
+
+const (
+	// workloadClusterTimeout is the timeout to get the nodes of a single workload cluster.
+	workloadClusterTimeout = 10 * time.Second
+	// workloadClusterConcurrency is the maximum number of workload clusters
+	// whose nodes are listed at the same time.
+	workloadClusterConcurrency = 10
+)
+
+// WorkloadClusterNodeFactory generates metrics which compare the nodes of the
+// workload clusters with the machines of the management cluster. The nodes are
+// listed periodically using the kubeconfig secret of each cluster.
+type WorkloadClusterNodeFactory struct {
+	// SyncInterval is the interval in which the nodes of the workload clusters
+	// are listed.
+	SyncInterval time.Duration
+
+	kubeClient  clientset.Interface
+	newClient   func(kubeconfig []byte) (clientset.Interface, error)
+	concurrency int
+	mtx         sync.Mutex
+	clusters    map[string]*workloadCluster
+}
+
+// workloadCluster is the last observed state of a workload cluster.
+type workloadCluster struct {
+	namespace string
+	name      string
+	uid       types.UID
+	// secretResourceVersion is the resource version of the kubeconfig secret
+	// the client was created from.
+	secretResourceVersion string
+	client                clientset.Interface
+	reachable             bool
+	// nodes maps the node names to their readiness.
+	nodes map[string]bool
+}
+
+// NewWorkloadClusterNodeFactory returns a new WorkloadClusterNodeFactory which
+// uses the given client to get the kubeconfig secrets of the clusters.
+func NewWorkloadClusterNodeFactory(kubeClient clientset.Interface, syncInterval time.Duration) *WorkloadClusterNodeFactory {
+	return &WorkloadClusterNodeFactory{
+		SyncInterval: syncInterval,
+		kubeClient:   kubeClient,
+		newClient:    newWorkloadClusterClient,
+		concurrency:  workloadClusterConcurrency,
+		clusters:     map[string]*workloadCluster{},
+	}
+}
+
+func (f *WorkloadClusterNodeFactory) Name() string {
+	return "workloadclusternodes"
+}
+
+func (f *WorkloadClusterNodeFactory) Resources() []string {
+	return []string{"clusters", "machines"}
+}
+
+func (f *WorkloadClusterNodeFactory) MetricFamilyGenerators(allowAnnotationsList, allowLabelsList []string) []generator.FamilyGenerator {
+	return []generator.FamilyGenerator{
+		*generator.NewFamilyGenerator(
+			"capi_cluster_workload_cluster_reachable",
+			"The nodes of the workload cluster could be listed using the kubeconfig secret of the cluster.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				f.mtx.Lock()
+				defer f.mtx.Unlock()
+
+				ms := []*metric.Metric{}
+
+				for _, key := range f.clusterKeys() {
+					wc := f.clusters[key]
+					ms = append(ms, &metric.Metric{
+						LabelKeys:   descClusterLabelsDefaultLabels,
+						LabelValues: []string{wc.namespace, wc.name, string(wc.uid)},
+						Value:       boolFloat64(wc.reachable),
+					})
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+		*generator.NewFamilyGenerator(
+			"capi_cluster_node_without_machine",
+			"A node of the workload cluster which is not referenced by any machine of the cluster.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				f.mtx.Lock()
+				defer f.mtx.Unlock()
+
+				referenced := map[string]bool{}
+				for _, obj := range o.List("machines") {
+					m := obj.(*clusterv1.Machine)
+					if m.Status.NodeRef != nil {
+						referenced[m.Namespace+"/"+m.Spec.ClusterName+"/"+m.Status.NodeRef.Name] = true
+					}
+				}
+
+				ms := []*metric.Metric{}
+
+				for _, key := range f.clusterKeys() {
+					wc := f.clusters[key]
+					for _, node := range sortedKeys(wc.nodes) {
+						if referenced[key+"/"+node] {
+							continue
+						}
+						ms = append(ms, &metric.Metric{
+							LabelKeys:   []string{"namespace", "cluster", "uid", "node"},
+							LabelValues: []string{wc.namespace, wc.name, string(wc.uid), node},
+							Value:       1,
+						})
+					}
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+		*generator.NewFamilyGenerator(
+			"capi_machine_node_missing",
+			"The node referenced by the machine does not exist in the workload cluster.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				f.mtx.Lock()
+				defer f.mtx.Unlock()
+
+				ms := []*metric.Metric{}
+
+				for _, obj := range o.List("machines") {
+					m := obj.(*clusterv1.Machine)
+					wc, ok := f.clusters[m.Namespace+"/"+m.Spec.ClusterName]
+					if !ok || !wc.reachable || m.Status.NodeRef == nil {
+						continue
+					}
+					_, exists := wc.nodes[m.Status.NodeRef.Name]
+					ms = append(ms, &metric.Metric{
+						LabelKeys:   append(append([]string{}, descMachineLabelsDefaultLabels...), "cluster", "node"),
+						LabelValues: []string{m.Namespace, m.Name, string(m.UID), m.Spec.ClusterName, m.Status.NodeRef.Name},
+						Value:       boolFloat64(!exists),
+					})
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+		*generator.NewFamilyGenerator(
+			"capi_machine_node_ready",
+			"The node referenced by the machine has the Ready condition in the workload cluster.",
+			metric.Gauge,
+			"",
+			wrapObjectsFunc(func(o Objects) *metric.Family {
+				f.mtx.Lock()
+				defer f.mtx.Unlock()
+
+				ms := []*metric.Metric{}
+
+				for _, obj := range o.List("machines") {
+					m := obj.(*clusterv1.Machine)
+					wc, ok := f.clusters[m.Namespace+"/"+m.Spec.ClusterName]
+					if !ok || !wc.reachable || m.Status.NodeRef == nil {
+						continue
+					}
+					ready, exists := wc.nodes[m.Status.NodeRef.Name]
+					if !exists {
+						continue
+					}
+					ms = append(ms, &metric.Metric{
+						LabelKeys:   append(append([]string{}, descMachineLabelsDefaultLabels...), "cluster", "node"),
+						LabelValues: []string{m.Namespace, m.Name, string(m.UID), m.Spec.ClusterName, m.Status.NodeRef.Name},
+						Value:       boolFloat64(ready),
+					})
+				}
+
+				return &metric.Family{
+					Metrics: ms,
+				}
+			}),
+		),
+	}
+}
+
+// Run lists the nodes of all workload clusters every SyncInterval until the
+// context is done.
+func (f *WorkloadClusterNodeFactory) Run(ctx context.Context, o Objects) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		f.sync(ctx, o)
+	}, f.SyncInterval)
+}
+
+// sync lists the nodes of all clusters whose control plane is initialized.
+// The clusters are synced concurrently and the result of each cluster is
+// stored as soon as it is known, so unreachable clusters do not delay the
+// others. Clusters which no longer exist are dropped.
+func (f *WorkloadClusterNodeFactory) sync(ctx context.Context, o Objects) {
+	f.mtx.Lock()
+	previous := make(map[string]*workloadCluster, len(f.clusters))
+	for key, wc := range f.clusters {
+		previous[key] = wc
+	}
+	f.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, f.concurrency)
+	clusters := map[string]bool{}
+	for _, obj := range o.List("clusters") {
+		c := obj.(*clusterv1.Cluster)
+		if !hasTrueCondition(c.Status.Conditions, clusterv1.ControlPlaneInitializedCondition) {
+			continue
+		}
+		key := c.Namespace + "/" + c.Name
+		clusters[key] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-workers }()
+
+			wc := f.syncCluster(ctx, c, previous[key])
+			f.mtx.Lock()
+			f.clusters[key] = wc
+			f.mtx.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	f.mtx.Lock()
+	for key := range f.clusters {
+		if !clusters[key] {
+			delete(f.clusters, key)
+		}
+	}
+	f.mtx.Unlock()
+}
+
+// syncCluster lists the nodes of a single workload cluster. The client of the
+// previous sync is reused as long as the kubeconfig secret did not change.
+func (f *WorkloadClusterNodeFactory) syncCluster(ctx context.Context, c *clusterv1.Cluster, previous *workloadCluster) *workloadCluster {
+	ctx, cancel := context.WithTimeout(ctx, workloadClusterTimeout)
+	defer cancel()
+
+	wc := &workloadCluster{
+		namespace: c.Namespace,
+		name:      c.Name,
+		uid:       c.UID,
+	}
+
+	s, err := f.kubeClient.CoreV1().Secrets(c.Namespace).Get(ctx, secret.Name(c.Name, secret.Kubeconfig), metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("Failed to get kubeconfig secret of cluster %s/%s: %v", c.Namespace, c.Name, err)
+		return wc
+	}
+
+	if previous != nil && previous.client != nil && previous.secretResourceVersion == s.ResourceVersion {
+		wc.client = previous.client
+	} else {
+		wc.client, err = f.newClient(s.Data[secret.KubeconfigDataName])
+		if err != nil {
+			klog.Errorf("Failed to create client for cluster %s/%s: %v", c.Namespace, c.Name, err)
+			return wc
+		}
+	}
+	wc.secretResourceVersion = s.ResourceVersion
+
+	nodes, err := wc.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list nodes of cluster %s/%s: %v", c.Namespace, c.Name, err)
+		return wc
+	}
+
+	wc.reachable = true
+	wc.nodes = make(map[string]bool, len(nodes.Items))
+	for _, n := range nodes.Items {
+		wc.nodes[n.Name] = nodeReady(n)
+	}
+
+	return wc
+}
+
+// clusterKeys returns the sorted keys of all synced clusters. The caller must
+// hold the lock.
+func (f *WorkloadClusterNodeFactory) clusterKeys() []string {
+	keys := make([]string, 0, len(f.clusters))
+	for key := range f.clusters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newWorkloadClusterClient(kubeconfig []byte) (clientset.Interface, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = workloadClusterTimeout
+	return clientset.NewForConfig(config)
+}
+
+func nodeReady(n corev1.Node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func hasTrueCondition(conditions clusterv1.Conditions, t clusterv1.ConditionType) bool {
+	for _, c := range conditions {
+		if c.Type == t {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	generator "k8s.io/kube-state-metrics/v2/pkg/metric_generator"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestWorkloadClusterNodeStore(t *testing.T) {
+	newCluster := func(name string, initialized bool) *clusterv1.Cluster {
+		status := corev1.ConditionFalse
+		if initialized {
+			status = corev1.ConditionTrue
+		}
+		return &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns1",
+				UID:       types.UID(name),
+			},
+			Status: clusterv1.ClusterStatus{
+				Conditions: clusterv1.Conditions{
+					{Type: clusterv1.ControlPlaneInitializedCondition, Status: status},
+				},
+			},
+		}
+	}
+	newKubeconfigSecret := func(cluster string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            cluster + "-kubeconfig",
+				Namespace:       "ns1",
+				ResourceVersion: "1",
+			},
+			Data: map[string][]byte{
+				"value": []byte(cluster),
+			},
+		}
+	}
+	newNode := func(name string, ready corev1.ConditionStatus) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{
+					{Type: corev1.NodeReady, Status: ready},
+				},
+			},
+		}
+	}
+	newMachine := func(name, cluster, node string) *clusterv1.Machine {
+		m := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns1",
+				UID:       types.UID(name),
+			},
+			Spec: clusterv1.MachineSpec{
+				ClusterName: cluster,
+			},
+		}
+		if node != "" {
+			m.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: node}
+		}
+		return m
+	}
+
+	// The kubeconfig of the fake secrets is the name of the cluster.
+	workloadClients := map[string]clientset.Interface{
+		"c1": fake.NewSimpleClientset(
+			newNode("node1", corev1.ConditionTrue),
+			newNode("node2", corev1.ConditionUnknown),
+			newNode("node3", corev1.ConditionTrue),
+		),
+	}
+	var mtx sync.Mutex
+	clientsCreated := 0
+
+	f := NewWorkloadClusterNodeFactory(fake.NewSimpleClientset(newKubeconfigSecret("c1"), newKubeconfigSecret("c3")), 0)
+	f.newClient = func(kubeconfig []byte) (clientset.Interface, error) {
+		mtx.Lock()
+		clientsCreated++
+		mtx.Unlock()
+		c, ok := workloadClients[string(kubeconfig)]
+		if !ok {
+			return nil, fmt.Errorf("invalid kubeconfig")
+		}
+		return c, nil
+	}
+
+	objects := newObjects(map[string][]interface{}{
+		"clusters": {
+			newCluster("c1", true),
+			newCluster("c2", false),
+			newCluster("c3", true),
+			newCluster("c4", true),
+		},
+		"machines": {
+			newMachine("m1", "c1", "node1"),
+			newMachine("m2", "c1", "node2"),
+			newMachine("m3", "c1", "node4"),
+			newMachine("m4", "c1", ""),
+			newMachine("m5", "c3", "node1"),
+		},
+	})
+
+	f.sync(context.Background(), objects)
+	f.sync(context.Background(), objects)
+
+	// The client of c1 is reused, the client of c3 fails to be created on every sync.
+	if clientsCreated != 3 {
+		t.Errorf("expected 3 clients to be created, got %d", clientsCreated)
+	}
+
+	tc := generateMetricsTestCase{
+		Obj: objects,
+		Want: `
+			# HELP capi_cluster_node_without_machine A node of the workload cluster which is not referenced by any machine of the cluster.
+			# HELP capi_cluster_workload_cluster_reachable The nodes of the workload cluster could be listed using the kubeconfig secret of the cluster.
+			# HELP capi_machine_node_missing The node referenced by the machine does not exist in the workload cluster.
+			# HELP capi_machine_node_ready The node referenced by the machine has the Ready condition in the workload cluster.
+			# TYPE capi_cluster_node_without_machine gauge
+			# TYPE capi_cluster_workload_cluster_reachable gauge
+			# TYPE capi_machine_node_missing gauge
+			# TYPE capi_machine_node_ready gauge
+			capi_cluster_node_without_machine{cluster="c1",namespace="ns1",node="node3",uid="c1"} 1
+			capi_cluster_workload_cluster_reachable{cluster="c1",namespace="ns1",uid="c1"} 1
+			capi_cluster_workload_cluster_reachable{cluster="c3",namespace="ns1",uid="c3"} 0
+			capi_cluster_workload_cluster_reachable{cluster="c4",namespace="ns1",uid="c4"} 0
+			capi_machine_node_missing{cluster="c1",machine="m1",namespace="ns1",node="node1",uid="m1"} 0
+			capi_machine_node_missing{cluster="c1",machine="m2",namespace="ns1",node="node2",uid="m2"} 0
+			capi_machine_node_missing{cluster="c1",machine="m3",namespace="ns1",node="node4",uid="m3"} 1
+			capi_machine_node_ready{cluster="c1",machine="m1",namespace="ns1",node="node1",uid="m1"} 1
+			capi_machine_node_ready{cluster="c1",machine="m2",namespace="ns1",node="node2",uid="m2"} 0
+		`,
+		Func:    generator.ComposeMetricGenFuncs(f.MetricFamilyGenerators(nil, nil)),
+		Headers: generator.ExtractMetricFamilyHeaders(f.MetricFamilyGenerators(nil, nil)),
+	}
+	if err := tc.run(); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+func TestWorkloadClusterNodeSyncDoesNotWaitForUnreachableClusters(t *testing.T) {
+	newCluster := func(name string) *clusterv1.Cluster {
+		return &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1", UID: types.UID(name)},
+			Status: clusterv1.ClusterStatus{
+				Conditions: clusterv1.Conditions{
+					{Type: clusterv1.ControlPlaneInitializedCondition, Status: corev1.ConditionTrue},
+				},
+			},
+		}
+	}
+	newKubeconfigSecret := func(cluster string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: cluster + "-kubeconfig", Namespace: "ns1"},
+			Data:       map[string][]byte{"value": []byte(cluster)},
+		}
+	}
+
+	// Listing the nodes of the unreachable cluster blocks until the test ends.
+	release := make(chan struct{})
+	unreachable := fake.NewSimpleClientset()
+	unreachable.PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		<-release
+		return true, nil, fmt.Errorf("connection timed out")
+	})
+	workloadClients := map[string]clientset.Interface{
+		"c1": unreachable,
+		"c2": fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}),
+	}
+
+	f := NewWorkloadClusterNodeFactory(fake.NewSimpleClientset(newKubeconfigSecret("c1"), newKubeconfigSecret("c2")), 0)
+	f.concurrency = 2
+	f.newClient = func(kubeconfig []byte) (clientset.Interface, error) {
+		return workloadClients[string(kubeconfig)], nil
+	}
+	objects := newObjects(map[string][]interface{}{
+		"clusters": {newCluster("c1"), newCluster("c2")},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		f.sync(context.Background(), objects)
+		close(done)
+	}()
+
+	reachable := func() bool {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		wc, ok := f.clusters["ns1/c2"]
+		return ok && wc.reachable
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !reachable() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the reachable cluster to be synced while the unreachable cluster is listed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+	<-done
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if wc := f.clusters["ns1/c1"]; wc == nil || wc.reachable {
+		t.Errorf("expected the unreachable cluster to be synced as not reachable, got %v", wc)
+	}
+}